@@ -0,0 +1,161 @@
+//go:build unix
+
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"math"
+	"time"
+)
+
+const (
+	// ifaFlagNoDAD is IFA_F_NODAD, which skips duplicate address detection.
+	ifaFlagNoDAD = 0x02
+	// lifetimeInfinite is INFINITY_LIFE_TIME once truncated to the 32-bit netlink field.
+	lifetimeInfinite = -1
+)
+
+// addAddress assigns an IPv4 or IPv6 address to the interface, in addition to any existing ones.
+func (a *SwiftInterface) addAddress(addr *swiftypes.Address) error {
+	link, err := a.addressLink()
+	if err != nil {
+		return err
+	}
+
+	nlAddr, err := toNetlinkAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	if err := a.handle().AddrAdd(link, nlAddr); err != nil {
+		return fmt.Errorf("failed to add address %v: %w", addr, err)
+	}
+
+	return nil
+}
+
+// removeAddress removes an address previously assigned to the interface.
+func (a *SwiftInterface) removeAddress(addr *swiftypes.Address) error {
+	link, err := a.addressLink()
+	if err != nil {
+		return err
+	}
+
+	if addr == nil || addr.IPNet == nil {
+		return errors.New("address cannot be nil")
+	}
+
+	if err := a.handle().AddrDel(link, &netlink.Addr{IPNet: addr.IPNet, Peer: addr.Peer}); err != nil {
+		return fmt.Errorf("failed to remove address %v: %w", addr, err)
+	}
+
+	return nil
+}
+
+// Addresses lists the IPv4 and IPv6 addresses currently assigned to the interface.
+func (a *SwiftInterface) Addresses() ([]swiftypes.Address, error) {
+	link, err := a.addressLink()
+	if err != nil {
+		return nil, err
+	}
+
+	nlAddrs, err := a.handle().AddrList(link, unix.AF_UNSPEC)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list addresses: %w", err)
+	}
+
+	addrs := make([]swiftypes.Address, 0, len(nlAddrs))
+	for _, nlAddr := range nlAddrs {
+		addrs = append(addrs, fromNetlinkAddr(&nlAddr))
+	}
+
+	return addrs, nil
+}
+
+// addressLink resolves the netlink link of the interface.
+func (a *SwiftInterface) addressLink() (netlink.Link, error) {
+	index, err := a.GetAdapterIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := a.handle().LinkByIndex(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface: %w", err)
+	}
+
+	return link, nil
+}
+
+// toNetlinkAddr converts an Address into its netlink representation.
+func toNetlinkAddr(addr *swiftypes.Address) (*netlink.Addr, error) {
+	if addr == nil || addr.IPNet == nil {
+		return nil, errors.New("address cannot be nil")
+	}
+
+	if addr.PreferredLifetime < 0 || addr.ValidLifetime < 0 {
+		return nil, errors.New("address lifetimes cannot be negative")
+	}
+
+	if addr.ValidLifetime > 0 && addr.PreferredLifetime > addr.ValidLifetime {
+		return nil, errors.New("preferred lifetime cannot exceed valid lifetime")
+	}
+
+	nlAddr := &netlink.Addr{
+		IPNet: addr.IPNet,
+		Peer:  addr.Peer,
+	}
+
+	if addr.NoDAD {
+		nlAddr.Flags |= ifaFlagNoDAD
+	}
+
+	if addr.PreferredLifetime > 0 || addr.ValidLifetime > 0 {
+		nlAddr.ValidLft = lifetimeSeconds(addr.ValidLifetime)
+		nlAddr.PreferedLft = nlAddr.ValidLft
+
+		if addr.PreferredLifetime > 0 {
+			nlAddr.PreferedLft = lifetimeSeconds(addr.PreferredLifetime)
+		}
+	}
+
+	return nlAddr, nil
+}
+
+// fromNetlinkAddr converts a netlink address into an Address.
+func fromNetlinkAddr(nlAddr *netlink.Addr) swiftypes.Address {
+	return swiftypes.Address{
+		IPNet:             nlAddr.IPNet,
+		Peer:              nlAddr.Peer,
+		NoDAD:             nlAddr.Flags&ifaFlagNoDAD != 0,
+		PreferredLifetime: lifetimeDuration(nlAddr.PreferedLft),
+		ValidLifetime:     lifetimeDuration(nlAddr.ValidLft),
+	}
+}
+
+// lifetimeSeconds converts a lifetime into whole seconds, with zero meaning forever.
+func lifetimeSeconds(d time.Duration) int {
+	if d <= 0 {
+		return lifetimeInfinite
+	}
+
+	seconds := (d + time.Second - 1) / time.Second
+	if seconds >= math.MaxUint32 {
+		return lifetimeInfinite
+	}
+
+	return int(seconds)
+}
+
+// lifetimeDuration converts a lifetime reported by netlink into a duration, with forever mapped to zero.
+func lifetimeDuration(seconds int) time.Duration {
+	if seconds == 0 || uint32(seconds) == math.MaxUint32 {
+		return 0
+	}
+
+	return time.Duration(uint32(seconds)) * time.Second
+}
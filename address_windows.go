@@ -0,0 +1,181 @@
+//go:build windows
+
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"golang.org/x/sys/windows"
+	"math"
+	"net"
+	"time"
+	"unsafe"
+)
+
+var (
+	procDeleteUnicastIpAddressEntry = iphlpapi.NewProc("DeleteUnicastIpAddressEntry")
+	procGetUnicastIpAddressTable    = iphlpapi.NewProc("GetUnicastIpAddressTable")
+)
+
+// mibUnicastIpAddressTable mirrors MIB_UNICASTIPADDRESS_TABLE.
+type mibUnicastIpAddressTable struct {
+	NumEntries uint32
+	Table      [1]windows.MibUnicastIpAddressRow
+}
+
+// addAddress assigns an IPv4 or IPv6 address to the interface, in addition to any existing ones.
+// Peer addresses are not supported on Windows.
+func (a *SwiftInterface) addAddress(addr *swiftypes.Address) error {
+	row, err := a.addressToRow(addr)
+	if err != nil {
+		return err
+	}
+
+	if addr.Peer != nil {
+		return errors.New("peer addresses are not supported on Windows")
+	}
+
+	if addr.ValidLifetime > 0 && addr.PreferredLifetime > addr.ValidLifetime {
+		return errors.New("preferred lifetime cannot exceed valid lifetime")
+	}
+
+	row.ValidLifetime = lifetimeSeconds(addr.ValidLifetime)
+	row.PreferredLifetime = row.ValidLifetime
+	if addr.PreferredLifetime > 0 {
+		row.PreferredLifetime = lifetimeSeconds(addr.PreferredLifetime)
+	}
+
+	if addr.NoDAD {
+		row.DadState = windows.IpDadStatePreferred
+	}
+
+	ret, _, _ := procCreateUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(row)))
+	if errno := windows.Errno(ret); !errors.Is(errno, windows.ERROR_SUCCESS) {
+		return fmt.Errorf("failed to add address %v: %w", addr, errno)
+	}
+
+	return nil
+}
+
+// removeAddress removes an address previously assigned to the interface.
+func (a *SwiftInterface) removeAddress(addr *swiftypes.Address) error {
+	row, err := a.addressToRow(addr)
+	if err != nil {
+		return err
+	}
+
+	ret, _, _ := procDeleteUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(row)))
+	if errno := windows.Errno(ret); !errors.Is(errno, windows.ERROR_SUCCESS) {
+		return fmt.Errorf("failed to remove address %v: %w", addr, errno)
+	}
+
+	return nil
+}
+
+// Addresses lists the IPv4 and IPv6 addresses currently assigned to the interface.
+func (a *SwiftInterface) Addresses() ([]swiftypes.Address, error) {
+	luid, err := a.GetAdapterLUID()
+	if err != nil {
+		return nil, err
+	}
+
+	var table *mibUnicastIpAddressTable
+
+	ret, _, _ := procGetUnicastIpAddressTable.Call(
+		uintptr(windows.AF_UNSPEC),
+		uintptr(unsafe.Pointer(&table)),
+	)
+	if errno := windows.Errno(ret); !errors.Is(errno, windows.ERROR_SUCCESS) {
+		return nil, fmt.Errorf("failed to get address table: %w", errno)
+	}
+	defer procFreeMibTable.Call(uintptr(unsafe.Pointer(table)))
+
+	addrs := []swiftypes.Address{}
+	if table.NumEntries == 0 {
+		return addrs, nil
+	}
+
+	for _, row := range unsafe.Slice(&table.Table[0], table.NumEntries) {
+		if row.InterfaceLuid != luid.ToUint64() {
+			continue
+		}
+
+		ip, family := extractIP((*windows.RawSockaddrInet)(unsafe.Pointer(&row.Address)))
+		if ip == nil {
+			continue
+		}
+
+		bits := 128
+		if family == windows.AF_INET {
+			bits = 32
+		}
+
+		addrs = append(addrs, swiftypes.Address{
+			IPNet:             &net.IPNet{IP: ip, Mask: net.CIDRMask(int(row.OnLinkPrefixLength), bits)},
+			PreferredLifetime: lifetimeDuration(row.PreferredLifetime),
+			ValidLifetime:     lifetimeDuration(row.ValidLifetime),
+		})
+	}
+
+	return addrs, nil
+}
+
+// addressToRow builds the unicast address row identifying addr on the interface.
+func (a *SwiftInterface) addressToRow(addr *swiftypes.Address) (*windows.MibUnicastIpAddressRow, error) {
+	if addr == nil || addr.IPNet == nil {
+		return nil, errors.New("address cannot be nil")
+	}
+
+	if addr.PreferredLifetime < 0 || addr.ValidLifetime < 0 {
+		return nil, errors.New("address lifetimes cannot be negative")
+	}
+
+	luid, err := a.GetAdapterLUID()
+	if err != nil {
+		return nil, err
+	}
+
+	var row windows.MibUnicastIpAddressRow
+
+	_, _, _ = procInitializeUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(&row)))
+
+	if ipv4 := addr.IPNet.IP.To4(); ipv4 != nil {
+		ipv4Row := (*windows.RawSockaddrInet4)(unsafe.Pointer(&row.Address))
+		ipv4Row.Family = windows.AF_INET
+		copy(ipv4Row.Addr[:], ipv4)
+	} else if ipv6 := addr.IPNet.IP.To16(); ipv6 != nil {
+		row.Address.Family = windows.AF_INET6
+		copy(row.Address.Addr[:], ipv6)
+	} else {
+		return nil, fmt.Errorf("invalid IP address: %s", addr.IPNet.IP)
+	}
+
+	ones, _ := addr.IPNet.Mask.Size()
+
+	row.InterfaceLuid = luid.ToUint64()
+	row.OnLinkPrefixLength = uint8(ones)
+	row.PrefixOrigin = IpPrefixOriginManual
+	row.SuffixOrigin = IpSuffixOriginManual
+
+	return &row, nil
+}
+
+// lifetimeSeconds converts a lifetime into whole seconds, with zero meaning forever.
+func lifetimeSeconds(d time.Duration) uint32 {
+	seconds := (d + time.Second - 1) / time.Second
+	if d <= 0 || seconds >= math.MaxUint32 {
+		return math.MaxUint32
+	}
+
+	return uint32(seconds)
+}
+
+// lifetimeDuration converts a lifetime reported by Windows into a duration, with forever mapped to zero.
+func lifetimeDuration(seconds uint32) time.Duration {
+	if seconds == math.MaxUint32 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
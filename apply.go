@@ -0,0 +1,293 @@
+package swiftunnel
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"github.com/vishvananda/netlink"
+	"net"
+	"slices"
+)
+
+// routeProtocolSystem is the protocol of routes the system derives from addresses: RTPROT_KERNEL on Linux and
+// MIB_IPPROTO_LOCAL on Windows. Apply never removes them.
+const routeProtocolSystem = 2
+
+// InterfaceState is the desired configuration of a SwiftInterface, reconciled by Apply.
+// Nil fields and a zero MTU leave the corresponding setting untouched; an empty, non-nil slice removes every
+// address or route the interface has, except link-local addresses and system-generated routes. Routes are reconciled
+// in the main table and in the tables named by Routes.
+type InterfaceState struct {
+	Addresses []swiftypes.Address
+	Routes    []netlink.Route
+	MTU       int
+	Status    *swiftypes.InterfaceStatus
+	DNS       *swiftypes.DNSConfig
+}
+
+// ApplyReport lists the changes made by Apply.
+type ApplyReport struct {
+	AddedAddresses   []swiftypes.Address
+	RemovedAddresses []swiftypes.Address
+	AddedRoutes      []netlink.Route
+	RemovedRoutes    []netlink.Route
+	MTUChanged       bool
+	StatusChanged    bool
+	DNSChanged       bool
+}
+
+// Changed reports whether Apply modified anything.
+func (r *ApplyReport) Changed() bool {
+	return len(r.AddedAddresses) > 0 || len(r.RemovedAddresses) > 0 ||
+		len(r.AddedRoutes) > 0 || len(r.RemovedRoutes) > 0 ||
+		r.MTUChanged || r.StatusChanged || r.DNSChanged
+}
+
+// Apply compares state with the configuration reported by the system and applies only the differences.
+// Applying the same state twice is a no-op. On error the returned report lists the changes made so far.
+func (a *SwiftInterface) Apply(state *InterfaceState) (*ApplyReport, error) {
+	report := &ApplyReport{}
+
+	if state == nil {
+		return report, nil
+	}
+
+	mtu, up, err := a.linkState()
+	if err != nil {
+		return report, fmt.Errorf("failed to read link state: %w", err)
+	}
+
+	if state.MTU > 0 && state.MTU != mtu {
+		if err := a.SetMTU(state.MTU); err != nil {
+			return report, err
+		}
+		report.MTUChanged = true
+	}
+
+	if state.Addresses != nil {
+		if err := a.applyAddresses(state.Addresses, report); err != nil {
+			return report, err
+		}
+	}
+
+	if state.Status != nil && *state.Status == swiftypes.InterfaceUp && !up {
+		if err := a.SetStatus(swiftypes.InterfaceUp); err != nil {
+			return report, fmt.Errorf("failed to set interface up: %w", err)
+		}
+		report.StatusChanged = true
+	}
+
+	if state.Routes != nil {
+		if err := a.applyRoutes(state.Routes, report); err != nil {
+			return report, err
+		}
+	}
+
+	if state.DNS != nil {
+		current, err := a.activeDNS()
+		if err != nil {
+			return report, fmt.Errorf("failed to read DNS configuration: %w", err)
+		}
+
+		if current == nil || !dnsConfigEqual(current, state.DNS) {
+			if err := a.SetDNS(state.DNS); err != nil {
+				return report, err
+			}
+			report.DNSChanged = true
+		}
+	}
+
+	if state.Status != nil && *state.Status == swiftypes.InterfaceDown && up {
+		if err := a.SetStatus(swiftypes.InterfaceDown); err != nil {
+			return report, fmt.Errorf("failed to set interface down: %w", err)
+		}
+		report.StatusChanged = true
+	}
+
+	return report, nil
+}
+
+// applyAddresses adds the addresses missing from the interface, then removes those absent from desired.
+// Adding first keeps the interface from briefly losing every IPv4 address, which would flush its routes.
+func (a *SwiftInterface) applyAddresses(desired []swiftypes.Address, report *ApplyReport) error {
+	current, err := a.addMissingAddresses(desired, report)
+	if err != nil {
+		return err
+	}
+
+	removed := false
+
+	for _, addr := range current {
+		if addr.IPNet == nil || addr.IPNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if slices.ContainsFunc(desired, func(want swiftypes.Address) bool { return addressEqual(&want, &addr) }) {
+			continue
+		}
+
+		if err := a.RemoveAddress(&addr); err != nil {
+			return err
+		}
+		report.RemovedAddresses = append(report.RemovedAddresses, addr)
+		removed = true
+	}
+
+	if removed {
+		// Linux drops secondary addresses together with the primary one of their subnet, so restore them.
+		_, err = a.addMissingAddresses(desired, report)
+	}
+
+	return err
+}
+
+// addMissingAddresses adds every desired address the interface lacks and returns the addresses it had before.
+func (a *SwiftInterface) addMissingAddresses(desired []swiftypes.Address, report *ApplyReport) ([]swiftypes.Address, error) {
+	current, err := a.Addresses()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, want := range desired {
+		if slices.ContainsFunc(current, func(addr swiftypes.Address) bool { return addressEqual(&want, &addr) }) {
+			continue
+		}
+
+		if err := a.AddAddress(&want); err != nil {
+			return nil, err
+		}
+
+		if !slices.ContainsFunc(report.AddedAddresses, func(addr swiftypes.Address) bool { return addressEqual(&want, &addr) }) {
+			report.AddedAddresses = append(report.AddedAddresses, want)
+		}
+	}
+
+	return current, nil
+}
+
+// applyRoutes removes routes missing from desired, then adds the new ones.
+func (a *SwiftInterface) applyRoutes(desired []netlink.Route, report *ApplyReport) error {
+	current, err := a.currentRoutes(desired)
+	if err != nil {
+		return fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	for _, route := range current {
+		if route.Protocol == routeProtocolSystem {
+			continue
+		}
+
+		if slices.ContainsFunc(desired, func(want netlink.Route) bool { return routeMatches(&want, &route) }) {
+			continue
+		}
+
+		if err := a.RemoveRoute(&route); err != nil {
+			return err
+		}
+		report.RemovedRoutes = append(report.RemovedRoutes, route)
+	}
+
+	for _, want := range desired {
+		if slices.ContainsFunc(current, func(route netlink.Route) bool {
+			return route.Protocol != routeProtocolSystem && routeMatches(&want, &route)
+		}) {
+			continue
+		}
+
+		if err := a.AddRoute(&want); err != nil {
+			return err
+		}
+		report.AddedRoutes = append(report.AddedRoutes, want)
+	}
+
+	return nil
+}
+
+// currentRoutes lists the routes of the interface in the main table and in every table named by a desired route.
+func (a *SwiftInterface) currentRoutes(desired []netlink.Route) ([]netlink.Route, error) {
+	tables := []int{0}
+	for _, route := range desired {
+		if !slices.Contains(tables, route.Table) {
+			tables = append(tables, route.Table)
+		}
+	}
+
+	var current []netlink.Route
+	for _, table := range tables {
+		routes, err := a.tableRoutes(table)
+		if err != nil {
+			return nil, err
+		}
+
+		// The main table may be named explicitly, and Windows has a single table, so skip routes listed twice.
+		for _, route := range routes {
+			if !slices.ContainsFunc(current, route.Equal) {
+				current = append(current, route)
+			}
+		}
+	}
+
+	return current, nil
+}
+
+// addressEqual reports whether two addresses share the same IP, prefix length and peer. Lifetimes are ignored as
+// the system counts them down.
+func addressEqual(want, got *swiftypes.Address) bool {
+	return ipNetString(want.IPNet) == ipNetString(got.IPNet) && ipNetString(want.Peer) == ipNetString(got.Peer)
+}
+
+// routeMatches reports whether got satisfies the desired route. The metric and table only count when set in want.
+func routeMatches(want, got *netlink.Route) bool {
+	if routeDestination(want) != routeDestination(got) || !want.Gw.Equal(got.Gw) {
+		return false
+	}
+
+	if want.Priority != 0 && want.Priority != got.Priority {
+		return false
+	}
+
+	return want.Table == 0 || want.Table == got.Table
+}
+
+// routeDestination returns the canonical destination prefix of a route; a nil Dst is a default route.
+func routeDestination(route *netlink.Route) string {
+	if route.Dst != nil {
+		return ipNetString(route.Dst)
+	}
+
+	if route.Gw != nil && route.Gw.To4() == nil {
+		return "::/0"
+	}
+
+	return "0.0.0.0/0"
+}
+
+// ipNetString formats a prefix with IPv4 addresses in their 4-byte form, or returns "" for nil.
+func ipNetString(ipNet *net.IPNet) string {
+	if ipNet == nil {
+		return ""
+	}
+
+	ip, mask := ipNet.IP, ipNet.Mask
+	if ipv4 := ip.To4(); ipv4 != nil && len(mask) == net.IPv6len {
+		ip, mask = ipv4, mask[12:]
+	}
+
+	return (&net.IPNet{IP: ip, Mask: mask}).String()
+}
+
+// dnsConfigEqual reports whether two DNS configurations list the same domain and upstreams, and the same servers
+// when neither has upstreams, in the same order.
+func dnsConfigEqual(a, b *swiftypes.DNSConfig) bool {
+	if a.Domain != b.Domain || !slices.EqualFunc(a.Upstreams, b.Upstreams, dnsUpstreamEqual) {
+		return false
+	}
+
+	return len(a.Upstreams) > 0 || slices.EqualFunc(a.DnsServers, b.DnsServers, net.IP.Equal)
+}
+
+// dnsUpstreamEqual reports whether two encrypted upstreams share the same URL, bootstrap addresses and pins.
+func dnsUpstreamEqual(a, b swiftypes.DNSUpstream) bool {
+	return a.URL == b.URL && slices.EqualFunc(a.Bootstrap, b.Bootstrap, net.IP.Equal) &&
+		slices.EqualFunc(a.Pins, b.Pins, bytes.Equal)
+}
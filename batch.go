@@ -0,0 +1,40 @@
+package swiftunnel
+
+import (
+	"errors"
+)
+
+var (
+	ErrBatchSizeMismatch = errors.New("sizes must have at least as many entries as bufs")
+)
+
+// readBatch reads a single packet into bufs[0] for platforms that only hand over one packet per call.
+func readBatch(read func([]byte) (int, error), bufs [][]byte, sizes []int) (int, error) {
+	if len(sizes) < len(bufs) {
+		return 0, ErrBatchSizeMismatch
+	}
+
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+
+	n, err := read(bufs[0])
+	if err != nil {
+		return 0, err
+	}
+
+	sizes[0] = n
+
+	return 1, nil
+}
+
+// writeBatch writes each packet in turn, returning how many were written before the first error.
+func writeBatch(write func([]byte) (int, error), bufs [][]byte) (int, error) {
+	for i, buf := range bufs {
+		if _, err := write(buf); err != nil {
+			return i, err
+		}
+	}
+
+	return len(bufs), nil
+}
@@ -0,0 +1,28 @@
+//go:build darwin
+
+package swiftunnel
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"strings"
+)
+
+// bindSocket restricts the socket fd to the interface with IP_BOUND_IF or IPV6_BOUND_IF.
+func (a *SwiftInterface) bindSocket(network string, fd uintptr) error {
+	index, err := a.GetAdapterIndex()
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(network, "6") {
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, index)
+	} else {
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BOUND_IF, index)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bind socket to interface %d: %w", index, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,17 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+)
+
+// bindSocket restricts the socket fd to the interface with SO_BINDTODEVICE, whatever the routing table says.
+func (a *SwiftInterface) bindSocket(_ string, fd uintptr) error {
+	if err := unix.BindToDevice(int(fd), a.name); err != nil {
+		return fmt.Errorf("failed to bind socket to %s: %w", a.name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build windows
+
+package swiftunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"strings"
+)
+
+const (
+	ipUnicastIf   = 31
+	ipv6UnicastIf = 31
+)
+
+// bindSocket restricts the socket fd to the interface with IP_UNICAST_IF or IPV6_UNICAST_IF.
+func (a *SwiftInterface) bindSocket(network string, fd uintptr) error {
+	index, err := a.GetAdapterIndex()
+	if err != nil {
+		return err
+	}
+
+	if strings.HasSuffix(network, "6") {
+		err = windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IPV6, ipv6UnicastIf, index)
+	} else {
+		// The IPv4 option takes the index in network byte order.
+		var be [4]byte
+		binary.BigEndian.PutUint32(be[:], uint32(index))
+		err = windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IP, ipUnicastIf, int(binary.NativeEndian.Uint32(be[:])))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to bind socket to interface %d: %w", index, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,326 @@
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"github.com/vishvananda/netlink"
+	"slices"
+	"sync"
+)
+
+const (
+	// dnsChangeKey identifies the DNS entry of a changeLog.
+	dnsChangeKey = "dns"
+	// routeTableMain is RT_TABLE_MAIN, which route keys treat the same as an unset table.
+	routeTableMain = 254
+)
+
+// changeLog records the system changes made through a SwiftInterface so that Close can revert them.
+type changeLog struct {
+	mu      sync.Mutex
+	entries []change
+}
+
+// change is a single recorded modification and the function undoing it.
+type change struct {
+	key  string
+	undo func() error
+}
+
+// record appends a change undone by undo.
+func (l *changeLog) record(key string, undo func() error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = append(l.entries, change{key: key, undo: undo})
+}
+
+// forget drops the most recent change recorded under key, reporting whether one was found.
+func (l *changeLog) forget(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if l.entries[i].key == key {
+			l.entries = append(l.entries[:i], l.entries[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// undo reverts and drops the most recent change recorded under key, doing nothing if there is none.
+func (l *changeLog) undo(key string) error {
+	l.mu.Lock()
+
+	for i := len(l.entries) - 1; i >= 0; i-- {
+		if l.entries[i].key == key {
+			entry := l.entries[i]
+			l.entries = append(l.entries[:i], l.entries[i+1:]...)
+			l.mu.Unlock()
+
+			return entry.undo()
+		}
+	}
+
+	l.mu.Unlock()
+
+	return nil
+}
+
+// has reports whether a change is recorded under key.
+func (l *changeLog) has(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, entry := range l.entries {
+		if entry.key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clear drops every recorded change without undoing it.
+func (l *changeLog) clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.entries = nil
+}
+
+// revert undoes every recorded change in reverse order and empties the log.
+func (l *changeLog) revert() error {
+	l.mu.Lock()
+	entries := l.entries
+	l.entries = nil
+	l.mu.Unlock()
+
+	var errs []error
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := entries[i].undo(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to revert %s: %w", entries[i].key, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// KeepChanges forgets every recorded address, route and DNS change, so that Close leaves them in place.
+// Use it when the configuration must outlive the SwiftInterface, e.g. after handing the device to another process.
+func (a *SwiftInterface) KeepChanges() {
+	a.changes.clear()
+}
+
+// KeepRoute excludes a route added, replaced or changed through the interface from the rollback done by Close.
+func (a *SwiftInterface) KeepRoute(route *netlink.Route) {
+	a.changes.forget(routeKey(route))
+}
+
+// AddAddress assigns an IPv4 or IPv6 address to the interface, in addition to any existing ones.
+// The address is removed again on Close.
+func (a *SwiftInterface) AddAddress(addr *swiftypes.Address) error {
+	if err := a.addAddress(addr); err != nil {
+		return err
+	}
+
+	a.recordAddressAdded(addr)
+
+	return nil
+}
+
+// RemoveAddress removes an address from the interface. Addresses that were not added through the interface are
+// restored on Close.
+func (a *SwiftInterface) RemoveAddress(addr *swiftypes.Address) error {
+	if err := a.removeAddress(addr); err != nil {
+		return err
+	}
+
+	removed := *addr
+	if !a.changes.forget(addressKey(&removed)) {
+		a.changes.record(addressKey(&removed), func() error {
+			return a.addAddress(&removed)
+		})
+	}
+
+	return nil
+}
+
+// AddRoute adds a network route via the current interface. The route is removed again on Close unless kept with
+// KeepRoute.
+func (a *SwiftInterface) AddRoute(route *netlink.Route) error {
+	if err := a.addRoute(route); err != nil {
+		return err
+	}
+
+	a.recordRouteAdded(route)
+
+	return nil
+}
+
+// AppendRoute appends a network route via the current interface. The route is removed again on Close unless kept
+// with KeepRoute.
+func (a *SwiftInterface) AppendRoute(route *netlink.Route) error {
+	if err := a.appendRoute(route); err != nil {
+		return err
+	}
+
+	a.recordRouteAdded(route)
+
+	return nil
+}
+
+// RemoveRoute removes a network route via the current interface. Routes that were not added through the interface
+// are restored on Close.
+func (a *SwiftInterface) RemoveRoute(route *netlink.Route) error {
+	if err := a.removeRoute(route); err != nil {
+		return err
+	}
+
+	removed := *route
+	if !a.changes.forget(routeKey(&removed)) {
+		a.changes.record(routeKey(&removed), func() error {
+			return a.addRoute(&removed)
+		})
+	}
+
+	return nil
+}
+
+// ReplaceRoute replaces a network route via the current interface. The previous route to the same destination, if
+// any, is restored on Close.
+func (a *SwiftInterface) ReplaceRoute(route *netlink.Route) error {
+	previous := a.findRoute(route)
+
+	if err := a.replaceRoute(route); err != nil {
+		return err
+	}
+
+	a.recordRouteReplaced(route, previous)
+
+	return nil
+}
+
+// ChangeRoute changes an existing network route via the current interface. The original route is restored on Close.
+func (a *SwiftInterface) ChangeRoute(route *netlink.Route) error {
+	previous := a.findRoute(route)
+
+	if err := a.changeRoute(route); err != nil {
+		return err
+	}
+
+	a.recordRouteReplaced(route, previous)
+
+	return nil
+}
+
+// SetDNS configures DNS servers and search domains for the interface. When config lists encrypted Upstreams, a DNS
+// forwarder answering through them is started on the interface address and becomes its DNS server instead, as with
+// StartDNSForwarder. The original configuration is restored on Close.
+func (a *SwiftInterface) SetDNS(config *swiftypes.DNSConfig) error {
+	if config != nil && len(config.Upstreams) > 0 {
+		return a.setEncryptedDNS(config)
+	}
+
+	var restore func() error
+
+	if !a.changes.has(dnsChangeKey) {
+		var err error
+		if restore, err = a.dnsRestorer(); err != nil {
+			return err
+		}
+	}
+
+	if err := a.setDNS(config); err != nil {
+		return err
+	}
+
+	if restore != nil {
+		a.changes.record(dnsChangeKey, restore)
+	}
+
+	return nil
+}
+
+// recordAddressAdded records the removal of an address added through the interface. Addresses the system already
+// dropped, e.g. together with the primary address of their subnet, are skipped.
+func (a *SwiftInterface) recordAddressAdded(addr *swiftypes.Address) {
+	added := *addr
+	a.changes.record(addressKey(&added), func() error {
+		err := a.removeAddress(&added)
+		if err != nil && !a.hasAddress(&added) {
+			return nil
+		}
+		return err
+	})
+}
+
+// recordRouteAdded records the removal of a route added through the interface. Routes the system already flushed,
+// e.g. along with the last address of their family, are skipped.
+func (a *SwiftInterface) recordRouteAdded(route *netlink.Route) {
+	added := *route
+	a.changes.record(routeKey(&added), func() error {
+		err := a.removeRoute(&added)
+		if err != nil && a.findRoute(&added) == nil {
+			return nil
+		}
+		return err
+	})
+}
+
+// recordRouteReplaced records how to undo replacing previous, which may be nil, with route.
+func (a *SwiftInterface) recordRouteReplaced(route, previous *netlink.Route) {
+	if previous == nil || a.changes.forget(routeKey(previous)) {
+		a.recordRouteAdded(route)
+		return
+	}
+
+	a.changes.record(routeKey(route), func() error {
+		return a.replaceRoute(previous)
+	})
+}
+
+// findRoute returns the route of the interface with the same destination and table as route, or nil.
+func (a *SwiftInterface) findRoute(route *netlink.Route) *netlink.Route {
+	routes, err := a.tableRoutes(route.Table)
+	if err != nil {
+		return nil
+	}
+
+	for _, existing := range routes {
+		if routeDestination(&existing) == routeDestination(route) {
+			return &existing
+		}
+	}
+
+	return nil
+}
+
+// hasAddress reports whether addr is still assigned to the interface.
+func (a *SwiftInterface) hasAddress(addr *swiftypes.Address) bool {
+	addrs, err := a.Addresses()
+	if err != nil {
+		return true
+	}
+
+	return slices.ContainsFunc(addrs, func(got swiftypes.Address) bool { return addressEqual(addr, &got) })
+}
+
+// addressKey identifies an address in the change log.
+func addressKey(addr *swiftypes.Address) string {
+	return "address " + ipNetString(addr.IPNet) + " peer " + ipNetString(addr.Peer)
+}
+
+// routeKey identifies a route in the change log by destination, gateway and table.
+func routeKey(route *netlink.Route) string {
+	table := route.Table
+	if table == routeTableMain {
+		table = 0
+	}
+
+	return fmt.Sprintf("route %s via %v table %d", routeDestination(route), route.Gw, table)
+}
@@ -0,0 +1,50 @@
+package swiftunnel
+
+import (
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftconfig"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"github.com/vishvananda/netlink"
+)
+
+// configureAdapter applies the addresses, MTU, routes and DNS settings of config to a newly created interface.
+// The changes are recorded, so closing the interface after a failure reverts them.
+func configureAdapter(adapter *SwiftInterface, config *swiftconfig.Config) error {
+	if config.UnicastConfig != nil {
+		if err := adapter.SetUnicastIpAddressEntry(config.UnicastConfig); err != nil {
+			return fmt.Errorf("failed to set IP address: %w", err)
+		}
+	}
+
+	for _, addr := range config.Addresses {
+		if err := adapter.AddAddress(addr); err != nil {
+			return fmt.Errorf("failed to add address %v: %w", addr.IPNet, err)
+		}
+	}
+
+	if config.MTU > 0 {
+		if err := adapter.SetMTU(config.MTU); err != nil {
+			return fmt.Errorf("failed to set MTU: %w", err)
+		}
+	}
+
+	if len(config.Routes) > 0 {
+		if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+			return fmt.Errorf("failed to bring interface up: %w", err)
+		}
+	}
+
+	for _, route := range config.Routes {
+		if err := adapter.AddRoute(&netlink.Route{Dst: route}); err != nil {
+			return fmt.Errorf("failed to add route %v: %w", route, err)
+		}
+	}
+
+	if config.DNSConfig != nil {
+		if err := adapter.SetDNS(config.DNSConfig); err != nil {
+			return fmt.Errorf("failed to set DNS: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/SyNdicateFoundation/swiftunnel/swiftconfig"
 	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
 	"io"
 	"math"
@@ -21,6 +22,9 @@ type SwiftInterface struct {
 	*tunReadCloser
 	name        string
 	AdapterType swiftypes.AdapterType
+	changes     changeLog
+	// encryptedDNS is the configuration served by the forwarder setEncryptedDNS started, if it is running.
+	encryptedDNS *swiftypes.DNSConfig
 }
 
 const (
@@ -176,11 +180,26 @@ func setIfUp(fd uintptr, ifName string) error {
 }
 
 type tunReadCloser struct {
-	f *os.File
+	f        *os.File
+	counters ioCounters
 }
 
-// Read implements the io.Reader interface for the macOS tunnel, handling the 4-byte PI header.
+// Read implements the io.Reader interface for the macOS tunnel, counting each packet received.
 func (t *tunReadCloser) Read(to []byte) (int, error) {
+	n, err := t.read(to)
+	t.counters.countRead(n, err)
+	return n, err
+}
+
+// Write implements the io.Writer interface for the macOS tunnel, counting each packet transmitted.
+func (t *tunReadCloser) Write(from []byte) (int, error) {
+	n, err := t.write(from)
+	t.counters.countWrite(n, err)
+	return n, err
+}
+
+// read receives a packet, handling the 4-byte PI header.
+func (t *tunReadCloser) read(to []byte) (int, error) {
 	buf := make([]byte, internalBufferSize)
 
 	n, err := t.f.Read(buf)
@@ -201,8 +220,8 @@ func (t *tunReadCloser) Read(to []byte) (int, error) {
 	return payloadLen, nil
 }
 
-// Write implements the io.Writer interface for the macOS tunnel, prepending the 4-byte PI header.
-func (t *tunReadCloser) Write(from []byte) (int, error) {
+// write transmits a packet, prepending the 4-byte PI header.
+func (t *tunReadCloser) write(from []byte) (int, error) {
 	if len(from) == 0 {
 		return 0, nil
 	}
@@ -234,11 +253,36 @@ func (t *tunReadCloser) Write(from []byte) (int, error) {
 	return 0, err
 }
 
+// ReadBatch receives a single packet into bufs[0], as utun hands over one packet per read.
+func (t *tunReadCloser) ReadBatch(bufs [][]byte, sizes []int) (int, error) {
+	return readBatch(t.Read, bufs, sizes)
+}
+
+// WriteBatch transmits each packet of bufs in turn.
+func (t *tunReadCloser) WriteBatch(bufs [][]byte) (int, error) {
+	return writeBatch(t.Write, bufs)
+}
+
 // Close releases the underlying file descriptor.
 func (t *tunReadCloser) Close() error {
 	return t.f.Close()
 }
 
+// Close reverts the recorded system changes and releases the underlying file descriptor.
+func (a *SwiftInterface) Close() error {
+	return errors.Join(a.changes.revert(), a.tunReadCloser.Close())
+}
+
+// handle returns a netlink handle; netlink is not implemented on macOS, so every call reports ErrNotImplemented.
+func (a *SwiftInterface) handle() *netlink.Handle {
+	return &netlink.Handle{}
+}
+
+// GetAdapterType reports whether the interface operates as TUN or TAP.
+func (a *SwiftInterface) GetAdapterType() swiftypes.AdapterType {
+	return a.AdapterType
+}
+
 // GetFD returns the underlying OS file object.
 func (a *SwiftInterface) GetFD() *os.File {
 	return a.tunReadCloser.f
@@ -246,37 +290,74 @@ func (a *SwiftInterface) GetFD() *os.File {
 
 // NewSwiftInterface creates and configures a new macOS SwiftInterface based on the driver type.
 func NewSwiftInterface(config *swiftconfig.Config) (*SwiftInterface, error) {
-	switch config.DriverType {
-	case swiftconfig.DriverTypeTunTapOSX:
-		tapOSX, err := openDevTunTapOSX(config)
-		if config.UnicastConfig == nil {
-			if err = tapOSX.SetUnicastIpAddressEntry(config.UnicastConfig); err != nil {
-				return nil, err
-			}
-		}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
 
-		if config.MTU > 0 {
-			if err = tapOSX.SetMTU(config.MTU); err != nil {
-				return nil, err
-			}
-		}
+	var adapter *SwiftInterface
+	var err error
 
-		return tapOSX, err
+	switch config.DriverType {
+	case swiftconfig.DriverTypeDefault, swiftconfig.DriverTypeTunTapOSX:
+		adapter, err = openDevTunTapOSX(config)
 	case swiftconfig.DriverTypeSystem:
-		system, err := openDevSystem(config)
-		if config.UnicastConfig == nil {
-			if err = system.SetUnicastIpAddressEntry(config.UnicastConfig); err != nil {
-				return nil, err
-			}
-		}
-
-		if config.MTU > 0 {
-			if err = system.SetMTU(config.MTU); err != nil {
-				return nil, err
-			}
-		}
-		return system, err
+		adapter, err = openDevSystem(config)
 	default:
 		return nil, errors.New("unrecognized driver")
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := configureAdapter(adapter, config); err != nil {
+		_ = adapter.Close()
+		return nil, err
+	}
+
+	return adapter, nil
+}
+
+// NewSwiftInterfaceFromFD adopts an already configured utun socket or TunTapOSX device descriptor.
+// The SwiftInterface takes ownership of fd and closes it on Close. An empty name is resolved from a utun socket.
+func NewSwiftInterfaceFromFD(fd uintptr, name string, adapterType swiftypes.AdapterType) (*SwiftInterface, error) {
+	return adoptInterface([]int{int(fd)}, name, adapterType)
+}
+
+// adoptInterface builds a SwiftInterface around the first descriptor, closing any extra ones as macOS has no queues.
+func adoptInterface(fds []int, name string, adapterType swiftypes.AdapterType) (*SwiftInterface, error) {
+	if len(fds) == 0 {
+		return nil, errors.New("no file descriptor to adopt")
+	}
+
+	closeDescriptors(fds[1:])
+
+	if name == "" {
+		ifName, err := getIfName(fds[0])
+		if err != nil {
+			_ = unix.Close(fds[0])
+			return nil, fmt.Errorf("error getting interface name: %w", err)
+		}
+		name = ifName
+	}
+
+	return &SwiftInterface{
+		name:        name,
+		AdapterType: adapterType,
+		tunReadCloser: &tunReadCloser{
+			f: os.NewFile(uintptr(fds[0]), name),
+		},
+	}, nil
+}
+
+// descriptors returns the raw descriptor backing the interface.
+func (a *SwiftInterface) descriptors() []int {
+	fd := -1
+
+	if conn, err := a.tunReadCloser.f.SyscallConn(); err == nil {
+		_ = conn.Control(func(raw uintptr) {
+			fd = int(raw)
+		})
+	}
+
+	return []int{fd}
 }
@@ -0,0 +1,95 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past used to interrupt a blocked read.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// SetDeadline sets the read and write deadlines of the queue.
+func (q *Queue) SetDeadline(t time.Time) error {
+	if err := q.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	return q.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for pending and future reads; a zero value disables it.
+func (q *Queue) SetReadDeadline(t time.Time) error {
+	q.deadlineMu.Lock()
+	defer q.deadlineMu.Unlock()
+
+	if err := q.file.SetReadDeadline(t); err != nil {
+		return err
+	}
+
+	q.readDeadline = t
+
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for pending and future writes; a zero value disables it.
+func (q *Queue) SetWriteDeadline(t time.Time) error {
+	return q.file.SetWriteDeadline(t)
+}
+
+// ReadContext reads a single packet, returning the context error if ctx is done before a packet arrives.
+func (q *Queue) ReadContext(ctx context.Context, buf []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	interrupted := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(interrupted)
+
+		q.deadlineMu.Lock()
+		defer q.deadlineMu.Unlock()
+
+		_ = q.file.SetReadDeadline(aLongTimeAgo)
+	})
+
+	n, err := q.Read(buf)
+	if stop() {
+		return n, err
+	}
+
+	<-interrupted
+
+	q.deadlineMu.Lock()
+	_ = q.file.SetReadDeadline(q.readDeadline)
+	q.deadlineMu.Unlock()
+
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return n, ctx.Err()
+	}
+
+	return n, err
+}
+
+// SetDeadline sets the read and write deadlines of the default queue.
+func (a *SwiftInterface) SetDeadline(t time.Time) error {
+	return a.queues[0].SetDeadline(t)
+}
+
+// SetReadDeadline sets the read deadline of the default queue.
+func (a *SwiftInterface) SetReadDeadline(t time.Time) error {
+	return a.queues[0].SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the default queue.
+func (a *SwiftInterface) SetWriteDeadline(t time.Time) error {
+	return a.queues[0].SetWriteDeadline(t)
+}
+
+// ReadContext reads a single packet from the default queue, honoring ctx cancellation.
+func (a *SwiftInterface) ReadContext(ctx context.Context, buf []byte) (int, error) {
+	return a.queues[0].ReadContext(ctx, buf)
+}
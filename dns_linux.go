@@ -0,0 +1,287 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"github.com/godbus/dbus/v5"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+const (
+	resolvedService       = "org.freedesktop.resolve1"
+	resolvedPath          = "/org/freedesktop/resolve1"
+	resolvedInterface     = "org.freedesktop.resolve1.Manager"
+	resolvedLinkInterface = "org.freedesktop.resolve1.Link"
+
+	// dbusUnknownMethod is returned by systemd-resolved versions predating a method.
+	dbusUnknownMethod = "org.freedesktop.DBus.Error.UnknownMethod"
+
+	// netnsEtcDir holds the per-namespace configuration files ip netns exec mounts over /etc.
+	netnsEtcDir = "/etc/netns"
+)
+
+// errResolvedUnavailable reports that systemd-resolved is not reachable, making setDNS fall back to resolv.conf.
+var errResolvedUnavailable = errors.New("systemd-resolved is not available")
+
+// dnsBackend identifies the resolver configuration written by setDNS.
+type dnsBackend int
+
+const (
+	dnsBackendNone dnsBackend = iota
+	dnsBackendResolved
+	dnsBackendResolvconf
+	dnsBackendFile
+)
+
+// resolvedServer is a DNS server in the (iay) form expected by SetLinkDNS.
+type resolvedServer struct {
+	Family  int32
+	Address []byte
+}
+
+// resolvedDomain is a search or routing-only domain in the (sb) form expected by SetLinkDomains.
+type resolvedDomain struct {
+	Domain      string
+	RoutingOnly bool
+}
+
+// currentDNS reads the configuration written by setDNS back from its backend: the link properties of
+// systemd-resolved or the resolver file. resolvconf keeps no per-interface state that can be read portably, so the
+// configuration last registered with it is returned. It returns nil when setDNS has not configured the interface.
+func (a *SwiftInterface) currentDNS() (*swiftypes.DNSConfig, error) {
+	switch a.dnsBackend {
+	case dnsBackendResolved:
+		return a.resolvedDNS()
+	case dnsBackendResolvconf:
+		return a.resolvconfDNS, nil
+	case dnsBackendFile:
+		return readResolvConf(a.resolvConfFile())
+	}
+
+	return nil, nil
+}
+
+// dnsRestorer returns a function reverting whatever configuration setDNS wrote.
+func (a *SwiftInterface) dnsRestorer() (func() error, error) {
+	return a.revertDNS, nil
+}
+
+// setDNS configures the DNS servers and search domain of the link through systemd-resolved. Without it, or when
+// a resolv.conf path is configured, the servers are written through resolvconf or directly to resolv.conf.
+// systemd-resolved and resolvconf only manage the host's namespace, so an interface in another one always gets its
+// resolv.conf written.
+func (a *SwiftInterface) setDNS(config *swiftypes.DNSConfig) error {
+	if config == nil {
+		return errors.New("DNS config cannot be nil")
+	}
+
+	managed := a.resolvConfPath == "" && !a.ns.IsOpen()
+
+	if managed && (a.dnsBackend == dnsBackendNone || a.dnsBackend == dnsBackendResolved) {
+		err := a.setResolvedDNS(config)
+		if err == nil {
+			a.dnsBackend = dnsBackendResolved
+		}
+		if !errors.Is(err, errResolvedUnavailable) {
+			return err
+		}
+	}
+
+	content := resolvConfContent(a.name, config)
+
+	if managed && hasResolvconf() {
+		if err := runResolvconf(a.name, content); err != nil {
+			return err
+		}
+		a.dnsBackend = dnsBackendResolvconf
+		a.resolvconfDNS = config
+		return nil
+	}
+
+	path := a.resolvConfFile()
+	if path == "" {
+		return errors.New("an interface in a namespace referenced by descriptor needs a resolv.conf path")
+	}
+
+	if a.ns.IsOpen() {
+		// The per-namespace directory that ip netns exec bind-mounts from may not exist yet.
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+		}
+	}
+
+	if err := writeResolvConf(path, content); err != nil {
+		return err
+	}
+	a.dnsBackend = dnsBackendFile
+
+	return nil
+}
+
+// revertDNS undoes the configuration written by setDNS.
+func (a *SwiftInterface) revertDNS() error {
+	backend := a.dnsBackend
+	a.dnsBackend = dnsBackendNone
+	a.resolvconfDNS = nil
+
+	switch backend {
+	case dnsBackendResolved:
+		index, err := a.GetAdapterIndex()
+		if err != nil {
+			return err
+		}
+
+		return a.withResolved(func(_ *dbus.Conn, manager dbus.BusObject) error {
+			if err := manager.Call(resolvedInterface+".RevertLink", 0, int32(index)).Err; err != nil {
+				return fmt.Errorf("failed to revert DNS configuration: %w", err)
+			}
+			return nil
+		})
+	case dnsBackendResolvconf:
+		return deleteResolvconf(a.name)
+	case dnsBackendFile:
+		return RestoreResolvConf(a.resolvConfFile())
+	}
+
+	return nil
+}
+
+// setResolvedDNS sets the servers and search domain of the link in systemd-resolved and makes it the default route
+// for queries outside other links' domains.
+func (a *SwiftInterface) setResolvedDNS(config *swiftypes.DNSConfig) error {
+	index, err := a.GetAdapterIndex()
+	if err != nil {
+		return err
+	}
+
+	servers := make([]resolvedServer, 0, len(config.DnsServers))
+	for _, server := range config.DnsServers {
+		if ipv4 := server.To4(); ipv4 != nil {
+			servers = append(servers, resolvedServer{Family: unix.AF_INET, Address: ipv4})
+		} else if ipv6 := server.To16(); ipv6 != nil {
+			servers = append(servers, resolvedServer{Family: unix.AF_INET6, Address: ipv6})
+		} else {
+			return fmt.Errorf("invalid DNS server: %v", server)
+		}
+	}
+
+	domains := []resolvedDomain{}
+	if config.Domain != "" {
+		domains = append(domains, resolvedDomain{Domain: config.Domain})
+	}
+
+	return a.withResolved(func(_ *dbus.Conn, manager dbus.BusObject) error {
+		if err := manager.Call(resolvedInterface+".SetLinkDNS", 0, int32(index), servers).Err; err != nil {
+			return fmt.Errorf("failed to set DNS servers: %w", err)
+		}
+
+		if err := manager.Call(resolvedInterface+".SetLinkDomains", 0, int32(index), domains).Err; err != nil {
+			return fmt.Errorf("failed to set DNS domains: %w", err)
+		}
+
+		err := manager.Call(resolvedInterface+".SetLinkDefaultRoute", 0, int32(index), true).Err
+		if dbusErr, ok := err.(dbus.Error); ok && dbusErr.Name == dbusUnknownMethod {
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to set DNS default route: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// resolvedDNS reads the servers and first search domain of the link from systemd-resolved.
+func (a *SwiftInterface) resolvedDNS() (*swiftypes.DNSConfig, error) {
+	index, err := a.GetAdapterIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []resolvedServer
+	var domains []resolvedDomain
+
+	err = a.withResolved(func(conn *dbus.Conn, manager dbus.BusObject) error {
+		var path dbus.ObjectPath
+		if err := manager.Call(resolvedInterface+".GetLink", 0, int32(index)).Store(&path); err != nil {
+			return fmt.Errorf("failed to look up DNS link: %w", err)
+		}
+
+		link := conn.Object(resolvedService, path)
+		if err := link.StoreProperty(resolvedLinkInterface+".DNS", &servers); err != nil {
+			return fmt.Errorf("failed to read DNS servers: %w", err)
+		}
+
+		if err := link.StoreProperty(resolvedLinkInterface+".Domains", &domains); err != nil {
+			return fmt.Errorf("failed to read DNS domains: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	config := &swiftypes.DNSConfig{}
+	for _, server := range servers {
+		config.DnsServers = append(config.DnsServers, net.IP(server.Address))
+	}
+
+	for _, domain := range domains {
+		if !domain.RoutingOnly {
+			config.Domain = domain.Domain
+			break
+		}
+	}
+
+	return config, nil
+}
+
+// withResolved calls fn with the connection and systemd-resolved manager on the configured bus, or the system bus.
+// It returns errResolvedUnavailable when the bus cannot be reached or nobody owns the systemd-resolved name.
+func (a *SwiftInterface) withResolved(fn func(conn *dbus.Conn, manager dbus.BusObject) error) error {
+	var conn *dbus.Conn
+	var err error
+
+	if a.resolvedBus == "" {
+		conn, err = dbus.ConnectSystemBus()
+	} else {
+		conn, err = dbus.Connect(a.resolvedBus)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: failed to connect to D-Bus: %w", errResolvedUnavailable, err)
+	}
+	defer conn.Close()
+
+	var running bool
+	if err := conn.BusObject().Call("org.freedesktop.DBus.NameHasOwner", 0, resolvedService).Store(&running); err != nil {
+		return fmt.Errorf("failed to look up %s: %w", resolvedService, err)
+	}
+
+	if !running {
+		return errResolvedUnavailable
+	}
+
+	return fn(conn, conn.Object(resolvedService, resolvedPath))
+}
+
+// resolvConfFile returns the resolver file written when systemd-resolved is not used: the one of the namespace for an
+// interface in a named namespace, as used by ip netns exec, or "" for one referenced by descriptor.
+func (a *SwiftInterface) resolvConfFile() string {
+	switch {
+	case a.resolvConfPath != "":
+		return a.resolvConfPath
+	case a.nsName != "":
+		return filepath.Join(netnsEtcDir, a.nsName, "resolv.conf")
+	case a.ns.IsOpen():
+		return ""
+	}
+
+	return DefaultResolvConfPath
+}
@@ -0,0 +1,948 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftconfig"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftdns"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftutils"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"github.com/godbus/dbus/v5"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolved records the calls made to the systemd-resolved manager.
+type fakeResolved struct {
+	mu           sync.Mutex
+	servers      map[int32][]resolvedServer
+	domains      map[int32][]resolvedDomain
+	defaultRoute map[int32]bool
+	reverted     []int32
+}
+
+func (f *fakeResolved) SetLinkDNS(index int32, servers []resolvedServer) *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.servers[index] = servers
+	return nil
+}
+
+func (f *fakeResolved) SetLinkDomains(index int32, domains []resolvedDomain) *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.domains[index] = domains
+	return nil
+}
+
+func (f *fakeResolved) SetLinkDefaultRoute(index int32, enable bool) *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.defaultRoute[index] = enable
+	return nil
+}
+
+func (f *fakeResolved) RevertLink(index int32) *dbus.Error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.servers, index)
+	delete(f.domains, index)
+	delete(f.defaultRoute, index)
+	f.reverted = append(f.reverted, index)
+	return nil
+}
+
+func (f *fakeResolved) GetLink(index int32) (dbus.ObjectPath, *dbus.Error) {
+	return fakeLinkPath(index), nil
+}
+
+// fakeLink serves the DNS and Domains properties of a link recorded by a fakeResolved.
+type fakeLink struct {
+	fake  *fakeResolved
+	index int32
+}
+
+func (l *fakeLink) Get(iface, property string) (dbus.Variant, *dbus.Error) {
+	l.fake.mu.Lock()
+	defer l.fake.mu.Unlock()
+
+	switch iface + "." + property {
+	case resolvedLinkInterface + ".DNS":
+		return dbus.MakeVariant(append([]resolvedServer{}, l.fake.servers[l.index]...)), nil
+	case resolvedLinkInterface + ".Domains":
+		return dbus.MakeVariant(append([]resolvedDomain{}, l.fake.domains[l.index]...)), nil
+	}
+
+	return dbus.Variant{}, dbus.MakeFailedError(fmt.Errorf("unknown property %s.%s", iface, property))
+}
+
+// fakeLinkPath returns the object path of a link, as systemd-resolved names it.
+func fakeLinkPath(index int32) dbus.ObjectPath {
+	return dbus.ObjectPath(fmt.Sprintf("%s/link/_3%d", resolvedPath, index))
+}
+
+// startTestBus runs a private dbus-daemon and returns its address, skipping the test when none is installed.
+func startTestBus(t *testing.T) string {
+	t.Helper()
+
+	daemon, err := exec.LookPath("dbus-daemon")
+	if err != nil {
+		t.Skip("dbus-daemon not installed")
+	}
+
+	dir := t.TempDir()
+	config := filepath.Join(dir, "bus.conf")
+	if err := os.WriteFile(config, []byte(`<busconfig>
+  <type>session</type>
+  <listen>unix:path=`+filepath.Join(dir, "bus")+`</listen>
+  <auth>EXTERNAL</auth>
+  <policy context="default">
+    <allow send_destination="*"/>
+    <allow receive_sender="*"/>
+    <allow own="*"/>
+  </policy>
+</busconfig>`), 0o644); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	cmd := exec.Command(daemon, "--config-file", config, "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("expected no error starting dbus-daemon, got %v", err)
+	}
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Fatalf("expected dbus-daemon to print its address, got %v", err)
+	}
+
+	return strings.TrimSpace(address)
+}
+
+func TestSetDNSResolved(t *testing.T) {
+	address := startTestBus(t)
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+
+	fake := &fakeResolved{
+		servers:      map[int32][]resolvedServer{},
+		domains:      map[int32][]resolvedDomain{},
+		defaultRoute: map[int32]bool{},
+	}
+
+	if err := conn.Export(fake, resolvedPath, resolvedInterface); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if reply, err := conn.RequestName(resolvedService, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("expected to own %s, got %v, %v", resolvedService, reply, err)
+	}
+
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tunresolved0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+		ResolvedBus: address,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	index, _ := adapter.GetAdapterIndex()
+
+	if err := conn.Export(&fakeLink{fake: fake, index: int32(index)}, fakeLinkPath(int32(index)),
+		"org.freedesktop.DBus.Properties"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	dns := &swiftypes.DNSConfig{
+		Domain:     "corp.example",
+		DnsServers: []net.IP{net.ParseIP("10.171.0.53"), net.ParseIP("fd00:171::53")},
+	}
+
+	if err := adapter.SetDNS(dns); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if report, err := adapter.Apply(&InterfaceState{DNS: dns}); err != nil || report.DNSChanged {
+		t.Fatalf("expected applying the same DNS configuration to be a no-op, got %+v, %v", report, err)
+	}
+
+	fake.mu.Lock()
+	servers, domains, defaultRoute := fake.servers[int32(index)], fake.domains[int32(index)], fake.defaultRoute[int32(index)]
+	fake.mu.Unlock()
+
+	if len(servers) != 2 || servers[0].Family != 2 || !net.IP(servers[0].Address).Equal(net.ParseIP("10.171.0.53")) ||
+		len(servers[1].Address) != net.IPv6len {
+		t.Fatalf("unexpected DNS servers %+v", servers)
+	}
+
+	if len(domains) != 1 || domains[0].Domain != "corp.example" || domains[0].RoutingOnly {
+		t.Fatalf("unexpected DNS domains %+v", domains)
+	}
+
+	if !defaultRoute {
+		t.Fatal("expected the link to become the default DNS route")
+	}
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+
+	if !slices.Contains(fake.reverted, int32(index)) {
+		t.Fatalf("expected link %d to be reverted on close, got %v", index, fake.reverted)
+	}
+}
+
+func TestSetDNSNetNS(t *testing.T) {
+	newTestNetNS(t, "swiftunnel-test7")
+
+	address := startTestBus(t)
+
+	conn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+
+	fake := &fakeResolved{
+		servers:      map[int32][]resolvedServer{},
+		domains:      map[int32][]resolvedDomain{},
+		defaultRoute: map[int32]bool{},
+	}
+
+	if err := conn.Export(fake, resolvedPath, resolvedInterface); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if reply, err := conn.RequestName(resolvedService, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("expected to own %s, got %v, %v", resolvedService, reply, err)
+	}
+
+	dir := filepath.Join(netnsEtcDir, "swiftunnel-test7")
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunnsdns0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		UnicastConfig: testUnicastConfig(t, "10.201.0.1/24"),
+		NetNS:         swiftconfig.NewNetNSByName("swiftunnel-test7"),
+		ResolvedBus:   address,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	// systemd-resolved only manages the host's links, so the namespace gets its own resolv.conf instead.
+	if err := adapter.SetDNS(&swiftypes.DNSConfig{DnsServers: []net.IP{net.ParseIP("10.201.0.53")}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	path := filepath.Join(dir, "resolv.conf")
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "nameserver 10.201.0.53\n") {
+		t.Fatalf("expected the namespace resolv.conf to be written, got %q", data)
+	}
+
+	fake.mu.Lock()
+	configured := len(fake.servers)
+	fake.mu.Unlock()
+
+	if configured != 0 {
+		t.Fatalf("expected systemd-resolved to be left alone, got %d links configured", configured)
+	}
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected the namespace resolv.conf to be removed on close, got %v", err)
+	}
+}
+
+func TestSetDNSResolvConf(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	original := []byte("nameserver 192.0.2.1\n")
+
+	if err := os.WriteFile(path, original, 0o644); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	newAdapter := func() *SwiftInterface {
+		adapter, err := NewSwiftInterface(&swiftconfig.Config{
+			AdapterName:    "tunresolv0",
+			AdapterType:    swiftypes.AdapterTypeTUN,
+			ResolvConfPath: path,
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return adapter
+	}
+
+	readFile := func(name string) string {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("expected no error reading %s, got %v", name, err)
+		}
+		return string(data)
+	}
+
+	adapter := newAdapter()
+
+	for _, server := range []string{"10.172.0.53", "10.172.0.54"} {
+		if err := adapter.SetDNS(&swiftypes.DNSConfig{
+			Domain:     "corp.example",
+			DnsServers: []net.IP{net.ParseIP(server)},
+		}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if content := readFile(path); !strings.Contains(content, "nameserver "+server+"\n") ||
+			!strings.Contains(content, "search corp.example\n") {
+			t.Fatalf("unexpected resolv.conf %q", content)
+		}
+	}
+
+	dns := &swiftypes.DNSConfig{Domain: "corp.example", DnsServers: []net.IP{net.ParseIP("10.172.0.54")}}
+	if report, err := adapter.Apply(&InterfaceState{DNS: dns}); err != nil || report.DNSChanged {
+		t.Fatalf("expected applying the same DNS configuration to be a no-op, got %+v, %v", report, err)
+	}
+
+	dns.Domain = "other.example"
+	if report, err := adapter.Apply(&InterfaceState{DNS: dns}); err != nil || !report.DNSChanged {
+		t.Fatalf("expected a different domain to be applied, got %+v, %v", report, err)
+	}
+
+	if backup := readFile(path + resolvConfBackupSuffix); backup != string(original) {
+		t.Fatalf("expected the original to be backed up, got %q", backup)
+	}
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	if content := readFile(path); content != string(original) {
+		t.Fatalf("expected the original to be restored on close, got %q", content)
+	}
+
+	for _, leftover := range []string{resolvConfBackupSuffix, resolvConfMarkerSuffix} {
+		if _, err := os.Stat(path + leftover); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, got %v", path+leftover, err)
+		}
+	}
+
+	// A crashed process leaves its file behind; a later run restores it from the marker.
+	adapter = newAdapter()
+	if err := adapter.SetDNS(&swiftypes.DNSConfig{DnsServers: []net.IP{net.ParseIP("10.172.0.53")}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	adapter.KeepChanges()
+	_ = adapter.Close()
+
+	if err := RestoreResolvConf(path); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if content := readFile(path); content != string(original) {
+		t.Fatalf("expected the original to be restored after a crash, got %q", content)
+	}
+
+	// A file rewritten by another program is neither overwritten nor replaced by the backup.
+	adapter = newAdapter()
+	defer adapter.Close()
+
+	if err := adapter.SetDNS(&swiftypes.DNSConfig{DnsServers: []net.IP{net.ParseIP("10.172.0.53")}}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	foreign := "nameserver 198.51.100.1\n"
+	if err := os.WriteFile(path, []byte(foreign), 0o644); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.SetDNS(&swiftypes.DNSConfig{DnsServers: []net.IP{net.ParseIP("10.172.0.54")}}); err == nil {
+		t.Fatal("expected SetDNS to refuse overwriting a foreign resolv.conf")
+	}
+
+	if err := adapter.Close(); err == nil {
+		t.Fatal("expected Close to refuse restoring over a foreign resolv.conf")
+	}
+
+	if content := readFile(path); content != foreign {
+		t.Fatalf("expected the foreign resolv.conf to be kept, got %q", content)
+	}
+}
+
+func TestDNSForwarder(t *testing.T) {
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer upstream.Close()
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			var msg dnsmessage.Message
+			if msg.Unpack(buf[:n]) != nil {
+				continue
+			}
+
+			msg.Response = true
+			msg.Answers = []dnsmessage.Resource{{
+				Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+				Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+			}}
+
+			response, _ := msg.Pack()
+			_, _ = upstream.WriteTo(response, addr)
+		}
+	}()
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte("nameserver 192.0.2.53\n"), 0o644); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:    "tunfwd0",
+		AdapterType:    swiftypes.AdapterTypeTUN,
+		UnicastConfig:  testUnicastConfig(t, "10.170.0.1/24"),
+		ResolvConfPath: path,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	// Upstream sockets are bound to the interface by default.
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer unix.Close(fd)
+
+	if err := adapter.bindSocket("udp4", uintptr(fd)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if device, err := unix.GetsockoptString(fd, unix.SOL_SOCKET, unix.SO_BINDTODEVICE); err != nil || device != "tunfwd0" {
+		t.Fatalf("expected the socket to be bound to tunfwd0, got %q, %v", device, err)
+	}
+
+	forwarder, err := adapter.StartDNSForwarder(&swiftdns.Config{
+		Upstreams: []string{upstream.LocalAddr().String()},
+		Dialer:    &net.Dialer{},
+	}, "corp.example")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if addr := forwarder.Addr().String(); addr != "10.170.0.1:53" {
+		t.Fatalf("expected the forwarder to listen on 10.170.0.1:53, got %s", addr)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "nameserver 10.170.0.1\n") || !strings.Contains(string(data), "search corp.example\n") {
+		t.Fatalf("expected the forwarder to be the interface DNS server, got %q", data)
+	}
+
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, forwarder.Addr().String())
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addrs, err := resolver.LookupIP(ctx, "ip4", "forwarded.example")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(addrs) != 1 || !addrs[0].Equal(net.ParseIP("192.0.2.1")) {
+		t.Fatalf("unexpected addresses %v", addrs)
+	}
+
+	if err := adapter.StopDNSForwarder(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "nameserver 192.0.2.53\n" {
+		t.Fatalf("expected the DNS configuration to be restored, got %q", data)
+	}
+
+	conn, err := net.ListenPacket("udp", "10.170.0.1:53")
+	if err != nil {
+		t.Fatalf("expected the forwarder to release its port, got %v", err)
+	}
+	_ = conn.Close()
+
+	// A configuration set through SetDNS before the forwarder starts comes back when it stops.
+	if err := adapter.SetDNS(&swiftypes.DNSConfig{
+		Domain:     "own.example",
+		DnsServers: []net.IP{net.ParseIP("10.170.0.54")},
+	}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := adapter.StartDNSForwarder(&swiftdns.Config{Upstreams: []string{upstream.LocalAddr().String()}}, ""); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.StopDNSForwarder(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "nameserver 10.170.0.54\n") ||
+		!strings.Contains(string(data), "search own.example\n") {
+		t.Fatalf("expected the configuration set through SetDNS to be restored, got %q", data)
+	}
+
+	if _, err := adapter.StartDNSForwarder(&swiftdns.Config{Upstreams: []string{upstream.LocalAddr().String()}}, ""); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "nameserver 192.0.2.53\n" {
+		t.Fatalf("expected the DNS configuration to be restored on close, got %q", data)
+	}
+}
+
+// staticResolver answers every query with the A record 192.0.2.1.
+type staticResolver struct{}
+
+func (staticResolver) Resolve(_ context.Context, query []byte) ([]byte, error) {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil, err
+	}
+
+	msg.Response = true
+	msg.Answers = []dnsmessage.Resource{{
+		Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+		Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+	}}
+
+	return msg.Pack()
+}
+
+func TestDNSInterceptor(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunintercept0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		UnicastConfig: testUnicastConfig(t, "10.169.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	v6, _ := swiftypes.ParseAddress("fd00:169::1/64")
+	v6.NoDAD = true
+	if err := adapter.AddAddress(v6); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	waitAddressesReady(t, "tunintercept0")
+
+	servers := []net.IP{net.ParseIP("10.169.0.53"), net.ParseIP("fd00:169::53")}
+
+	interceptor, err := NewDNSInterceptor(adapter, &DNSInterceptorConfig{Resolver: staticResolver{}, Servers: servers})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer interceptor.Close()
+
+	passed := make(chan []byte, 16)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, err := interceptor.Read(buf)
+			if err != nil {
+				return
+			}
+			passed <- slices.Clone(buf[:n])
+		}
+	}()
+
+	for _, server := range servers {
+		resolver := &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, net.JoinHostPort(server.String(), "53"))
+			},
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		addrs, err := resolver.LookupIP(ctx, "ip4", "intercepted.example")
+		cancel()
+
+		if err != nil {
+			t.Fatalf("expected the query to %v to be answered, got %v", server, err)
+		}
+
+		if len(addrs) != 1 || !addrs[0].Equal(net.ParseIP("192.0.2.1")) {
+			t.Fatalf("unexpected addresses %v", addrs)
+		}
+	}
+
+	conn, err := net.Dial("udp", "10.169.0.53:9999")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("not dns")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case packet := <-passed:
+			if query, ok := parseUDPPacket(packet); ok && query.dstPort == 9999 {
+				return
+			}
+		case <-timeout:
+			t.Fatal("expected other packets to be returned by Read")
+		}
+	}
+}
+
+func TestUDPReply(t *testing.T) {
+	for _, version := range []int{4, 6} {
+		query, ok := parseUDPPacket(buildTestPacket(version, unix.IPPROTO_UDP, 0, 0, []byte("query")))
+		if !ok {
+			t.Fatalf("expected a UDP packet for IPv%d", version)
+		}
+
+		reply, err := udpReply(query, []byte("response"))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		parsed, ok := parseUDPPacket(reply)
+		if !ok || !parsed.src.Equal(query.dst) || !parsed.dst.Equal(query.src) ||
+			parsed.srcPort != query.dstPort || parsed.dstPort != query.srcPort || string(parsed.payload) != "response" {
+			t.Fatalf("unexpected IPv%d reply %+v", version, parsed)
+		}
+
+		header := ipv6HeaderLen
+		if version == 4 {
+			header = ipv4HeaderMin
+			if swiftutils.Checksum(reply[:header], 0) != 0xFFFF {
+				t.Fatal("expected a valid IPv4 header checksum")
+			}
+		}
+
+		udp := reply[header:]
+		if swiftutils.Checksum(udp, swiftutils.PseudoHeaderChecksum(unix.IPPROTO_UDP, parsed.src, parsed.dst, uint16(len(udp)))) != 0xFFFF {
+			t.Fatalf("expected a valid IPv%d UDP checksum", version)
+		}
+
+		// The largest payload fills the length fields exactly; one more byte would wrap them.
+		limit := 0xFFFF - header - udpHeaderLen
+		if version == 6 {
+			limit = 0xFFFF - udpHeaderLen
+		}
+
+		if reply, err = udpReply(query, make([]byte, limit)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if parsed, ok := parseUDPPacket(reply); !ok || len(parsed.payload) != limit {
+			t.Fatalf("expected an IPv%d reply carrying %d bytes", version, limit)
+		}
+
+		if _, err := udpReply(query, make([]byte, limit+1)); err == nil {
+			t.Fatalf("expected an error for an IPv%d payload of %d bytes", version, limit+1)
+		}
+	}
+}
+
+func TestSetDNSEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte("nameserver 192.0.2.53\n"), 0o644); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:    "tunencdns0",
+		AdapterType:    swiftypes.AdapterTypeTUN,
+		UnicastConfig:  testUnicastConfig(t, "10.168.0.1/24"),
+		ResolvConfPath: path,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	config := &swiftypes.DNSConfig{
+		Domain: "corp.example",
+		Upstreams: []swiftypes.DNSUpstream{
+			{URL: "https://dns.example/dns-query", Bootstrap: []net.IP{net.ParseIP("192.0.2.10")}},
+		},
+	}
+
+	if err := adapter.SetDNS(config); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	served := adapter.encryptedDNS
+
+	// Setting the same configuration again keeps the running forwarder, its cache and in-flight queries.
+	if err := adapter.SetDNS(config); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if adapter.encryptedDNS != served {
+		t.Fatal("expected the running forwarder to be kept")
+	}
+
+	if report, err := adapter.Apply(&InterfaceState{DNS: config}); err != nil || report.Changed() {
+		t.Fatalf("expected applying the served configuration to be a no-op, got %+v, %v", report, err)
+	}
+
+	changed := &swiftypes.DNSConfig{
+		Domain:    config.Domain,
+		Upstreams: []swiftypes.DNSUpstream{{URL: "tls://dns.example", Bootstrap: []net.IP{net.ParseIP("192.0.2.11")}}},
+	}
+
+	if report, err := adapter.Apply(&InterfaceState{DNS: changed}); err != nil || !report.DNSChanged {
+		t.Fatalf("expected new upstreams to be applied, got %+v, %v", report, err)
+	}
+
+	if adapter.encryptedDNS == served || adapter.encryptedDNS.Upstreams[0].URL != "tls://dns.example" {
+		t.Fatalf("expected the forwarder to be restarted, got %v", adapter.encryptedDNS)
+	}
+
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "nameserver 10.168.0.1\n") {
+		t.Fatalf("expected the forwarder to be the interface DNS server, got %q", data)
+	}
+
+	if conn, err := net.ListenPacket("udp", "10.168.0.1:53"); err == nil {
+		_ = conn.Close()
+		t.Fatal("expected the forwarder to listen on the interface address")
+	}
+
+	config.Upstreams[0].Bootstrap = nil
+	if err := adapter.SetDNS(config); err == nil {
+		t.Fatal("expected an error for an upstream without bootstrap addresses")
+	}
+
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "nameserver 10.168.0.1\n") {
+		t.Fatalf("expected an invalid configuration to keep the running forwarder, got %q", data)
+	}
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	if data, _ := os.ReadFile(path); string(data) != "nameserver 192.0.2.53\n" {
+		t.Fatalf("expected the DNS configuration to be restored on close, got %q", data)
+	}
+}
+
+func TestDomainRouter(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tundomain0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		UnicastConfig: testUnicastConfig(t, "10.167.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	v6, _ := swiftypes.ParseAddress("fd00:167::1/64")
+	v6.NoDAD = true
+	if err := adapter.AddAddress(v6); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	router, err := NewDomainRouter(adapter, &DomainRouterConfig{Domains: []string{"*.corp.example"}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer router.Close()
+
+	now := time.Now()
+	router.now = func() time.Time { return now }
+
+	response := func(name string, ttl uint32, answers ...dnsmessage.ResourceBody) []byte {
+		msg := dnsmessage.Message{
+			Header:    dnsmessage.Header{Response: true},
+			Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+		}
+		for _, answer := range answers {
+			header := dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Class: dnsmessage.ClassINET, TTL: ttl}
+			if _, ok := answer.(*dnsmessage.AAAAResource); ok {
+				header.Type = dnsmessage.TypeAAAA
+			} else {
+				header.Type = dnsmessage.TypeA
+			}
+			msg.Answers = append(msg.Answers, dnsmessage.Resource{Header: header, Body: answer})
+		}
+
+		packed, err := msg.Pack()
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		return packed
+	}
+
+	// Responses reach the router through the OnResponse callback of the library's resolver.
+	upstream := newTestAnswerServer(t, response("app.corp.example.", 30,
+		&dnsmessage.AResource{A: [4]byte{198, 51, 100, 7}},
+		&dnsmessage.AAAAResource{AAAA: [16]byte{0x20, 0x01, 0x0d, 0xb8, 15: 7}},
+	))
+
+	forwarder, err := swiftdns.NewForwarder(&swiftdns.Config{
+		Upstreams:  []string{upstream},
+		OnResponse: func(response []byte) { _ = router.Observe(response) },
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer forwarder.Close()
+
+	query, _ := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 7, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName("app.corp.example."), Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET}},
+	}).Pack()
+
+	if _, err := forwarder.Resolve(context.Background(), query); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := router.Observe(response("www.other.example.", 30, &dnsmessage.AResource{A: [4]byte{198, 51, 100, 8}})); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// A later answer with a longer TTL extends the route.
+	if err := router.Observe(response("api.corp.example.", 120, &dnsmessage.AResource{A: [4]byte{198, 51, 100, 9}})); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	routed := func() []string {
+		routes, err := adapter.RouteList(netlink.FAMILY_ALL)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		var dsts []string
+		for _, route := range routes {
+			if route.Dst != nil {
+				if ones, bits := route.Dst.Mask.Size(); ones == bits {
+					dsts = append(dsts, route.Dst.String())
+				}
+			}
+		}
+		slices.Sort(dsts)
+		return dsts
+	}
+
+	expected := []string{"198.51.100.7/32", "198.51.100.9/32", "2001:db8::7/128"}
+	if dsts := routed(); !slices.Equal(dsts, expected) {
+		t.Fatalf("expected host routes %v, got %v", expected, dsts)
+	}
+
+	now = now.Add(time.Minute)
+	if err := router.expire(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if dsts := routed(); !slices.Equal(dsts, []string{"198.51.100.9/32"}) {
+		t.Fatalf("expected expired routes to be removed, got %v", dsts)
+	}
+
+	if err := router.Close(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if dsts := routed(); len(dsts) != 0 {
+		t.Fatalf("expected Close to remove every route, got %v", dsts)
+	}
+}
+
+// newTestAnswerServer starts a UDP DNS server replying to every query with response, given the query's ID, and
+// returns its address.
+func newTestAnswerServer(t *testing.T, response []byte) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 2 {
+				continue
+			}
+
+			reply := slices.Clone(response)
+			copy(reply, buf[:2])
+			_, _ = conn.WriteTo(reply, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
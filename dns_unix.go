@@ -0,0 +1,23 @@
+//go:build unix && !linux
+
+package swiftunnel
+
+import (
+	"errors"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+)
+
+// currentDNS returns nil as the DNS configuration of the interface cannot be read back.
+func (a *SwiftInterface) currentDNS() (*swiftypes.DNSConfig, error) {
+	return nil, nil
+}
+
+// dnsRestorer returns nil as setDNS leaves nothing to restore on this platform.
+func (a *SwiftInterface) dnsRestorer() (func() error, error) {
+	return nil, nil
+}
+
+// setDNS is currently unsupported on this platform.
+func (a *SwiftInterface) setDNS(config *swiftypes.DNSConfig) error {
+	return errors.New("DNS configuration not supported on this platform")
+}
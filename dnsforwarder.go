@@ -0,0 +1,176 @@
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftdns"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"net"
+	"slices"
+	"syscall"
+)
+
+const (
+	// dnsForwarderChangeKey identifies the DNS forwarder in the change log.
+	dnsForwarderChangeKey = "dns forwarder"
+	// dnsForwarderDNSChangeKey identifies the DNS configuration pointing at the forwarder.
+	dnsForwarderDNSChangeKey = "dns forwarder config"
+)
+
+// StartDNSForwarder starts a caching swiftdns.Forwarder and makes it the DNS server of the interface through SetDNS,
+// with domain as the search domain. An empty config.Listen means the first address of the interface, such as the
+// UnicastConfig.IP it was created with, and a nil config.Dialer one bound to the interface so that forwarded queries
+// cannot leave through another link. StopDNSForwarder restores the DNS configuration the interface had before, such as
+// one set through SetDNS, and stops the forwarder; Close reverts both.
+func (a *SwiftInterface) StartDNSForwarder(config *swiftdns.Config, domain string) (*swiftdns.Forwarder, error) {
+	if config == nil {
+		return nil, errors.New("forwarder config cannot be nil")
+	}
+
+	if a.changes.has(dnsForwarderChangeKey) {
+		return nil, errors.New("DNS forwarder already running")
+	}
+
+	// The configuration set through SetDNS before the forwarder took over is restored when it stops; without one,
+	// the change recorded by SetDNS below is undone.
+	restore := func() error { return a.changes.undo(dnsChangeKey) }
+	if a.changes.has(dnsChangeKey) {
+		previous, err := a.currentDNS()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DNS configuration: %w", err)
+		}
+
+		if previous != nil {
+			restore = func() error { return a.setDNS(previous) }
+		}
+	}
+
+	forwarderConfig := *config
+
+	if forwarderConfig.Listen == nil {
+		listen, err := a.listenAddress()
+		if err != nil {
+			return nil, err
+		}
+		forwarderConfig.Listen = listen
+	}
+
+	if forwarderConfig.Dialer == nil {
+		forwarderConfig.Dialer = &net.Dialer{
+			Control: func(network, _ string, conn syscall.RawConn) error {
+				var bindErr error
+				if err := conn.Control(func(fd uintptr) {
+					bindErr = a.bindSocket(network, fd)
+				}); err != nil {
+					return err
+				}
+				return bindErr
+			},
+		}
+	}
+
+	forwarder, err := swiftdns.NewForwarder(&forwarderConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	a.changes.record(dnsForwarderChangeKey, func() error {
+		a.encryptedDNS = nil
+		return forwarder.Close()
+	})
+
+	if err := a.SetDNS(&swiftypes.DNSConfig{Domain: domain, DnsServers: []net.IP{forwarderConfig.Listen}}); err != nil {
+		return nil, errors.Join(err, a.changes.undo(dnsForwarderChangeKey))
+	}
+
+	a.changes.record(dnsForwarderDNSChangeKey, restore)
+
+	return forwarder, nil
+}
+
+// StopDNSForwarder restores the DNS configuration replaced by StartDNSForwarder and stops the forwarder.
+func (a *SwiftInterface) StopDNSForwarder() error {
+	return errors.Join(a.changes.undo(dnsForwarderDNSChangeKey), a.changes.undo(dnsForwarderChangeKey))
+}
+
+// setEncryptedDNS restarts the DNS forwarder with the encrypted upstreams of config, unless the interface already
+// resolves through a forwarder serving the same upstreams and domain.
+func (a *SwiftInterface) setEncryptedDNS(config *swiftypes.DNSConfig) error {
+	current, err := a.activeDNS()
+	if err != nil {
+		return fmt.Errorf("failed to read DNS configuration: %w", err)
+	}
+
+	if current != nil && dnsConfigEqual(current, config) {
+		return nil
+	}
+
+	// A forwarder that does not listen validates the upstreams before the running one is stopped.
+	probe, err := swiftdns.NewForwarder(&swiftdns.Config{Encrypted: config.Upstreams})
+	if err != nil {
+		return err
+	}
+	_ = probe.Close()
+
+	if err := a.StopDNSForwarder(); err != nil {
+		return err
+	}
+
+	forwarder, err := a.StartDNSForwarder(&swiftdns.Config{Encrypted: config.Upstreams}, config.Domain)
+	if err != nil {
+		return err
+	}
+
+	a.encryptedDNS = &swiftypes.DNSConfig{
+		Domain:     config.Domain,
+		DnsServers: []net.IP{forwarder.Addr().(*net.UDPAddr).IP},
+		Upstreams:  slices.Clone(config.Upstreams),
+	}
+
+	return nil
+}
+
+// activeDNS returns the DNS configuration of the interface, with the upstreams of the forwarder started by
+// setEncryptedDNS when the configuration still points at it.
+func (a *SwiftInterface) activeDNS() (*swiftypes.DNSConfig, error) {
+	current, err := a.currentDNS()
+	if err != nil || current == nil || a.encryptedDNS == nil {
+		return current, err
+	}
+
+	forwarded := &swiftypes.DNSConfig{Domain: a.encryptedDNS.Domain, DnsServers: a.encryptedDNS.DnsServers}
+	if dnsConfigEqual(current, forwarded) {
+		return a.encryptedDNS, nil
+	}
+
+	return current, nil
+}
+
+// listenAddress returns the first IPv4 address of the interface, or its first global IPv6 address.
+func (a *SwiftInterface) listenAddress() (net.IP, error) {
+	addrs, err := a.Addresses()
+	if err != nil {
+		return nil, err
+	}
+
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		if addr.IPNet == nil || addr.IPNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		if ipv4 := addr.IPNet.IP.To4(); ipv4 != nil {
+			return ipv4, nil
+		}
+
+		if ipv6 == nil {
+			ipv6 = addr.IPNet.IP
+		}
+	}
+
+	if ipv6 == nil {
+		return nil, errors.New("interface has no address to listen on")
+	}
+
+	return ipv6, nil
+}
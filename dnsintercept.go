@@ -0,0 +1,281 @@
+package swiftunnel
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftdns"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftutils"
+	"math"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	ipv4HeaderMin = 20
+	ipv6HeaderLen = 40
+	udpHeaderLen  = 8
+	ipProtoUDP    = 17
+
+	// dnsPort is the destination port of intercepted queries.
+	dnsPort = 53
+	// defaultInterceptTimeout bounds the resolution of an intercepted query when DNSInterceptorConfig.Timeout is zero.
+	defaultInterceptTimeout = 5 * time.Second
+)
+
+// DNSResolver answers wire-format DNS queries; *swiftdns.Forwarder implements it.
+type DNSResolver interface {
+	Resolve(ctx context.Context, query []byte) ([]byte, error)
+}
+
+// DNSInterceptorConfig describes which queries a DNSInterceptor answers and how.
+type DNSInterceptorConfig struct {
+	// Resolver answers the intercepted queries. A swiftdns.Forwarder without Listen redirects them to its upstreams.
+	Resolver DNSResolver
+	// Servers restricts interception to queries sent to these addresses; empty intercepts every UDP/53 query.
+	Servers []net.IP
+	// Timeout bounds the resolution of each query; zero means 5 seconds.
+	Timeout time.Duration
+	// OnResponse is called with the DNS payload of each reply before it is written to the interface, whether the
+	// interceptor synthesized it or it is a UDP/53 reply passed to Write.
+	OnResponse func(response []byte)
+}
+
+// DNSInterceptor reads packets from a TUN SwiftInterface and answers the UDP/53 queries among them itself, writing
+// the reply packets back to the interface, for hosts whose system DNS configuration cannot be changed.
+type DNSInterceptor struct {
+	iface    *SwiftInterface
+	resolver DNSResolver
+	servers  []net.IP
+	timeout  time.Duration
+
+	onResponse func(response []byte)
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// udpPacket is the part of an IPv4 or IPv6 UDP packet needed to answer it.
+type udpPacket struct {
+	src, dst         net.IP
+	srcPort, dstPort uint16
+	payload          []byte
+}
+
+// NewDNSInterceptor returns a DNSInterceptor reading from iface. Packets must be read through the interceptor,
+// instead of the interface, for queries to be answered.
+func NewDNSInterceptor(iface *SwiftInterface, config *DNSInterceptorConfig) (*DNSInterceptor, error) {
+	if config == nil || config.Resolver == nil {
+		return nil, errors.New("DNS interceptor needs a resolver")
+	}
+
+	d := &DNSInterceptor{
+		iface:    iface,
+		resolver: config.Resolver,
+		servers:  config.Servers,
+		timeout:  config.Timeout,
+
+		onResponse: config.OnResponse,
+	}
+
+	if d.timeout <= 0 {
+		d.timeout = defaultInterceptTimeout
+	}
+
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+
+	return d, nil
+}
+
+// Read reads the next packet from the interface that is not an intercepted query. Queries are resolved in the
+// background and their replies written to the interface.
+func (d *DNSInterceptor) Read(buf []byte) (int, error) {
+	for {
+		n, err := d.iface.Read(buf)
+		if err != nil {
+			return n, err
+		}
+
+		query, ok := parseUDPPacket(buf[:n])
+		if !ok || !d.intercepts(query) {
+			return n, nil
+		}
+
+		query.payload = slices.Clone(query.payload)
+		query.src, query.dst = slices.Clone(query.src), slices.Clone(query.dst)
+
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.answer(query)
+		}()
+	}
+}
+
+// Write writes a packet to the interface, passing the payload of UDP/53 replies to OnResponse first.
+func (d *DNSInterceptor) Write(buf []byte) (int, error) {
+	if d.onResponse != nil {
+		if reply, ok := parseUDPPacket(buf); ok && reply.srcPort == dnsPort {
+			d.onResponse(reply.payload)
+		}
+	}
+
+	return d.iface.Write(buf)
+}
+
+// Close abandons the queries being resolved and waits for their goroutines. The interface stays open.
+func (d *DNSInterceptor) Close() error {
+	d.cancel()
+	d.wg.Wait()
+
+	return nil
+}
+
+// intercepts reports whether packet is a DNS query to one of the intercepted servers.
+func (d *DNSInterceptor) intercepts(packet udpPacket) bool {
+	if packet.dstPort != dnsPort {
+		return false
+	}
+
+	if len(d.servers) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(d.servers, packet.dst.Equal)
+}
+
+// answer resolves the query carried by packet and writes the reply packet to the interface. Queries the resolver
+// cannot parse are dropped.
+func (d *DNSInterceptor) answer(packet udpPacket) {
+	ctx, cancel := context.WithTimeout(d.ctx, d.timeout)
+	defer cancel()
+
+	response, err := d.resolver.Resolve(ctx, packet.payload)
+	if err != nil || d.ctx.Err() != nil {
+		return
+	}
+
+	if response, err = swiftdns.TruncateResponse(response, packet.payload); err != nil {
+		return
+	}
+
+	if d.onResponse != nil {
+		d.onResponse(response)
+	}
+
+	reply, err := udpReply(packet, response)
+	if err != nil {
+		return
+	}
+
+	_, _ = d.iface.Write(reply)
+}
+
+// parseUDPPacket extracts the addresses, ports and payload of an unfragmented IPv4 or IPv6 UDP packet.
+// IPv6 packets with extension headers are not recognized.
+func parseUDPPacket(packet []byte) (udpPacket, bool) {
+	var udp []byte
+	var src, dst net.IP
+
+	switch {
+	case swiftutils.IsIPv4(packet):
+		if !swiftutils.ValidateIPv4(packet) || swiftutils.IPv4Protocol(packet) != ipProtoUDP {
+			return udpPacket{}, false
+		}
+
+		// More fragments flag or fragment offset.
+		if binary.BigEndian.Uint16(packet[6:])&0x3FFF != 0 {
+			return udpPacket{}, false
+		}
+
+		header, total := swiftutils.IPv4HeaderLength(packet), int(binary.BigEndian.Uint16(packet[2:]))
+		if total < header {
+			return udpPacket{}, false
+		}
+
+		udp = packet[header:total]
+		src, dst = packet[12:16], packet[16:20]
+	case swiftutils.IsIPv6(packet):
+		if len(packet) < ipv6HeaderLen || swiftutils.IPv6NextHeader(packet) != ipProtoUDP {
+			return udpPacket{}, false
+		}
+
+		end := ipv6HeaderLen + int(binary.BigEndian.Uint16(packet[4:]))
+		if end > len(packet) {
+			return udpPacket{}, false
+		}
+
+		udp = packet[ipv6HeaderLen:end]
+		src, dst = packet[8:24], packet[24:40]
+	default:
+		return udpPacket{}, false
+	}
+
+	if len(udp) < udpHeaderLen {
+		return udpPacket{}, false
+	}
+
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if length < udpHeaderLen || length > len(udp) {
+		return udpPacket{}, false
+	}
+
+	return udpPacket{
+		src:     src,
+		dst:     dst,
+		srcPort: binary.BigEndian.Uint16(udp[0:]),
+		dstPort: binary.BigEndian.Uint16(udp[2:]),
+		payload: udp[udpHeaderLen:length],
+	}, true
+}
+
+// udpReply builds the IPv4 or IPv6 UDP packet carrying payload back to the sender of query, with valid checksums.
+// A payload whose packet would not fit the 16-bit length fields is rejected.
+func udpReply(query udpPacket, payload []byte) ([]byte, error) {
+	header, limit := ipv6HeaderLen, math.MaxUint16-udpHeaderLen
+	if len(query.src) == net.IPv4len {
+		header, limit = ipv4HeaderMin, math.MaxUint16-ipv4HeaderMin-udpHeaderLen
+	}
+
+	if len(payload) > limit {
+		return nil, fmt.Errorf("UDP payload of %d bytes exceeds the %d bytes a packet can carry", len(payload), limit)
+	}
+
+	packet := make([]byte, header+udpHeaderLen+len(payload))
+	udp := packet[header:]
+
+	if header == ipv4HeaderMin {
+		packet[0] = 0x45
+		binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)))
+		packet[6] = 0x40
+		packet[8] = 64
+		packet[9] = ipProtoUDP
+		copy(packet[12:], query.dst)
+		copy(packet[16:], query.src)
+		swiftutils.IPv4HeaderChecksum(packet)
+	} else {
+		packet[0] = 0x60
+		binary.BigEndian.PutUint16(packet[4:], uint16(len(udp)))
+		packet[6] = ipProtoUDP
+		packet[7] = 64
+		copy(packet[8:], query.dst)
+		copy(packet[24:], query.src)
+	}
+
+	binary.BigEndian.PutUint16(udp[0:], query.dstPort)
+	binary.BigEndian.PutUint16(udp[2:], query.srcPort)
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[udpHeaderLen:], payload)
+
+	sum := ^swiftutils.Checksum(udp, swiftutils.PseudoHeaderChecksum(ipProtoUDP, query.dst, query.src, uint16(len(udp))))
+	if sum == 0 {
+		sum = 0xFFFF
+	}
+	binary.BigEndian.PutUint16(udp[6:], sum)
+
+	return packet, nil
+}
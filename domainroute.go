@@ -0,0 +1,241 @@
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DomainRouterConfig describes the domains whose addresses a DomainRouter routes through the interface.
+type DomainRouterConfig struct {
+	// Domains are names such as "corp.example", matching only that name, or patterns such as "*.corp.example",
+	// matching every name below it.
+	Domains []string
+	// MinTTL keeps routes at least this long, so that short TTLs do not make them flap; zero honours the TTLs as is.
+	MinTTL time.Duration
+}
+
+// DomainRouter routes the addresses that DNS answers give for matching domains through a SwiftInterface, as host
+// routes removed once the TTL of every answer that installed them has expired. Responses are fed to Observe,
+// typically from the OnResponse callback of a swiftdns.Forwarder or a DNSInterceptor, which call it before the client
+// sees the answer.
+type DomainRouter struct {
+	iface  *SwiftInterface
+	exact  []string
+	suffix []string
+	minTTL time.Duration
+	mu     sync.Mutex
+	routes map[netip.Addr]time.Time
+	timer  *time.Timer
+	closed bool
+	now    func() time.Time
+}
+
+// NewDomainRouter returns a DomainRouter managing host routes of iface for the configured domains.
+func NewDomainRouter(iface *SwiftInterface, config *DomainRouterConfig) (*DomainRouter, error) {
+	if config == nil || len(config.Domains) == 0 {
+		return nil, errors.New("domain router needs at least one domain")
+	}
+
+	r := &DomainRouter{
+		iface:  iface,
+		minTTL: config.MinTTL,
+		routes: make(map[netip.Addr]time.Time),
+		now:    time.Now,
+	}
+
+	for _, domain := range config.Domains {
+		name := normalizeDomain(domain)
+
+		if suffix, ok := strings.CutPrefix(name, "*."); ok {
+			if suffix == "" || strings.Contains(suffix, "*") {
+				return nil, fmt.Errorf("invalid domain pattern %q", domain)
+			}
+			r.suffix = append(r.suffix, "."+suffix)
+			continue
+		}
+
+		if name == "" || strings.Contains(name, "*") {
+			return nil, fmt.Errorf("invalid domain %q", domain)
+		}
+		r.exact = append(r.exact, name)
+	}
+
+	return r, nil
+}
+
+// Observe routes the A and AAAA records of a wire-format DNS response through the interface when the question, or
+// the owner of the record, matches a configured domain, so that aliases pointing outside the domain are routed too.
+// Routes already installed are kept until the latest expiry.
+func (r *DomainRouter) Observe(response []byte) error {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(response); err != nil {
+		return fmt.Errorf("failed to parse DNS response: %w", err)
+	}
+
+	if !msg.Response || msg.RCode != dnsmessage.RCodeSuccess {
+		return nil
+	}
+
+	questionMatches := len(msg.Questions) == 1 && r.matches(msg.Questions[0].Name.String())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	now := r.now()
+	var errs []error
+
+	for _, answer := range msg.Answers {
+		var addr netip.Addr
+
+		switch body := answer.Body.(type) {
+		case *dnsmessage.AResource:
+			addr = netip.AddrFrom4(body.A)
+		case *dnsmessage.AAAAResource:
+			addr = netip.AddrFrom16(body.AAAA)
+		default:
+			continue
+		}
+
+		if !questionMatches && !r.matches(answer.Header.Name.String()) {
+			continue
+		}
+
+		expiry := now.Add(max(time.Duration(answer.Header.TTL)*time.Second, r.minTTL))
+
+		if current, ok := r.routes[addr]; ok {
+			if expiry.After(current) {
+				r.routes[addr] = expiry
+			}
+			continue
+		}
+
+		if err := r.iface.AddRoute(hostRoute(addr)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		r.routes[addr] = expiry
+	}
+
+	r.schedule()
+
+	return errors.Join(errs...)
+}
+
+// Routes returns the host routes currently installed.
+func (r *DomainRouter) Routes() []*net.IPNet {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	routes := make([]*net.IPNet, 0, len(r.routes))
+	for addr := range r.routes {
+		routes = append(routes, hostRoute(addr).Dst)
+	}
+
+	slices.SortFunc(routes, func(a, b *net.IPNet) int { return strings.Compare(a.String(), b.String()) })
+
+	return routes
+}
+
+// Close stops expiring routes and removes every route installed by the DomainRouter.
+func (r *DomainRouter) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+
+	var errs []error
+	for addr := range r.routes {
+		if err := r.iface.RemoveRoute(hostRoute(addr)); err != nil {
+			errs = append(errs, err)
+		}
+		delete(r.routes, addr)
+	}
+
+	return errors.Join(errs...)
+}
+
+// expire removes the routes whose TTL has run out and schedules the next expiry.
+func (r *DomainRouter) expire() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	now := r.now()
+	var errs []error
+
+	for addr, expiry := range r.routes {
+		if expiry.After(now) {
+			continue
+		}
+
+		if err := r.iface.RemoveRoute(hostRoute(addr)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		delete(r.routes, addr)
+	}
+
+	r.schedule()
+
+	return errors.Join(errs...)
+}
+
+// schedule arms the timer for the earliest expiry. The caller must hold the mutex.
+func (r *DomainRouter) schedule() {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+
+	var next time.Time
+	for _, expiry := range r.routes {
+		if next.IsZero() || expiry.Before(next) {
+			next = expiry
+		}
+	}
+
+	if next.IsZero() {
+		return
+	}
+
+	// Routes that failed to be removed are retried a second later.
+	r.timer = time.AfterFunc(max(next.Sub(r.now()), time.Second), func() { _ = r.expire() })
+}
+
+// matches reports whether name equals a configured domain or lies below a pattern.
+func (r *DomainRouter) matches(name string) bool {
+	name = normalizeDomain(name)
+
+	if slices.Contains(r.exact, name) {
+		return true
+	}
+
+	return slices.ContainsFunc(r.suffix, func(suffix string) bool { return strings.HasSuffix(name, suffix) })
+}
+
+// normalizeDomain lowercases name and drops its trailing dot.
+func normalizeDomain(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
+
+// hostRoute returns the /32 or /128 route to addr.
+func hostRoute(addr netip.Addr) *netlink.Route {
+	return &netlink.Route{Dst: prefixToIPNet(netip.PrefixFrom(addr, addr.BitLen()))}
+}
@@ -0,0 +1,33 @@
+package swiftunnel
+
+import (
+	"testing"
+)
+
+func TestDomainRouterMatches(t *testing.T) {
+	router, err := NewDomainRouter(nil, &DomainRouterConfig{Domains: []string{"*.corp.example", "VPN.example."}})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for name, expected := range map[string]bool{
+		"app.corp.example.":    true,
+		"a.b.corp.example":     true,
+		"APP.Corp.Example":     true,
+		"corp.example":         false,
+		"badcorp.example":      false,
+		"vpn.example.":         true,
+		"www.vpn.example":      false,
+		"app.corp.example.org": false,
+	} {
+		if matches := router.matches(name); matches != expected {
+			t.Fatalf("expected %s to match %v, got %v", name, expected, matches)
+		}
+	}
+
+	for _, domain := range []string{"", "*.", "*.*.example", "app.*.example"} {
+		if _, err := NewDomainRouter(nil, &DomainRouterConfig{Domains: []string{domain}}); err == nil {
+			t.Fatalf("expected an error for %q", domain)
+		}
+	}
+}
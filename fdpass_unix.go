@@ -0,0 +1,92 @@
+//go:build linux || darwin
+
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+)
+
+// maxPassedDescriptors bounds the number of queue descriptors accepted in a single message.
+const maxPassedDescriptors = 256
+
+// SendSwiftInterface passes the descriptors of a SwiftInterface, along with its name and adapter type, to the peer of
+// conn using SCM_RIGHTS. The sender keeps its own copy and may close it once the peer has received the interface.
+func SendSwiftInterface(conn *net.UnixConn, a *SwiftInterface) error {
+	name, err := a.GetAdapterName()
+	if err != nil {
+		return err
+	}
+
+	fds := a.descriptors()
+	for _, fd := range fds {
+		if fd < 0 {
+			return errors.New("interface descriptor is not available")
+		}
+	}
+
+	payload := append([]byte{byte(a.GetAdapterType())}, name...)
+
+	if _, _, err := conn.WriteMsgUnix(payload, unix.UnixRights(fds...), nil); err != nil {
+		return fmt.Errorf("failed to send interface descriptors: %w", err)
+	}
+
+	return nil
+}
+
+// ReceiveSwiftInterface receives a SwiftInterface sent with SendSwiftInterface and adopts its descriptors.
+func ReceiveSwiftInterface(conn *net.UnixConn) (*SwiftInterface, error) {
+	payload := make([]byte, 1+unix.IFNAMSIZ)
+	oob := make([]byte, unix.CmsgSpace(4*maxPassedDescriptors))
+
+	n, oobn, flags, _, err := conn.ReadMsgUnix(payload, oob)
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive interface descriptors: %w", err)
+	}
+
+	fds, err := parseRights(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+
+	if flags&unix.MSG_CTRUNC != 0 || n < 2 {
+		closeDescriptors(fds)
+		return nil, errors.New("truncated interface descriptor message")
+	}
+
+	return adoptInterface(fds, string(payload[1:n]), swiftypes.AdapterType(payload[0]))
+}
+
+// parseRights extracts every descriptor carried by SCM_RIGHTS control messages.
+func parseRights(oob []byte) ([]int, error) {
+	messages, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, os.NewSyscallError("parse control message", err)
+	}
+
+	var fds []int
+	for _, message := range messages {
+		rights, err := unix.ParseUnixRights(&message)
+		if err != nil {
+			continue
+		}
+		fds = append(fds, rights...)
+	}
+
+	if len(fds) == 0 {
+		return nil, errors.New("message did not carry any descriptor")
+	}
+
+	return fds, nil
+}
+
+// closeDescriptors closes raw descriptors that were not adopted by a SwiftInterface.
+func closeDescriptors(fds []int) {
+	for _, fd := range fds {
+		_ = unix.Close(fd)
+	}
+}
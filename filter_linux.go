@@ -0,0 +1,334 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"golang.org/x/sys/unix"
+	"net"
+	"unsafe"
+)
+
+const (
+	// filterAccept is returned by filters to keep the whole packet.
+	filterAccept = 0x40000
+	// filterMaxInstructions mirrors the kernel's BPF_MAXINSNS limit.
+	filterMaxInstructions = 4096
+	// ethernetHeaderLen is the offset of the IP header in TAP frames.
+	ethernetHeaderLen = 14
+	// tunFilterAllMulti is TUN_FLT_ALLMULTI, which lets every multicast frame through a TX filter.
+	tunFilterAllMulti = 0x0001
+)
+
+var (
+	ErrEmptyFilter = errors.New("filter program is empty")
+	ErrNotTAP      = errors.New("operation requires a TAP adapter")
+)
+
+// FilterRule selects packets by transport protocol, ports and address prefixes.
+// Zero-valued fields match anything; every set field must match for the rule to match.
+type FilterRule struct {
+	Protocol int
+	SrcPort  uint16
+	DstPort  uint16
+	Src      *net.IPNet
+	Dst      *net.IPNet
+}
+
+// BuildFilter compiles rules into a classic BPF program for TAP frames that accepts packets matching any rule and
+// drops the rest. Ports are matched on the transport header that directly follows the IP header, so IPv6 extension
+// headers and IPv4 fragments never match a port rule.
+func BuildFilter(rules ...FilterRule) ([]unix.SockFilter, error) {
+	if len(rules) == 0 {
+		return nil, errors.New("at least one filter rule is required")
+	}
+
+	var program []unix.SockFilter
+
+	for i, rule := range rules {
+		families, err := rule.families()
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+
+		for _, family := range families {
+			block, err := rule.compile(family)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i, err)
+			}
+			program = append(program, block...)
+		}
+	}
+
+	program = append(program, bpfStmt(unix.BPF_RET|unix.BPF_K, 0))
+
+	if len(program) > filterMaxInstructions {
+		return nil, fmt.Errorf("filter program of %d instructions exceeds the limit of %d", len(program), filterMaxInstructions)
+	}
+
+	return program, nil
+}
+
+// families returns the IP versions a rule applies to, derived from its prefixes.
+func (r *FilterRule) families() ([]int, error) {
+	family := 0
+
+	for _, prefix := range []*net.IPNet{r.Src, r.Dst} {
+		if prefix == nil {
+			continue
+		}
+
+		prefixFamily := 6
+		if prefix.IP.To4() != nil {
+			prefixFamily = 4
+		}
+
+		if family != 0 && family != prefixFamily {
+			return nil, errors.New("source and destination prefixes must share an address family")
+		}
+		family = prefixFamily
+	}
+
+	if family == 0 {
+		return []int{4, 6}, nil
+	}
+
+	return []int{family}, nil
+}
+
+// filterBlock assembles the instructions of one rule, tracking jumps that leave the rule on mismatch.
+type filterBlock struct {
+	insns []unix.SockFilter
+	fails []filterJump
+}
+
+// filterJump records a conditional jump whose true or false branch must skip to the next rule.
+type filterJump struct {
+	index  int
+	onTrue bool
+}
+
+// load emits an absolute or indexed load.
+func (b *filterBlock) load(code uint16, k uint32) {
+	b.insns = append(b.insns, bpfStmt(code, k))
+}
+
+// requireEqual continues when the accumulator equals k and skips to the next rule otherwise.
+func (b *filterBlock) requireEqual(k uint32) {
+	b.fails = append(b.fails, filterJump{index: len(b.insns)})
+	b.insns = append(b.insns, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, K: k})
+}
+
+// requireMasked continues when the accumulator masked with mask equals value.
+func (b *filterBlock) requireMasked(mask, value uint32) {
+	if mask != 0xFFFFFFFF {
+		b.insns = append(b.insns, bpfStmt(unix.BPF_ALU|unix.BPF_AND|unix.BPF_K, mask))
+	}
+	b.requireEqual(value & mask)
+}
+
+// requireClear continues when none of the bits of k are set in the accumulator.
+func (b *filterBlock) requireClear(k uint32) {
+	b.fails = append(b.fails, filterJump{index: len(b.insns), onTrue: true})
+	b.insns = append(b.insns, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JSET | unix.BPF_K, K: k})
+}
+
+// requireOneOf continues when the accumulator equals first or second.
+func (b *filterBlock) requireOneOf(first, second uint32) {
+	b.insns = append(b.insns, unix.SockFilter{Code: unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K, Jt: 1, K: first})
+	b.requireEqual(second)
+}
+
+// finish appends the accept return and resolves every mismatch jump to the instruction after it.
+func (b *filterBlock) finish() ([]unix.SockFilter, error) {
+	b.insns = append(b.insns, bpfStmt(unix.BPF_RET|unix.BPF_K, filterAccept))
+	target := len(b.insns)
+
+	for _, jump := range b.fails {
+		offset := target - jump.index - 1
+		if offset > 0xFF {
+			return nil, errors.New("filter rule is too long")
+		}
+
+		if jump.onTrue {
+			b.insns[jump.index].Jt = uint8(offset)
+		} else {
+			b.insns[jump.index].Jf = uint8(offset)
+		}
+	}
+
+	return b.insns, nil
+}
+
+// compile emits the instructions matching the rule for one IP version.
+func (r *FilterRule) compile(family int) ([]unix.SockFilter, error) {
+	var b filterBlock
+
+	const base uint32 = ethernetHeaderLen
+
+	etherType := uint32(unix.ETH_P_IP)
+	if family == 6 {
+		etherType = unix.ETH_P_IPV6
+	}
+
+	b.load(unix.BPF_LD|unix.BPF_H|unix.BPF_ABS, 12)
+	b.requireEqual(etherType)
+
+	protocolOffset := base + 9
+	if family == 6 {
+		protocolOffset = base + 6
+	}
+
+	if r.Protocol != 0 {
+		b.load(unix.BPF_LD|unix.BPF_B|unix.BPF_ABS, protocolOffset)
+		b.requireEqual(uint32(r.Protocol))
+	}
+
+	if err := r.compilePrefix(&b, family, r.Src, 12, 8); err != nil {
+		return nil, err
+	}
+	if err := r.compilePrefix(&b, family, r.Dst, 16, 24); err != nil {
+		return nil, err
+	}
+
+	if r.SrcPort != 0 || r.DstPort != 0 {
+		if r.Protocol == 0 {
+			b.load(unix.BPF_LD|unix.BPF_B|unix.BPF_ABS, protocolOffset)
+			b.requireOneOf(unix.IPPROTO_TCP, unix.IPPROTO_UDP)
+		} else if r.Protocol != unix.IPPROTO_TCP && r.Protocol != unix.IPPROTO_UDP && r.Protocol != unix.IPPROTO_SCTP {
+			return nil, errors.New("ports require a TCP, UDP or SCTP protocol")
+		}
+
+		loadPort := func(offset uint32) {
+			if family == 4 {
+				b.load(unix.BPF_LD|unix.BPF_H|unix.BPF_IND, base+offset)
+			} else {
+				b.load(unix.BPF_LD|unix.BPF_H|unix.BPF_ABS, base+ipv6HeaderLen+offset)
+			}
+		}
+
+		if family == 4 {
+			b.load(unix.BPF_LD|unix.BPF_H|unix.BPF_ABS, base+6)
+			b.requireClear(0x1FFF)
+			b.load(unix.BPF_LDX|unix.BPF_B|unix.BPF_MSH, base)
+		}
+
+		if r.SrcPort != 0 {
+			loadPort(0)
+			b.requireEqual(uint32(r.SrcPort))
+		}
+		if r.DstPort != 0 {
+			loadPort(2)
+			b.requireEqual(uint32(r.DstPort))
+		}
+	}
+
+	return b.finish()
+}
+
+// compilePrefix emits the word comparisons of an address prefix at the IPv4 or IPv6 address offset.
+func (r *FilterRule) compilePrefix(b *filterBlock, family int, prefix *net.IPNet, offset4, offset6 uint32) error {
+	if prefix == nil {
+		return nil
+	}
+
+	ip, mask, offset := prefix.IP.To4(), prefix.Mask, offset4
+	if family == 6 {
+		ip, offset = prefix.IP.To16(), offset6
+	}
+
+	if ip == nil || len(mask) != len(ip) {
+		return fmt.Errorf("invalid prefix %v", prefix)
+	}
+
+	for word := 0; word < len(ip); word += 4 {
+		wordMask := binary.BigEndian.Uint32(mask[word:])
+		if wordMask == 0 {
+			continue
+		}
+
+		b.load(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, ethernetHeaderLen+offset+uint32(word))
+		b.requireMasked(wordMask, binary.BigEndian.Uint32(ip[word:]))
+	}
+
+	return nil
+}
+
+// bpfStmt builds a classic BPF instruction without jump targets.
+func bpfStmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+// AttachFilter installs a classic BPF program that the kernel runs on every frame before queueing it for Read.
+// Frames for which the program returns zero are dropped. The filter applies to every queue of the device; the
+// kernel only supports it on TAP adapters.
+func (a *SwiftInterface) AttachFilter(program []unix.SockFilter) error {
+	if a.adapterType != swiftypes.AdapterTypeTAP {
+		return ErrNotTAP
+	}
+
+	if len(program) == 0 {
+		return ErrEmptyFilter
+	}
+
+	fprog := unix.SockFprog{
+		Len:    uint16(len(program)),
+		Filter: &program[0],
+	}
+
+	if err := ioctl(a.queues[0].Fd(), unix.TUNATTACHFILTER, uintptr(unsafe.Pointer(&fprog))); err != nil {
+		return fmt.Errorf("failed to attach filter: %w", err)
+	}
+
+	return nil
+}
+
+// DetachFilter removes the BPF program installed with AttachFilter.
+func (a *SwiftInterface) DetachFilter() error {
+	if a.adapterType != swiftypes.AdapterTypeTAP {
+		return ErrNotTAP
+	}
+
+	var fprog unix.SockFprog
+
+	if err := ioctl(a.queues[0].Fd(), unix.TUNDETACHFILTER, uintptr(unsafe.Pointer(&fprog))); err != nil {
+		return fmt.Errorf("failed to detach filter: %w", err)
+	}
+
+	return nil
+}
+
+// SetTxFilter restricts the frames delivered by a TAP device to the given destination MAC addresses, plus every
+// multicast frame when allMulticast is set. Passing no address and allMulticast false disables filtering.
+func (a *SwiftInterface) SetTxFilter(addrs []net.HardwareAddr, allMulticast bool) error {
+	if a.adapterType != swiftypes.AdapterTypeTAP {
+		return ErrNotTAP
+	}
+
+	// struct tun_filter { __u16 flags; __u16 count; __u8 addr[][ETH_ALEN]; }
+	buf := make([]byte, 4+len(addrs)*6)
+
+	var flags uint16
+	if allMulticast {
+		flags = tunFilterAllMulti
+	}
+
+	binary.NativeEndian.PutUint16(buf[0:], flags)
+	binary.NativeEndian.PutUint16(buf[2:], uint16(len(addrs)))
+
+	for i, addr := range addrs {
+		if len(addr) != 6 {
+			return fmt.Errorf("invalid MAC address %v", addr)
+		}
+		copy(buf[4+i*6:], addr)
+	}
+
+	if err := ioctl(a.queues[0].Fd(), unix.TUNSETTXFILTER, uintptr(unsafe.Pointer(&buf[0]))); err != nil {
+		return fmt.Errorf("failed to set TX filter: %w", err)
+	}
+
+	return nil
+}
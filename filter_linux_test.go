@@ -0,0 +1,290 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftconfig"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"net"
+	"testing"
+	"time"
+)
+
+// runFilter interprets the subset of classic BPF emitted by BuildFilter.
+func runFilter(t *testing.T, program []unix.SockFilter, pkt []byte) uint32 {
+	t.Helper()
+
+	var a, x uint32
+
+	load := func(offset uint32, size uint32) (uint32, bool) {
+		if int(offset+size) > len(pkt) {
+			return 0, false
+		}
+
+		switch size {
+		case 1:
+			return uint32(pkt[offset]), true
+		case 2:
+			return uint32(binary.BigEndian.Uint16(pkt[offset:])), true
+		default:
+			return binary.BigEndian.Uint32(pkt[offset:]), true
+		}
+	}
+
+	sizes := map[uint16]uint32{unix.BPF_B: 1, unix.BPF_H: 2, unix.BPF_W: 4}
+
+	for pc := 0; pc < len(program); pc++ {
+		insn := program[pc]
+
+		switch {
+		case insn.Code&0x07 == unix.BPF_LD && insn.Code&0xE0 == unix.BPF_ABS:
+			v, ok := load(insn.K, sizes[insn.Code&0x18])
+			if !ok {
+				return 0
+			}
+			a = v
+		case insn.Code&0x07 == unix.BPF_LD && insn.Code&0xE0 == unix.BPF_IND:
+			v, ok := load(x+insn.K, sizes[insn.Code&0x18])
+			if !ok {
+				return 0
+			}
+			a = v
+		case insn.Code == unix.BPF_LDX|unix.BPF_B|unix.BPF_MSH:
+			v, ok := load(insn.K, 1)
+			if !ok {
+				return 0
+			}
+			x = (v & 0x0F) * 4
+		case insn.Code == unix.BPF_ALU|unix.BPF_AND|unix.BPF_K:
+			a &= insn.K
+		case insn.Code == unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K:
+			if a == insn.K {
+				pc += int(insn.Jt)
+			} else {
+				pc += int(insn.Jf)
+			}
+		case insn.Code == unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K:
+			if a&insn.K != 0 {
+				pc += int(insn.Jt)
+			} else {
+				pc += int(insn.Jf)
+			}
+		case insn.Code == unix.BPF_RET|unix.BPF_K:
+			return insn.K
+		default:
+			t.Fatalf("unexpected instruction %+v", insn)
+		}
+	}
+
+	t.Fatal("program fell off the end")
+
+	return 0
+}
+
+// ethernetFrame prepends an ethernet header carrying the EtherType of pkt.
+func ethernetFrame(pkt []byte) []byte {
+	frame := make([]byte, ethernetHeaderLen, ethernetHeaderLen+len(pkt))
+
+	etherType := uint16(unix.ETH_P_IP)
+	if pkt[0]>>4 == 6 {
+		etherType = unix.ETH_P_IPV6
+	}
+	binary.BigEndian.PutUint16(frame[12:], etherType)
+
+	return append(frame, pkt...)
+}
+
+func TestBuildFilter(t *testing.T) {
+	_, dst4, _ := net.ParseCIDR("10.0.0.0/24")
+	_, other4, _ := net.ParseCIDR("10.1.0.0/16")
+	_, dst6, _ := net.ParseCIDR("fd00::/64")
+
+	udp4 := buildTestPacket(4, unix.IPPROTO_UDP, 0, 0, []byte("payload"))
+	tcp4 := buildTestPacket(4, unix.IPPROTO_TCP, 1, tcpFlagACK, []byte("payload"))
+	udp6 := buildTestPacket(6, unix.IPPROTO_UDP, 0, 0, []byte("payload"))
+
+	fragment := append([]byte(nil), udp4...)
+	fragment[6], fragment[7] = 0x00, 0x10
+
+	tests := []struct {
+		name  string
+		rules []FilterRule
+		pkt   []byte
+		match bool
+	}{
+		{"protocol", []FilterRule{{Protocol: unix.IPPROTO_UDP}}, udp4, true},
+		{"protocol mismatch", []FilterRule{{Protocol: unix.IPPROTO_UDP}}, tcp4, false},
+		{"protocol ipv6", []FilterRule{{Protocol: unix.IPPROTO_UDP}}, udp6, true},
+		{"destination port", []FilterRule{{DstPort: 443}}, tcp4, true},
+		{"source port mismatch", []FilterRule{{SrcPort: 443}}, udp6, false},
+		{"port on fragment", []FilterRule{{DstPort: 443}}, fragment, false},
+		{"destination prefix", []FilterRule{{Dst: dst4}}, udp4, true},
+		{"source prefix mismatch", []FilterRule{{Src: other4}}, udp4, false},
+		{"ipv4 prefix skips ipv6", []FilterRule{{Dst: dst4}}, udp6, false},
+		{"ipv6 prefix", []FilterRule{{Protocol: unix.IPPROTO_UDP, Dst: dst6, DstPort: 443}}, udp6, true},
+		{"any rule", []FilterRule{{Src: other4}, {Protocol: unix.IPPROTO_TCP, DstPort: 443}}, tcp4, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			program, err := BuildFilter(tt.rules...)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if got := runFilter(t, program, ethernetFrame(tt.pkt)) != 0; got != tt.match {
+				t.Fatalf("expected match %v, got %v", tt.match, got)
+			}
+		})
+	}
+
+	t.Run("non-IP frame", func(t *testing.T) {
+		program, err := BuildFilter(FilterRule{})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		frame := make([]byte, 60)
+		binary.BigEndian.PutUint16(frame[12:], unix.ETH_P_ARP)
+
+		if runFilter(t, program, frame) != 0 {
+			t.Fatal("expected ARP frame to be dropped")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := BuildFilter(); err == nil {
+			t.Fatal("expected error for empty rule set")
+		}
+
+		if _, err := BuildFilter(FilterRule{Src: dst4, Dst: dst6}); err == nil {
+			t.Fatal("expected error for mixed address families")
+		}
+
+		if _, err := BuildFilter(FilterRule{Protocol: unix.IPPROTO_ICMP, DstPort: 1}); err == nil {
+			t.Fatal("expected error for ports without a transport protocol")
+		}
+	})
+}
+
+func TestAttachFilter(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tapfilter0",
+		AdapterType:   swiftypes.AdapterTypeTAP,
+		MTU:           1500,
+		UnicastConfig: testUnicastConfig(t, "10.195.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error setting status, got %v", err)
+	}
+
+	index, err := adapter.GetAdapterIndex()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	mac, _ := net.ParseMAC("02:00:00:00:00:02")
+
+	// A permanent neighbor lets packets leave without waiting for ARP, which the filter would drop.
+	if err := netlink.NeighAdd(&netlink.Neigh{
+		LinkIndex:    index,
+		Family:       netlink.FAMILY_V4,
+		State:        netlink.NUD_PERMANENT,
+		IP:           net.ParseIP("10.195.0.2"),
+		HardwareAddr: mac,
+	}); err != nil {
+		t.Fatalf("expected no error adding neighbor, got %v", err)
+	}
+
+	program, err := BuildFilter(FilterRule{Protocol: unix.IPPROTO_UDP, DstPort: 9999})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.AttachFilter(program); err != nil {
+		t.Fatalf("expected no error attaching filter, got %v", err)
+	}
+
+	for _, port := range []string{"9998", "9999"} {
+		conn, err := net.Dial("udp4", net.JoinHostPort("10.195.0.2", port))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if _, err := conn.Write([]byte("swiftunnel")); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		_ = conn.Close()
+	}
+
+	if err := adapter.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	buf := make([]byte, 2048)
+
+	n, err := adapter.Read(buf)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	pkt := buf[ethernetHeaderLen:n]
+	if port := binary.BigEndian.Uint16(pkt[ipv4HeaderMin+2:]); packetProtocol(pkt) != unix.IPPROTO_UDP || port != 9999 {
+		t.Fatalf("expected only UDP packets to port 9999, got %x", buf[:n])
+	}
+
+	if err := adapter.DetachFilter(); err != nil {
+		t.Fatalf("expected no error detaching filter, got %v", err)
+	}
+}
+
+func TestFilterRequiresTAP(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tunfilter1",
+		AdapterType: swiftypes.AdapterTypeTUN,
+		MTU:         1500,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.AttachFilter([]unix.SockFilter{bpfStmt(unix.BPF_RET|unix.BPF_K, 0)}); !errors.Is(err, ErrNotTAP) {
+		t.Fatalf("expected ErrNotTAP, got %v", err)
+	}
+
+	if err := adapter.SetTxFilter(nil, false); !errors.Is(err, ErrNotTAP) {
+		t.Fatalf("expected ErrNotTAP, got %v", err)
+	}
+}
+
+func TestSetTxFilter(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tapfilter1",
+		AdapterType: swiftypes.AdapterTypeTAP,
+		MTU:         1500,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+
+	if err := adapter.SetTxFilter([]net.HardwareAddr{mac}, true); err != nil {
+		t.Fatalf("expected no error setting TX filter, got %v", err)
+	}
+
+	if err := adapter.SetTxFilter(nil, false); err != nil {
+		t.Fatalf("expected no error clearing TX filter, got %v", err)
+	}
+}
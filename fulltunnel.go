@@ -0,0 +1,124 @@
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"net"
+)
+
+var (
+	// fullTunnelIPv4 covers the IPv4 space with two halves, which win over the default route without replacing it.
+	fullTunnelIPv4 = []string{"0.0.0.0/1", "128.0.0.0/1"}
+	// fullTunnelIPv6 covers the IPv6 space the same way.
+	fullTunnelIPv6 = []string{"::/1", "8000::/1"}
+)
+
+// FullTunnelConfig selects the address families sent through the interface and the VPN endpoints exempted from it.
+type FullTunnelConfig struct {
+	// Endpoints are the addresses of the VPN servers, kept reachable through the original default gateway.
+	Endpoints []net.IP
+	IPv4      bool
+	IPv6      bool
+}
+
+// EnableFullTunnel sends all traffic of the selected families through the interface, except the endpoints, which get
+// host routes via the gateway and interface of the default route of their family.
+// The routes are removed on DisableFullTunnel or Close, restoring the previous routing table.
+func (a *SwiftInterface) EnableFullTunnel(config *FullTunnelConfig) error {
+	if config == nil {
+		return errors.New("full tunnel config cannot be nil")
+	}
+
+	for _, endpoint := range config.Endpoints {
+		route, err := a.endpointRoute(endpoint)
+		if err != nil {
+			return err
+		}
+
+		if err := a.addBypassRoute(route); err != nil {
+			return fmt.Errorf("failed to exempt endpoint %v: %w", endpoint, err)
+		}
+
+		a.changes.record(bypassRouteKey(endpoint), func() error {
+			return a.removeBypassRoute(route)
+		})
+	}
+
+	for _, dst := range fullTunnelPrefixes(config) {
+		if err := a.AddRoute(&netlink.Route{Dst: dst}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DisableFullTunnel removes the routes installed by EnableFullTunnel with the same config.
+func (a *SwiftInterface) DisableFullTunnel(config *FullTunnelConfig) error {
+	if config == nil {
+		return errors.New("full tunnel config cannot be nil")
+	}
+
+	var errs []error
+
+	for _, dst := range fullTunnelPrefixes(config) {
+		if err := a.RemoveRoute(&netlink.Route{Dst: dst}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// The routes installed by EnableFullTunnel are removed as recorded, even if the default gateway changed since.
+	for _, endpoint := range config.Endpoints {
+		if err := a.changes.undo(bypassRouteKey(endpoint)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// fullTunnelPrefixes returns the prefixes routed through the interface for the families selected by config.
+func fullTunnelPrefixes(config *FullTunnelConfig) []*net.IPNet {
+	var cidrs []string
+	if config.IPv4 {
+		cidrs = append(cidrs, fullTunnelIPv4...)
+	}
+	if config.IPv6 {
+		cidrs = append(cidrs, fullTunnelIPv6...)
+	}
+
+	prefixes := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, prefix, _ := net.ParseCIDR(cidr)
+		prefixes = append(prefixes, prefix)
+	}
+
+	return prefixes
+}
+
+// endpointRoute builds the host route sending endpoint through the default gateway of its family.
+func (a *SwiftInterface) endpointRoute(endpoint net.IP) (*netlink.Route, error) {
+	bits := 8 * net.IPv6len
+	if ipv4 := endpoint.To4(); ipv4 != nil {
+		endpoint, bits = ipv4, 8*net.IPv4len
+	} else if endpoint.To16() == nil {
+		return nil, fmt.Errorf("invalid endpoint address: %v", endpoint)
+	}
+
+	gw, linkIndex, err := a.defaultGateway(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover gateway for endpoint %v: %w", endpoint, err)
+	}
+
+	return &netlink.Route{
+		LinkIndex: linkIndex,
+		Dst:       &net.IPNet{IP: endpoint, Mask: net.CIDRMask(bits, bits)},
+		Gw:        gw,
+	}, nil
+}
+
+// bypassRouteKey identifies the route of an endpoint in the change log.
+func bypassRouteKey(endpoint net.IP) string {
+	return "bypass " + endpoint.String()
+}
@@ -5,17 +5,18 @@ package gateway
 import (
 	"fmt"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 	"net"
 )
 
 // DiscoverGatewayIPv4 finds the IPv4 default gateway on Unix-like systems.
 func DiscoverGatewayIPv4() (net.IP, error) {
-	return discoverGateway(netlink.FAMILY_V4)
+	return discoverGateway(unix.AF_INET)
 }
 
 // DiscoverGatewayIPv6 finds the IPv6 default gateway on Unix-like systems.
 func DiscoverGatewayIPv6() (net.IP, error) {
-	return discoverGateway(netlink.FAMILY_V6)
+	return discoverGateway(unix.AF_INET6)
 }
 
 // discoverGateway iterates through the routing table to find the gateway for the specified family.
@@ -6,4 +6,17 @@ require golang.org/x/sys v0.39.0
 
 require github.com/vishvananda/netlink v1.3.1
 
-require github.com/vishvananda/netns v0.0.5 // indirect
+require github.com/vishvananda/netns v0.0.5
+
+require github.com/google/nftables v0.3.0
+
+require github.com/godbus/dbus/v5 v5.2.2
+
+require golang.org/x/net v0.33.0
+
+require (
+	github.com/google/go-cmp v0.6.0 // indirect
+	github.com/mdlayher/netlink v1.7.3-0.20250113171957-fbb4dce95f42 // indirect
+	github.com/mdlayher/socket v0.5.0 // indirect
+	golang.org/x/sync v0.6.0 // indirect
+)
@@ -0,0 +1,215 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	"net"
+)
+
+const (
+	// DefaultKillSwitchTable is the nftables table used when KillSwitchConfig.Table is empty.
+	DefaultKillSwitchTable = "swiftunnel-killswitch"
+	// killSwitchChangeKey identifies the kill switch in the change log.
+	killSwitchChangeKey = "kill switch"
+)
+
+// dhcpDestination is a destination prefix and UDP port of DHCP messages.
+type dhcpDestination struct {
+	prefix string
+	port   uint16
+}
+
+var (
+	// dhcpOutput are the DHCPv4 broadcasts and DHCPv6 multicasts to servers, which the kill switch always allows so
+	// that the uplinks keep their leases without opening a path to arbitrary hosts.
+	dhcpOutput = []dhcpDestination{{"255.255.255.255/32", 67}, {"ff02::1:2/128", 547}}
+	// dhcpInput are the server replies to DHCPv4 and DHCPv6 clients, the latter sent to link-local addresses.
+	dhcpInput = []dhcpDestination{{"0.0.0.0/0", 68}, {"fe80::/10", 546}}
+)
+
+// lanPrefixes are the private and link-local prefixes allowed by KillSwitchConfig.AllowLAN.
+var lanPrefixes = []string{
+	"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "169.254.0.0/16", "224.0.0.0/4", "255.255.255.255/32",
+	"fc00::/7", "fe80::/10", "ff00::/8",
+}
+
+// KillSwitchEndpoint is a VPN server reachable outside the tunnel while the kill switch is armed.
+// A zero Protocol allows every protocol and a zero Port every port of the protocol.
+type KillSwitchEndpoint struct {
+	IP       net.IP
+	Protocol int
+	Port     uint16
+}
+
+// KillSwitchConfig describes the traffic allowed by the kill switch besides loopback and the tunnel interface.
+type KillSwitchConfig struct {
+	Endpoints []KillSwitchEndpoint
+	// AllowLAN lets traffic to and from private, link-local and multicast prefixes through.
+	AllowLAN bool
+	// Table names the nftables table, so that a later process can re-arm or remove the same kill switch.
+	Table string
+}
+
+// ArmKillSwitch installs an inet nftables table dropping every packet that does not use loopback or the interface,
+// does not go to an endpoint, or, with AllowLAN, stay on the local network. Forwarded packets, such as those of
+// containers or virtual machines behind the host, are held to the same rules, judged by their destination. ICMPv6
+// neighbor discovery and DHCP to link-local destinations are always allowed. Replies to allowed connections are
+// accepted. Arming again atomically replaces the table, e.g. with the endpoints of a reconnect. The table is removed
+// on DisarmKillSwitch or Close, unless KeepKillSwitch is called.
+func (a *SwiftInterface) ArmKillSwitch(config *KillSwitchConfig) error {
+	if config == nil {
+		return errors.New("kill switch config cannot be nil")
+	}
+
+	for _, endpoint := range config.Endpoints {
+		if endpoint.IP == nil {
+			return errors.New("kill switch endpoint cannot be nil")
+		}
+	}
+
+	conn, err := nftConn(a.ns)
+	if err != nil {
+		return err
+	}
+
+	table := killSwitchTable(config.Table)
+	replaceNftTable(conn, table)
+
+	drop := nftables.ChainPolicyDrop
+	input := conn.AddChain(&nftables.Chain{
+		Name:     "input",
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookInput,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   &drop,
+	})
+	output := conn.AddChain(&nftables.Chain{
+		Name:     "output",
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookOutput,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   &drop,
+	})
+	forward := conn.AddChain(&nftables.Chain{
+		Name:     "forward",
+		Table:    table,
+		Type:     nftables.ChainTypeFilter,
+		Hooknum:  nftables.ChainHookForward,
+		Priority: nftables.ChainPriorityFilter,
+		Policy:   &drop,
+	})
+
+	inputRules := [][]expr.Any{
+		verdict(expr.VerdictAccept, matchInterfaceName(expr.MetaKeyIIFNAME, "lo")),
+		verdict(expr.VerdictAccept, matchInterfaceName(expr.MetaKeyIIFNAME, a.name)),
+		verdict(expr.VerdictAccept, matchEstablished()),
+	}
+	outputRules := [][]expr.Any{
+		verdict(expr.VerdictAccept, matchInterfaceName(expr.MetaKeyOIFNAME, "lo")),
+		verdict(expr.VerdictAccept, matchInterfaceName(expr.MetaKeyOIFNAME, a.name)),
+	}
+	forwardRules := [][]expr.Any{
+		verdict(expr.VerdictAccept, matchInterfaceName(expr.MetaKeyIIFNAME, a.name)),
+		verdict(expr.VerdictAccept, matchInterfaceName(expr.MetaKeyOIFNAME, a.name)),
+		verdict(expr.VerdictAccept, matchEstablished()),
+	}
+
+	// Neighbor discovery and DHCP keep the uplinks, and therefore the endpoints, reachable without AllowLAN.
+	inputRules = append(inputRules, verdict(expr.VerdictAccept, matchNeighborDiscovery()))
+	outputRules = append(outputRules, verdict(expr.VerdictAccept, matchNeighborDiscovery()))
+
+	for _, dhcp := range dhcpInput {
+		_, prefix, _ := net.ParseCIDR(dhcp.prefix)
+		inputRules = append(inputRules, verdict(expr.VerdictAccept,
+			matchPrefix(prefix, false), matchDestinationPort(unix.IPPROTO_UDP, dhcp.port)))
+	}
+	for _, dhcp := range dhcpOutput {
+		_, prefix, _ := net.ParseCIDR(dhcp.prefix)
+		outputRules = append(outputRules, verdict(expr.VerdictAccept,
+			matchPrefix(prefix, false), matchDestinationPort(unix.IPPROTO_UDP, dhcp.port)))
+	}
+
+	for _, endpoint := range config.Endpoints {
+		bits := 8 * len(endpoint.IP)
+		if ipv4 := endpoint.IP.To4(); ipv4 != nil {
+			bits = 8 * net.IPv4len
+		}
+
+		host := &net.IPNet{IP: endpoint.IP, Mask: net.CIDRMask(bits, bits)}
+		rule := verdict(expr.VerdictAccept, matchPrefix(host, false), matchDestinationPort(endpoint.Protocol, endpoint.Port))
+		outputRules = append(outputRules, rule)
+		forwardRules = append(forwardRules, rule)
+	}
+
+	if config.AllowLAN {
+		for _, cidr := range lanPrefixes {
+			_, prefix, _ := net.ParseCIDR(cidr)
+			inputRules = append(inputRules, verdict(expr.VerdictAccept, matchPrefix(prefix, true)))
+			outputRules = append(outputRules, verdict(expr.VerdictAccept, matchPrefix(prefix, false)))
+			// Forwarded sources are private too, so only the destination tells local traffic apart.
+			forwardRules = append(forwardRules, verdict(expr.VerdictAccept, matchPrefix(prefix, false)))
+		}
+	}
+
+	for _, rule := range inputRules {
+		conn.AddRule(&nftables.Rule{Table: table, Chain: input, Exprs: rule})
+	}
+	for _, rule := range outputRules {
+		conn.AddRule(&nftables.Rule{Table: table, Chain: output, Exprs: rule})
+	}
+	for _, rule := range forwardRules {
+		conn.AddRule(&nftables.Rule{Table: table, Chain: forward, Exprs: rule})
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to arm kill switch: %w", err)
+	}
+
+	ns := a.ns
+	a.changes.forget(killSwitchChangeKey)
+	a.changes.record(killSwitchChangeKey, func() error {
+		return deleteNftTable(ns, table)
+	})
+
+	return nil
+}
+
+// DisarmKillSwitch removes the kill switch armed through the interface, restoring normal connectivity.
+func (a *SwiftInterface) DisarmKillSwitch() error {
+	return a.changes.undo(killSwitchChangeKey)
+}
+
+// KeepKillSwitch leaves the kill switch armed after Close, e.g. while the tunnel reconnects.
+// It can later be re-armed with new endpoints or removed with RemoveKillSwitch.
+func (a *SwiftInterface) KeepKillSwitch() {
+	a.changes.forget(killSwitchChangeKey)
+}
+
+// KillSwitchArmed reports whether the kill switch table called table, or DefaultKillSwitchTable when empty, exists
+// in the caller's network namespace.
+func KillSwitchArmed(table string) (bool, error) {
+	return nftTableExists(netns.None(), killSwitchTable(table).Name, nftables.TableFamilyINet)
+}
+
+// RemoveKillSwitch removes a kill switch left armed by KeepKillSwitch, possibly by another process, from the caller's
+// network namespace.
+func RemoveKillSwitch(table string) error {
+	return deleteNftTable(netns.None(), killSwitchTable(table))
+}
+
+// killSwitchTable returns the inet table called name, or DefaultKillSwitchTable when empty.
+func killSwitchTable(name string) *nftables.Table {
+	if name == "" {
+		name = DefaultKillSwitchTable
+	}
+
+	return &nftables.Table{Name: name, Family: nftables.TableFamilyINet}
+}
@@ -0,0 +1,166 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftconfig"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"github.com/vishvananda/netlink"
+	"slices"
+	"strings"
+)
+
+const (
+	// persistentAltNamePrefix starts the alternative name tagging persistent devices created by swiftunnel. It is
+	// added next to the names and alias set by users or other tools, which are left untouched.
+	persistentAltNamePrefix = "swiftunnel-"
+	// persistentAlias is the link alias earlier versions tagged persistent devices with, still recognized and used
+	// when the kernel does not support alternative names.
+	persistentAlias = "swiftunnel"
+)
+
+var (
+	ErrNotPersistentInterface = errors.New("interface is not a persistent swiftunnel device")
+)
+
+// PersistentInterface describes a persistent TUN/TAP device left behind by swiftunnel.
+type PersistentInterface struct {
+	Name        string
+	Index       int
+	AdapterType swiftypes.AdapterType
+	MultiQueue  bool
+	VnetHdr     bool
+	Queues      int
+	Owner       uint32
+	Group       uint32
+}
+
+// ListPersistentInterfaces returns every persistent TUN/TAP device created by swiftunnel.
+func ListPersistentInterfaces() ([]PersistentInterface, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	var interfaces []PersistentInterface
+	for _, link := range links {
+		if info, ok := persistentInfo(link); ok {
+			interfaces = append(interfaces, info)
+		}
+	}
+
+	return interfaces, nil
+}
+
+// AttachPersistentInterface opens a persistent swiftunnel device by name with the flags it was created with.
+// The device stays persistent unless it is deleted or reattached with a DeleteOnClose configuration.
+func AttachPersistentInterface(name string) (*SwiftInterface, error) {
+	info, err := lookupPersistentInterface(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSwiftInterface(info.config())
+}
+
+// DeletePersistentInterface clears the persistent flag of a swiftunnel device so that the kernel removes it.
+// Removal happens immediately unless another process still holds the device open.
+func DeletePersistentInterface(name string) error {
+	info, err := lookupPersistentInterface(name)
+	if err != nil {
+		return err
+	}
+
+	config := info.config()
+	config.DeleteOnClose = true
+
+	adapter, err := NewSwiftInterface(config)
+	if err != nil {
+		return fmt.Errorf("failed to attach to %s: %w", name, err)
+	}
+
+	return adapter.Close()
+}
+
+// lookupPersistentInterface finds a persistent swiftunnel device by name.
+func lookupPersistentInterface(name string) (PersistentInterface, error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return PersistentInterface{}, fmt.Errorf("failed to find interface %s: %w", name, err)
+	}
+
+	info, ok := persistentInfo(link)
+	if !ok {
+		return PersistentInterface{}, fmt.Errorf("%s: %w", name, ErrNotPersistentInterface)
+	}
+
+	return info, nil
+}
+
+// persistentInfo converts a link into a PersistentInterface when it is a persistent swiftunnel device.
+func persistentInfo(link netlink.Link) (PersistentInterface, bool) {
+	tuntap, ok := link.(*netlink.Tuntap)
+	if !ok || tuntap.NonPersist || !persistentTagged(link) {
+		return PersistentInterface{}, false
+	}
+
+	info := PersistentInterface{
+		Name:        tuntap.Name,
+		Index:       tuntap.Index,
+		AdapterType: swiftypes.AdapterTypeTUN,
+		MultiQueue:  tuntap.Flags&netlink.TUNTAP_MULTI_QUEUE != 0,
+		VnetHdr:     tuntap.Flags&netlink.TUNTAP_VNET_HDR != 0,
+		Queues:      tuntap.Queues,
+		Owner:       tuntap.Owner,
+		Group:       tuntap.Group,
+	}
+
+	if tuntap.Mode == netlink.TUNTAP_MODE_TAP {
+		info.AdapterType = swiftypes.AdapterTypeTAP
+	}
+
+	return info, true
+}
+
+// config builds a configuration whose TUNSETIFF flags match the existing device.
+func (p PersistentInterface) config() *swiftconfig.Config {
+	return &swiftconfig.Config{
+		AdapterName: p.Name,
+		AdapterType: p.AdapterType,
+		MultiQueue:  p.MultiQueue,
+		Queues:      1,
+		Persist:     true,
+		VnetHdr:     p.VnetHdr,
+	}
+}
+
+// markPersistent tags a persistent device with an alternative name so that ListPersistentInterfaces can find it later.
+// Kernels before 5.5 and restricted netlink sockets reject alternative names; the device is then tagged with the
+// legacy alias if it has none, and left untagged otherwise, without failing: the device stays persistent either way.
+func (a *SwiftInterface) markPersistent() {
+	link, err := a.handle().LinkByName(a.name)
+	if err != nil || persistentTagged(link) {
+		return
+	}
+
+	if err := a.handle().LinkAddAltName(link, persistentAltNamePrefix+a.name); err == nil {
+		return
+	}
+
+	if link.Attrs().Alias == "" {
+		_ = a.handle().LinkSetAlias(link, persistentAlias)
+	}
+}
+
+// persistentTagged reports whether link carries the tag of a persistent swiftunnel device.
+func persistentTagged(link netlink.Link) bool {
+	if link.Attrs().Alias == persistentAlias {
+		return true
+	}
+
+	return slices.ContainsFunc(link.Attrs().AltNames, func(name string) bool {
+		return strings.HasPrefix(name, persistentAltNamePrefix)
+	})
+}
@@ -3,15 +3,25 @@
 package swiftunnel
 
 import (
+	"errors"
+	"fmt"
 	"github.com/SyNdicateFoundation/swiftunnel/swiftconfig"
 	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
 	"golang.org/x/sys/unix"
 	"io"
 	"os"
 	"strings"
+	"sync"
+	"time"
 	"unsafe"
 )
 
+var (
+	ErrNotMultiQueue = errors.New("interface was not created with multi-queue support")
+)
+
 type ifReq struct {
 	Name  [unix.IFNAMSIZ]byte
 	Flags uint16
@@ -23,10 +33,144 @@ type SwiftInterface struct {
 	io.ReadWriteCloser
 	name        string
 	adapterType swiftypes.AdapterType
+	queues      []*Queue
+	uso         bool
+	counters    ioCounters
+	changes     changeLog
+	// encryptedDNS is the configuration served by the forwarder setEncryptedDNS started, if it is running.
+	encryptedDNS *swiftypes.DNSConfig
+
+	deleteOnClose bool
+
+	ns         netns.NsHandle
+	nl         *netlink.Handle
+	createInNS bool
+	nsName     string
+
+	resolvedBus    string
+	resolvConfPath string
+	dnsBackend     dnsBackend
+	resolvconfDNS  *swiftypes.DNSConfig
+}
+
+// Queue is a single packet queue of a Linux TUN/TAP device.
+type Queue struct {
+	file       *os.File
+	fd         int
+	index      int
+	multiQueue bool
+	offload    *offload
+	counters   *ioCounters
+
+	deadlineMu   sync.Mutex
+	readDeadline time.Time
+}
+
+// Read receives a single packet from the queue.
+func (q *Queue) Read(buf []byte) (n int, err error) {
+	defer func() { q.counters.countRead(n, err) }()
+
+	if q.offload == nil || q.offload.raw {
+		return q.file.Read(buf)
+	}
+
+	return q.offload.read(q.file, buf)
+}
+
+// Write transmits a single packet through the queue.
+func (q *Queue) Write(buf []byte) (n int, err error) {
+	defer func() { q.counters.countWrite(n, err) }()
+
+	if q.offload == nil || q.offload.raw {
+		return q.file.Write(buf)
+	}
+
+	return q.offload.write(q.file, buf)
+}
+
+// ReadBatch receives up to len(bufs) packets, storing each packet length in sizes.
+// With virtio-net offloads a single super-packet is segmented across bufs; otherwise one packet is read per call.
+func (q *Queue) ReadBatch(bufs [][]byte, sizes []int) (n int, err error) {
+	defer func() { q.counters.countReadBatch(n, sizes, err) }()
+
+	if q.offload == nil || q.offload.raw {
+		return readBatch(q.file.Read, bufs, sizes)
+	}
+
+	return q.offload.readBatch(q.file, bufs, sizes)
+}
+
+// WriteBatch transmits bufs, coalescing TCP and UDP segments into super-packets when offloads are enabled.
+// It returns the number of packets written.
+func (q *Queue) WriteBatch(bufs [][]byte) (n int, err error) {
+	defer func() { q.counters.countWriteBatch(n, bufs, err) }()
+
+	if q.offload == nil || q.offload.raw {
+		return writeBatch(q.file.Write, bufs)
+	}
+
+	return q.offload.writePackets(q.file, bufs)
+}
+
+// Close releases the queue file descriptor.
+func (q *Queue) Close() error {
+	return q.file.Close()
+}
+
+// Fd returns the raw file descriptor of the queue without switching it to blocking mode.
+func (q *Queue) Fd() uintptr {
+	return uintptr(q.fd)
+}
+
+// Index returns the position of the queue within its SwiftInterface.
+func (q *Queue) Index() int {
+	return q.index
+}
+
+// Attach re-enables packet delivery to a previously detached queue.
+func (q *Queue) Attach() error {
+	return q.setQueue(unix.IFF_ATTACH_QUEUE)
+}
+
+// Detach stops the kernel from steering packets to the queue without closing it.
+func (q *Queue) Detach() error {
+	return q.setQueue(unix.IFF_DETACH_QUEUE)
+}
+
+// setQueue sends the TUNSETQUEUE ioctl with the given attach or detach flag.
+func (q *Queue) setQueue(flags uint16) error {
+	if !q.multiQueue {
+		return ErrNotMultiQueue
+	}
+
+	var req ifReq
+	req.Flags = flags
+
+	return ioctl(q.Fd(), unix.TUNSETQUEUE, uintptr(unsafe.Pointer(&req)))
 }
 
 // initializeAdapter configures the flags and creates the Linux interface via ioctl.
 func (a *SwiftInterface) initializeAdapter(config *swiftconfig.Config, fd uintptr) (string, error) {
+	ifName, err := a.createInterface(fd, config.AdapterName, a.interfaceFlags(config))
+	if err != nil {
+		return "", err
+	}
+
+	if err := a.setDeviceOptions(fd, config); err != nil {
+		return "", err
+	}
+
+	if config.VnetHdr {
+		if a.uso, err = setOffload(fd); err != nil {
+			return "", err
+		}
+	}
+
+	return ifName, nil
+}
+
+// interfaceFlags builds the TUNSETIFF flags requested by the configuration.
+func (a *SwiftInterface) interfaceFlags(config *swiftconfig.Config) uint16 {
 	flags := unix.IFF_NO_PI
 
 	if config.AdapterType == swiftypes.AdapterTypeTUN {
@@ -36,19 +180,14 @@ func (a *SwiftInterface) initializeAdapter(config *swiftconfig.Config, fd uintpt
 	}
 
 	if config.MultiQueue {
-		flags |= unix.IFF_PROMISC
+		flags |= unix.IFF_MULTI_QUEUE
 	}
 
-	ifName, err := a.createInterface(fd, config.AdapterName, uint16(flags))
-	if err != nil {
-		return "", err
+	if config.VnetHdr {
+		flags |= unix.IFF_VNET_HDR
 	}
 
-	if err := a.setDeviceOptions(fd, config); err != nil {
-		return "", err
-	}
-
-	return ifName, nil
+	return uint16(flags)
 }
 
 // createInterface sends the TUNSETIFF ioctl to create the virtual device.
@@ -84,44 +223,250 @@ func (a *SwiftInterface) setDeviceOptions(fd uintptr, config *swiftconfig.Config
 	return ioctl(fd, unix.TUNSETPERSIST, uintptr(persistFlag))
 }
 
-// GetFD returns the underlying OS file pointer.
+// openQueue opens /dev/net/tun and binds the new file to the named device.
+// The file is handed to the runtime poller only once the device is attached, as unbound descriptors are not pollable.
+func (a *SwiftInterface) openQueue(config *swiftconfig.Config) (*Queue, string, error) {
+	fd, err := a.openDevice()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var ifName string
+	if len(a.queues) == 0 {
+		ifName, err = a.initializeAdapter(config, uintptr(fd))
+	} else {
+		ifName, err = a.createInterface(uintptr(fd), a.name, a.interfaceFlags(config))
+	}
+
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, "", err
+	}
+
+	queue := a.newQueue(fd, ifName, config.MultiQueue)
+
+	if config.VnetHdr {
+		queue.offload = newOffload(config.RawVnetHdr, a.uso)
+	}
+
+	return queue, ifName, nil
+}
+
+// newQueue wraps an attached non-blocking descriptor as the next queue of the device.
+func (a *SwiftInterface) newQueue(fd int, ifName string, multiQueue bool) *Queue {
+	return &Queue{
+		file:       os.NewFile(uintptr(fd), ifName),
+		fd:         fd,
+		index:      len(a.queues),
+		multiQueue: multiQueue,
+		counters:   &a.counters,
+	}
+}
+
+// queryInterface reads the name and flags of the device a descriptor is attached to via TUNGETIFF.
+func queryInterface(fd uintptr) (string, uint16, error) {
+	var req ifReq
+
+	if err := ioctl(fd, unix.TUNGETIFF, uintptr(unsafe.Pointer(&req))); err != nil {
+		return "", 0, err
+	}
+
+	return strings.TrimRight(string(req.Name[:]), "\x00"), req.Flags, nil
+}
+
+// GetAdapterType reports whether the device operates as TUN or TAP.
+func (a *SwiftInterface) GetAdapterType() swiftypes.AdapterType {
+	return a.adapterType
+}
+
+// Queues returns every packet queue opened for the device, the first being the default one.
+func (a *SwiftInterface) Queues() []*Queue {
+	return a.queues
+}
+
+// ReadBatch receives up to len(bufs) packets from the default queue.
+func (a *SwiftInterface) ReadBatch(bufs [][]byte, sizes []int) (int, error) {
+	return a.queues[0].ReadBatch(bufs, sizes)
+}
+
+// WriteBatch transmits bufs through the default queue.
+func (a *SwiftInterface) WriteBatch(bufs [][]byte) (int, error) {
+	return a.queues[0].WriteBatch(bufs)
+}
+
+// Close reverts the recorded system changes and releases every queue of the device, first clearing its persistent
+// flag when DeleteOnClose is set.
+func (a *SwiftInterface) Close() error {
+	var errs []error
+
+	if err := a.changes.revert(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if a.deleteOnClose && len(a.queues) > 0 {
+		if err := ioctl(a.queues[0].Fd(), unix.TUNSETPERSIST, 0); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, queue := range a.queues {
+		if err := queue.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if err := a.closeNetNS(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// GetFD returns the underlying OS file pointer of the default queue.
+// Calling Fd on it switches the queue back to blocking mode; use Queue.Fd for raw descriptor access.
 func (a *SwiftInterface) GetFD() *os.File {
-	return a.ReadWriteCloser.(*os.File)
+	return a.queues[0].file
 }
 
-// NewSwiftInterface opens /dev/net/tun and initializes the SwiftInterface.
+// NewSwiftInterface opens /dev/net/tun in non-blocking mode and initializes the SwiftInterface.
+// Queues are registered with the Go runtime poller, so deadlines apply and Close unblocks pending reads.
 func NewSwiftInterface(config *swiftconfig.Config) (*SwiftInterface, error) {
-	fd, err := unix.Open("/dev/net/tun", os.O_RDWR, 0)
-	if err != nil {
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
 
+	if config.VnetHdr && config.AdapterType != swiftypes.AdapterTypeTUN {
+		return nil, errors.New("virtio-net header offloads require a TUN adapter")
+	}
+
+	queueCount := 1
+	if config.MultiQueue && config.Queues > 1 {
+		queueCount = config.Queues
+	}
+
 	adapter := &SwiftInterface{
-		adapterType:     config.AdapterType,
-		ReadWriteCloser: os.NewFile(uintptr(fd), "tun"),
+		adapterType:    config.AdapterType,
+		queues:         make([]*Queue, 0, queueCount),
+		deleteOnClose:  config.DeleteOnClose,
+		ns:             netns.None(),
+		resolvedBus:    config.ResolvedBus,
+		resolvConfPath: config.ResolvConfPath,
 	}
 
-	adapterName, err := adapter.initializeAdapter(config, uintptr(fd))
-	if err != nil {
-		_ = adapter.Close()
-		return nil, err
+	if config.NetNS != nil {
+		if err := adapter.enterNetNS(config.NetNS); err != nil {
+			return nil, err
+		}
 	}
 
-	adapter.name = adapterName
+	for range queueCount {
+		queue, adapterName, err := adapter.openQueue(config)
+		if err != nil {
+			_ = adapter.Close()
+			return nil, err
+		}
 
-	if config.UnicastConfig != nil {
-		if err = adapter.SetUnicastIpAddressEntry(config.UnicastConfig); err != nil {
+		adapter.name = adapterName
+		adapter.queues = append(adapter.queues, queue)
+	}
+
+	adapter.ReadWriteCloser = adapter.queues[0]
+
+	if config.NetNS != nil && config.NetNS.Move {
+		if err := adapter.moveToNetNS(); err != nil {
 			_ = adapter.Close()
 			return nil, err
 		}
 	}
 
-	if config.MTU > 0 {
-		if err = adapter.SetMTU(config.MTU); err != nil {
+	if config.Persist {
+		adapter.markPersistent()
+	}
+
+	if err := configureAdapter(adapter, config); err != nil {
+		_ = adapter.Close()
+		return nil, err
+	}
+
+	return adapter, nil
+}
+
+// NewSwiftInterfaceFromFD adopts a descriptor that is already attached to a TUN/TAP device, such as one created by a
+// privileged helper, Android's VpnService or systemd. The SwiftInterface takes ownership of fd and closes it on Close.
+// An empty name is resolved from the kernel; otherwise it must match the device the descriptor is attached to.
+func NewSwiftInterfaceFromFD(fd uintptr, name string, adapterType swiftypes.AdapterType) (*SwiftInterface, error) {
+	return adoptInterface([]int{int(fd)}, name, adapterType)
+}
+
+// adoptInterface builds a SwiftInterface whose queues are the given attached descriptors.
+func adoptInterface(fds []int, name string, adapterType swiftypes.AdapterType) (*SwiftInterface, error) {
+	if len(fds) == 0 {
+		return nil, errors.New("no file descriptor to adopt")
+	}
+
+	adapter := &SwiftInterface{
+		name:        name,
+		adapterType: adapterType,
+		queues:      make([]*Queue, 0, len(fds)),
+		ns:          netns.None(),
+	}
+
+	for i, fd := range fds {
+		if err := unix.SetNonblock(fd, true); err != nil {
+			closeDescriptors(fds[i:])
+			_ = adapter.Close()
+			return nil, os.NewSyscallError("fcntl", err)
+		}
+
+		ifName, flags, err := queryInterface(uintptr(fd))
+		if err == nil {
+			err = adapter.checkAdopted(ifName, flags)
+		} else if adapter.name != "" {
+			// Descriptors that do not answer TUNGETIFF (e.g. some VpnService fds) are trusted to match the given name.
+			ifName, err = adapter.name, nil
+		}
+
+		if err != nil {
+			closeDescriptors(fds[i:])
 			_ = adapter.Close()
 			return nil, err
 		}
+
+		adapter.name = ifName
+		queue := adapter.newQueue(fd, ifName, flags&unix.IFF_MULTI_QUEUE != 0)
+
+		if flags&unix.IFF_VNET_HDR != 0 {
+			queue.offload = newOffload(false, false)
+		}
+
+		adapter.queues = append(adapter.queues, queue)
 	}
 
+	adapter.ReadWriteCloser = adapter.queues[0]
+
 	return adapter, nil
 }
+
+// checkAdopted verifies that an adopted descriptor belongs to the expected device and adapter type.
+func (a *SwiftInterface) checkAdopted(ifName string, flags uint16) error {
+	if a.name != "" && a.name != ifName {
+		return fmt.Errorf("descriptor is attached to %q, expected %q", ifName, a.name)
+	}
+
+	isTAP := flags&unix.IFF_TAP != 0
+	if isTAP != (a.adapterType == swiftypes.AdapterTypeTAP) {
+		return fmt.Errorf("descriptor adapter type does not match for %q", ifName)
+	}
+
+	return nil
+}
+
+// descriptors returns the raw descriptors of every queue, in queue order.
+func (a *SwiftInterface) descriptors() []int {
+	fds := make([]int, len(a.queues))
+	for i, queue := range a.queues {
+		fds[i] = queue.fd
+	}
+
+	return fds
+}
@@ -5,9 +5,11 @@ package swiftunnel
 import (
 	"errors"
 	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/gateway"
 	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/unix"
+	"net"
 	"os"
 )
 
@@ -33,7 +35,7 @@ func (a *SwiftInterface) GetAdapterIndex() (int, error) {
 		return 0, errors.New("adapter name is not set")
 	}
 
-	ifi, err := netlink.LinkByName(a.name)
+	ifi, err := a.handle().LinkByName(a.name)
 	if err != nil {
 		return 0, err
 	}
@@ -48,36 +50,45 @@ func (a *SwiftInterface) SetMTU(mtu int) error {
 		return err
 	}
 
-	link, err := netlink.LinkByIndex(index)
+	link, err := a.handle().LinkByIndex(index)
 	if err != nil {
 		return fmt.Errorf("failed to find interface: %w", err)
 	}
 
-	if err = netlink.LinkSetMTU(link, mtu); err != nil {
+	if err = a.handle().LinkSetMTU(link, mtu); err != nil {
 		return fmt.Errorf("failed to set MTU: %w", err)
 	}
 
 	return nil
 }
 
-// SetUnicastIpAddressEntry assigns an IP address and optional gateway to the interface.
+// SetUnicastIpAddressEntry assigns an IP address and optional gateway to the interface. The address is IP with the
+// prefix length of IPNet, as on Windows, so that a config parsed from "10.8.0.2/24" assigns 10.8.0.2 rather than the
+// network address 10.8.0.0; IPNet itself is assigned when IP is nil.
 func (a *SwiftInterface) SetUnicastIpAddressEntry(config *swiftypes.UnicastConfig) error {
 	index, err := a.GetAdapterIndex()
 	if err != nil {
 		return err
 	}
 
-	link, err := netlink.LinkByIndex(index)
+	link, err := a.handle().LinkByIndex(index)
 	if err != nil {
 		return fmt.Errorf("failed to find interface: %w", err)
 	}
 
-	if err := netlink.AddrAdd(link, &netlink.Addr{
-		IPNet: config.IPNet,
+	ipNet := config.IPNet
+	if config.IP != nil {
+		ipNet = &net.IPNet{IP: config.IP, Mask: config.IPNet.Mask}
+	}
+
+	if err := a.handle().AddrAdd(link, &netlink.Addr{
+		IPNet: ipNet,
 	}); err != nil {
-		return fmt.Errorf("failed to add address %v to interface %d: %v", config.IPNet, index, err)
+		return fmt.Errorf("failed to add address %v to interface %d: %v", ipNet, index, err)
 	}
 
+	a.recordAddressAdded(&swiftypes.Address{IPNet: ipNet})
+
 	if config.Gateway != nil {
 		if err := a.AddRoute(&netlink.Route{
 			LinkIndex: link.Attrs().Index,
@@ -97,23 +108,23 @@ func (a *SwiftInterface) SetStatus(status swiftypes.InterfaceStatus) error {
 		return err
 	}
 
-	link, err := netlink.LinkByIndex(index)
+	link, err := a.handle().LinkByIndex(index)
 	if err != nil {
 		return fmt.Errorf("failed to find interface: %w", err)
 	}
 
 	switch status {
 	case swiftypes.InterfaceUp:
-		return netlink.LinkSetUp(link)
+		return a.handle().LinkSetUp(link)
 	case swiftypes.InterfaceDown:
-		return netlink.LinkSetDown(link)
+		return a.handle().LinkSetDown(link)
 	}
 
 	return nil
 }
 
-// AddRoute adds a network route via the current interface.
-func (a *SwiftInterface) AddRoute(route *netlink.Route) error {
+// addRoute adds a network route via the current interface.
+func (a *SwiftInterface) addRoute(route *netlink.Route) error {
 	index, err := a.GetAdapterIndex()
 	if err != nil {
 		return err
@@ -121,15 +132,71 @@ func (a *SwiftInterface) AddRoute(route *netlink.Route) error {
 
 	route.LinkIndex = index
 
-	if err := netlink.RouteAdd(route); err != nil {
+	if err := a.handle().RouteAdd(route); err != nil {
 		return fmt.Errorf("failed to add route %v: %v", route, err)
 	}
 
 	return nil
 }
 
-// RemoveRoute remove a network route via the current interface.
-func (a *SwiftInterface) RemoveRoute(route *netlink.Route) error {
+// addBypassRoute adds a route through its gateway on the interface the kernel resolves for it, bypassing the current
+// interface.
+func (a *SwiftInterface) addBypassRoute(route *netlink.Route) error {
+	if err := a.handle().RouteAdd(route); err != nil {
+		return fmt.Errorf("failed to add route %v: %v", route, err)
+	}
+
+	return nil
+}
+
+// defaultGateway returns the gateway and outgoing interface index of the main-table default route of the family of
+// dst, skipping routes through the current interface. The index is needed for the link-local gateways usual on IPv6.
+func (a *SwiftInterface) defaultGateway(dst net.IP) (net.IP, int, error) {
+	family := unix.AF_INET6
+	if dst.To4() != nil {
+		family = unix.AF_INET
+	}
+
+	index, err := a.GetAdapterIndex()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	routes, err := a.handle().RouteList(nil, family)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get route list: %w", err)
+	}
+
+	for _, route := range routes {
+		if route.Dst != nil {
+			if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+				continue
+			}
+		}
+
+		if route.Gw == nil && len(route.MultiPath) > 0 {
+			route.Gw, route.LinkIndex = route.MultiPath[0].Gw, route.MultiPath[0].LinkIndex
+		}
+
+		if route.Gw != nil && route.LinkIndex != index {
+			return route.Gw, route.LinkIndex, nil
+		}
+	}
+
+	return nil, 0, gateway.ErrNoGateway
+}
+
+// removeBypassRoute removes a route added by addBypassRoute.
+func (a *SwiftInterface) removeBypassRoute(route *netlink.Route) error {
+	if err := a.handle().RouteDel(route); err != nil {
+		return fmt.Errorf("failed to remove route %v: %v", route, err)
+	}
+
+	return nil
+}
+
+// removeRoute remove a network route via the current interface.
+func (a *SwiftInterface) removeRoute(route *netlink.Route) error {
 	index, err := a.GetAdapterIndex()
 	if err != nil {
 		return err
@@ -137,15 +204,15 @@ func (a *SwiftInterface) RemoveRoute(route *netlink.Route) error {
 
 	route.LinkIndex = index
 
-	if err := netlink.RouteDel(route); err != nil {
+	if err := a.handle().RouteDel(route); err != nil {
 		return fmt.Errorf("failed to add route %v: %v", route, err)
 	}
 
 	return nil
 }
 
-// ReplaceRoute replace a network route via the current interface.
-func (a *SwiftInterface) ReplaceRoute(route *netlink.Route) error {
+// replaceRoute replace a network route via the current interface.
+func (a *SwiftInterface) replaceRoute(route *netlink.Route) error {
 	index, err := a.GetAdapterIndex()
 	if err != nil {
 		return err
@@ -153,15 +220,15 @@ func (a *SwiftInterface) ReplaceRoute(route *netlink.Route) error {
 
 	route.LinkIndex = index
 
-	if err := netlink.RouteReplace(route); err != nil {
+	if err := a.handle().RouteReplace(route); err != nil {
 		return fmt.Errorf("failed to add route %v: %v", route, err)
 	}
 
 	return nil
 }
 
-// ChangeRoute change a network route via the current interface.
-func (a *SwiftInterface) ChangeRoute(route *netlink.Route) error {
+// changeRoute change a network route via the current interface.
+func (a *SwiftInterface) changeRoute(route *netlink.Route) error {
 	index, err := a.GetAdapterIndex()
 	if err != nil {
 		return err
@@ -169,15 +236,15 @@ func (a *SwiftInterface) ChangeRoute(route *netlink.Route) error {
 
 	route.LinkIndex = index
 
-	if err := netlink.RouteChange(route); err != nil {
+	if err := a.handle().RouteChange(route); err != nil {
 		return fmt.Errorf("failed to add route %v: %v", route, err)
 	}
 
 	return nil
 }
 
-// AppendRoute append a network route via the current interface.
-func (a *SwiftInterface) AppendRoute(route *netlink.Route) error {
+// appendRoute append a network route via the current interface.
+func (a *SwiftInterface) appendRoute(route *netlink.Route) error {
 	index, err := a.GetAdapterIndex()
 	if err != nil {
 		return err
@@ -185,7 +252,7 @@ func (a *SwiftInterface) AppendRoute(route *netlink.Route) error {
 
 	route.LinkIndex = index
 
-	if err := netlink.RouteAppend(route); err != nil {
+	if err := a.handle().RouteAppend(route); err != nil {
 		return fmt.Errorf("failed to add route %v: %v", route, err)
 	}
 
@@ -199,15 +266,35 @@ func (a *SwiftInterface) RouteList(family int) ([]netlink.Route, error) {
 		return nil, err
 	}
 
-	byIndex, err := netlink.LinkByIndex(index)
+	byIndex, err := a.handle().LinkByIndex(index)
 	if err != nil {
 		return nil, fmt.Errorf("failed reterive routes %dv: %v", index, err)
 	}
 
-	return netlink.RouteList(byIndex, family)
+	return a.handle().RouteList(byIndex, family)
+}
+
+// tableRoutes lists the routes of the interface in table, with zero meaning the main table.
+func (a *SwiftInterface) tableRoutes(table int) ([]netlink.Route, error) {
+	if table == 0 {
+		return a.RouteList(0)
+	}
+
+	index, err := a.GetAdapterIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	return a.handle().RouteListFiltered(unix.AF_UNSPEC, &netlink.Route{LinkIndex: index, Table: table},
+		netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
 }
 
-// SetDNS is currently unsupported on Unix-like SwiftInterfaces.
-func (a *SwiftInterface) SetDNS(config *swiftypes.DNSConfig) error {
-	return errors.New("DNS configuration not supported on this platform")
+// linkState reports the MTU and administrative state of the interface.
+func (a *SwiftInterface) linkState() (int, bool, error) {
+	link, err := a.handle().LinkByName(a.name)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return link.Attrs().MTU, link.Attrs().Flags&net.FlagUp != 0, nil
 }
@@ -5,6 +5,7 @@ package swiftunnel
 import (
 	"errors"
 	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/gateway"
 	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
 	"github.com/vishvananda/netlink"
 	"golang.org/x/sys/windows"
@@ -21,6 +22,7 @@ var (
 	procInitializeIpForwardEntry        = iphlpapi.NewProc("InitializeIpForwardEntry")
 	procSetInterfaceDnsSettings         = iphlpapi.NewProc("SetInterfaceDnsSettings")
 	procGetInterfaceDnsSettings         = iphlpapi.NewProc("GetInterfaceDnsSettings")
+	procFreeInterfaceDnsSettings        = iphlpapi.NewProc("FreeInterfaceDnsSettings")
 	procDeleteIpForwardEntry2           = iphlpapi.NewProc("DeleteIpForwardEntry2")
 	procGetIpForwardTable2              = iphlpapi.NewProc("GetIpForwardTable2")
 	procSetIpForwardEntry2              = iphlpapi.NewProc("SetIpForwardEntry2")
@@ -144,11 +146,31 @@ func (a *SwiftInterface) SetUnicastIpAddressEntry(config *swiftypes.UnicastConfi
 		return fmt.Errorf("failed to create unicast IP address config: %w (error code: %d)", errno, ret)
 	}
 
+	a.recordAddressAdded(&swiftypes.Address{IPNet: &net.IPNet{IP: config.IP, Mask: config.IPNet.Mask}})
+
 	return nil
 }
 
-// SetDNS configures DNS servers and search domains for the interface.
-func (a *SwiftInterface) SetDNS(config *swiftypes.DNSConfig) error {
+// setDNS configures DNS servers and search domains for the interface.
+func (a *SwiftInterface) setDNS(config *swiftypes.DNSConfig) error {
+	return a.writeDNS(config, false)
+}
+
+// dnsRestorer captures the current DNS configuration and returns a function writing it back.
+func (a *SwiftInterface) dnsRestorer() (func() error, error) {
+	previous, err := a.currentDNS()
+	if err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return a.writeDNS(previous, true)
+	}, nil
+}
+
+// writeDNS writes config to the interface. Empty settings are left untouched unless clear is set, in which case
+// they are cleared.
+func (a *SwiftInterface) writeDNS(config *swiftypes.DNSConfig, clear bool) error {
 	guid, err := a.GetAdapterGUID()
 	if err != nil {
 		return err
@@ -165,7 +187,7 @@ func (a *SwiftInterface) SetDNS(config *swiftypes.DNSConfig) error {
 		return fmt.Errorf("failed to get DNS settings: %w", windows.Errno(ret))
 	}
 
-	if config.Domain != "" {
+	if config.Domain != "" || clear {
 		domain, err := windows.UTF16PtrFromString(config.Domain)
 		if err != nil {
 			return fmt.Errorf("failed to convert domain to UTF16: %w", err)
@@ -174,7 +196,7 @@ func (a *SwiftInterface) SetDNS(config *swiftypes.DNSConfig) error {
 		settings.Flags |= dnsSettingDomain
 	}
 
-	if len(config.DnsServers) > 0 {
+	if len(config.DnsServers) > 0 || clear {
 		var servers []string
 		var ipv6 bool
 
@@ -209,6 +231,58 @@ func (a *SwiftInterface) SetDNS(config *swiftypes.DNSConfig) error {
 	return nil
 }
 
+// linkState reports the MTU and operational state of the interface.
+func (a *SwiftInterface) linkState() (int, bool, error) {
+	index, err := a.GetAdapterIndex()
+	if err != nil {
+		return 0, false, err
+	}
+
+	var ifRow windows.MibIfRow
+	ifRow.Index = uint32(index)
+
+	ret, _, _ := procGetIfEntry.Call(uintptr(unsafe.Pointer(&ifRow)))
+	if err := windows.Errno(ret); !errors.Is(err, windows.ERROR_SUCCESS) {
+		return 0, false, fmt.Errorf("failed to retrieve interface entry: %w", err)
+	}
+
+	return int(ifRow.Mtu), ifRow.OperStatus == windows.IfOperStatusUp, nil
+}
+
+// currentDNS reads the DNS servers and domain configured on the interface.
+func (a *SwiftInterface) currentDNS() (*swiftypes.DNSConfig, error) {
+	guid, err := a.GetAdapterGUID()
+	if err != nil {
+		return nil, err
+	}
+
+	var settings dnsInterfaceSettings
+	settings.Version = 1
+
+	ret, _, _ := procGetInterfaceDnsSettings.Call(
+		uintptr(unsafe.Pointer(&guid)),
+		uintptr(unsafe.Pointer(&settings)),
+	)
+	if err := windows.Errno(ret); !errors.Is(err, windows.ERROR_SUCCESS) {
+		return nil, fmt.Errorf("failed to get DNS settings: %w", windows.Errno(ret))
+	}
+	defer procFreeInterfaceDnsSettings.Call(uintptr(unsafe.Pointer(&settings)))
+
+	config := &swiftypes.DNSConfig{
+		Domain: windows.UTF16PtrToString(settings.Domain),
+	}
+
+	for _, server := range strings.FieldsFunc(windows.UTF16PtrToString(settings.NameServer), func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		if ip := net.ParseIP(server); ip != nil {
+			config.DnsServers = append(config.DnsServers, ip)
+		}
+	}
+
+	return config, nil
+}
+
 // SetStatus modifies the administrative status of the interface.
 func (a *SwiftInterface) SetStatus(status swiftypes.InterfaceStatus) error {
 	index, err := a.GetAdapterIndex()
@@ -239,8 +313,8 @@ func (a *SwiftInterface) SetStatus(status swiftypes.InterfaceStatus) error {
 	return nil
 }
 
-// AddRoute adds a network route via the current interface.
-func (a *SwiftInterface) AddRoute(route *netlink.Route) error {
+// addRoute adds a network route via the current interface.
+func (a *SwiftInterface) addRoute(route *netlink.Route) error {
 	row, err := a.routeToRow(route)
 	if err != nil {
 		return err
@@ -258,9 +332,9 @@ func (a *SwiftInterface) AddRoute(route *netlink.Route) error {
 	return nil
 }
 
-// ReplaceRoute replaces a network route via the current interface.
+// replaceRoute replaces a network route via the current interface.
 // On Windows, if the exact route exists, we update it. If not, we create it.
-func (a *SwiftInterface) ReplaceRoute(route *netlink.Route) error {
+func (a *SwiftInterface) replaceRoute(route *netlink.Route) error {
 	row, err := a.routeToRow(route)
 	if err != nil {
 		return err
@@ -286,8 +360,8 @@ func (a *SwiftInterface) ReplaceRoute(route *netlink.Route) error {
 	return fmt.Errorf("failed to replace (create) route: %w", errno)
 }
 
-// ChangeRoute changes an existing network route via the current interface.
-func (a *SwiftInterface) ChangeRoute(route *netlink.Route) error {
+// changeRoute changes an existing network route via the current interface.
+func (a *SwiftInterface) changeRoute(route *netlink.Route) error {
 	row, err := a.routeToRow(route)
 	if err != nil {
 		return err
@@ -302,9 +376,9 @@ func (a *SwiftInterface) ChangeRoute(route *netlink.Route) error {
 	return nil
 }
 
-// AppendRoute appends a network route via the current interface.
+// appendRoute appends a network route via the current interface.
 // On Windows, this is functionally equivalent to AddRoute (CreateIpForwardEntry2).
-func (a *SwiftInterface) AppendRoute(route *netlink.Route) error {
+func (a *SwiftInterface) appendRoute(route *netlink.Route) error {
 	row, err := a.routeToRow(route)
 	if err != nil {
 		return err
@@ -323,6 +397,11 @@ func (a *SwiftInterface) AppendRoute(route *netlink.Route) error {
 	return nil
 }
 
+// tableRoutes lists the routes of the interface; Windows has a single routing table, so table is ignored.
+func (a *SwiftInterface) tableRoutes(table int) ([]netlink.Route, error) {
+	return a.RouteList(windows.AF_UNSPEC)
+}
+
 // RouteList retrieves network routes via the current interface.
 func (a *SwiftInterface) RouteList(family int) ([]netlink.Route, error) {
 	idx, err := a.GetAdapterIndex()
@@ -391,8 +470,8 @@ func (a *SwiftInterface) RouteList(family int) ([]netlink.Route, error) {
 	return routes, nil
 }
 
-// RemoveRoute removes a network route via the current interface.
-func (a *SwiftInterface) RemoveRoute(route *netlink.Route) error {
+// removeRoute removes a network route via the current interface.
+func (a *SwiftInterface) removeRoute(route *netlink.Route) error {
 	row, err := a.routeToRow(route)
 	if err != nil {
 		return err
@@ -408,21 +487,106 @@ func (a *SwiftInterface) RemoveRoute(route *netlink.Route) error {
 	return nil
 }
 
-// routeToRow converts a netlink.Route to a Windows MIB_IPFORWARD_ROW2 structure.
+// addBypassRoute adds a route through its gateway on the interface the system would pick for it, bypassing the
+// current interface.
+func (a *SwiftInterface) addBypassRoute(route *netlink.Route) error {
+	row, err := bypassRouteToRow(route)
+	if err != nil {
+		return err
+	}
+
+	ret, _, _ := procCreateIpForwardEntry2.Call(uintptr(unsafe.Pointer(row)))
+	if errno := windows.Errno(ret); !errors.Is(errno, windows.ERROR_SUCCESS) {
+		return fmt.Errorf("failed to add route %v: %w", route.Dst, errno)
+	}
+
+	return nil
+}
+
+// defaultGateway returns the default gateway of the family of dst. The interface index is left to bypassRouteToRow,
+// which picks the best interface to reach the gateway.
+func (a *SwiftInterface) defaultGateway(dst net.IP) (net.IP, int, error) {
+	discover := gateway.DiscoverGatewayIPv6
+	if dst.To4() != nil {
+		discover = gateway.DiscoverGatewayIPv4
+	}
+
+	gw, err := discover()
+
+	return gw, 0, err
+}
+
+// removeBypassRoute removes a route added by addBypassRoute.
+func (a *SwiftInterface) removeBypassRoute(route *netlink.Route) error {
+	row, err := bypassRouteToRow(route)
+	if err != nil {
+		return err
+	}
+
+	ret, _, _ := procDeleteIpForwardEntry2.Call(uintptr(unsafe.Pointer(row)))
+	if errno := windows.Errno(ret); !errors.Is(errno, windows.ERROR_SUCCESS) {
+		return fmt.Errorf("failed to remove route %v: %w", route.Dst, errno)
+	}
+
+	return nil
+}
+
+// bypassRouteToRow converts a route through a gateway into a row on the best interface to reach that gateway.
+func bypassRouteToRow(route *netlink.Route) (*windows.MibIpForwardRow2, error) {
+	if route.Gw == nil {
+		return nil, errors.New("bypass route requires a gateway")
+	}
+
+	var sockaddr windows.Sockaddr
+	if ipv4 := route.Gw.To4(); ipv4 != nil {
+		sa := &windows.SockaddrInet4{}
+		copy(sa.Addr[:], ipv4)
+		sockaddr = sa
+	} else {
+		sa := &windows.SockaddrInet6{}
+		copy(sa.Addr[:], route.Gw.To16())
+		sockaddr = sa
+	}
+
+	var index uint32
+	if err := windows.GetBestInterfaceEx(sockaddr, &index); err != nil {
+		return nil, fmt.Errorf("failed to find interface for gateway %v: %w", route.Gw, err)
+	}
+
+	row, err := newRouteRow(route)
+	if err != nil {
+		return nil, err
+	}
+	row.InterfaceIndex = index
+
+	return row, nil
+}
+
+// routeToRow converts a netlink.Route to a Windows MIB_IPFORWARD_ROW2 structure on the current interface.
 func (a *SwiftInterface) routeToRow(route *netlink.Route) (*windows.MibIpForwardRow2, error) {
 	luid, err := a.GetAdapterLUID()
 	if err != nil {
 		return nil, err
 	}
 
+	row, err := newRouteRow(route)
+	if err != nil {
+		return nil, err
+	}
+	row.InterfaceLuid = luid.ToUint64()
+
+	return row, nil
+}
+
+// newRouteRow converts a netlink.Route to a MIB_IPFORWARD_ROW2 structure without an interface.
+func newRouteRow(route *netlink.Route) (*windows.MibIpForwardRow2, error) {
 	var row windows.MibIpForwardRow2
 
-	_, _, err = procInitializeIpForwardEntry.Call(uintptr(unsafe.Pointer(&row)))
+	_, _, err := procInitializeIpForwardEntry.Call(uintptr(unsafe.Pointer(&row)))
 	if err != nil && !errors.Is(err, windows.ERROR_SUCCESS) {
 		return nil, fmt.Errorf("failed to initialize ip forward: %w", err)
 	}
 
-	row.InterfaceLuid = luid.ToUint64()
 	row.Metric = uint32(route.Priority)
 	row.Protocol = mibIPForwardProtoNetMgmt
 	row.Origin = nlRouteOriginManual
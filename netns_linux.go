@@ -0,0 +1,138 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftconfig"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	"os"
+	"runtime"
+)
+
+// handle returns the netlink handle bound to the namespace of the interface.
+func (a *SwiftInterface) handle() *netlink.Handle {
+	if a.nl == nil {
+		return &netlink.Handle{}
+	}
+
+	return a.nl
+}
+
+// NetNS returns the namespace the interface lives in, or an invalid handle when it is the caller's namespace.
+func (a *SwiftInterface) NetNS() netns.NsHandle {
+	return a.ns
+}
+
+// enterNetNS opens the configured namespace and binds a netlink handle to it.
+func (a *SwiftInterface) enterNetNS(config *swiftconfig.NetNS) error {
+	ns, err := openNetNS(config)
+	if err != nil {
+		return fmt.Errorf("failed to open network namespace: %w", err)
+	}
+
+	nl, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		_ = ns.Close()
+		return fmt.Errorf("failed to open netlink handle in namespace: %w", err)
+	}
+
+	a.ns = ns
+	a.nl = nl
+	a.createInNS = !config.Move
+	a.nsName = config.Name
+
+	return nil
+}
+
+// openNetNS resolves the namespace reference into a handle owned by the caller.
+func openNetNS(config *swiftconfig.NetNS) (netns.NsHandle, error) {
+	if config.Name != "" {
+		return netns.GetFromName(config.Name)
+	}
+
+	fd, err := unix.FcntlInt(uintptr(config.FD), unix.F_DUPFD_CLOEXEC, 0)
+	if err != nil {
+		return netns.None(), os.NewSyscallError("fcntl", err)
+	}
+
+	return netns.NsHandle(fd), nil
+}
+
+// moveToNetNS moves the device from the caller's namespace into the namespace of the interface.
+func (a *SwiftInterface) moveToNetNS() error {
+	link, err := netlink.LinkByName(a.name)
+	if err != nil {
+		return fmt.Errorf("failed to find interface: %w", err)
+	}
+
+	if err := netlink.LinkSetNsFd(link, int(a.ns)); err != nil {
+		return fmt.Errorf("failed to move interface to namespace: %w", err)
+	}
+
+	return nil
+}
+
+// closeNetNS releases the namespace and netlink handles of the interface.
+func (a *SwiftInterface) closeNetNS() error {
+	if a.nl != nil {
+		a.nl.Close()
+	}
+
+	if a.ns.IsOpen() {
+		return a.ns.Close()
+	}
+
+	return nil
+}
+
+// openDevice opens /dev/net/tun, from inside the target namespace when the device must be created there.
+// The kernel binds a TUN/TAP device to the namespace of the task that opened /dev/net/tun.
+func (a *SwiftInterface) openDevice() (int, error) {
+	open := func() (int, error) {
+		return unix.Open("/dev/net/tun", os.O_RDWR|unix.O_CLOEXEC|unix.O_NONBLOCK, 0)
+	}
+
+	if !a.createInNS || !a.ns.IsOpen() {
+		return open()
+	}
+
+	var fd int
+	err := inNetNS(a.ns, func() error {
+		var err error
+		fd, err = open()
+		return err
+	})
+
+	return fd, err
+}
+
+// inNetNS runs fn on a locked OS thread switched into ns.
+func inNetNS(ns netns.NsHandle, fn func() error) error {
+	runtime.LockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	defer origin.Close()
+
+	if err := netns.Set(ns); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+
+	fnErr := fn()
+
+	// A thread that cannot be switched back stays locked so that the runtime discards it.
+	if err := netns.Set(origin); err != nil {
+		return fmt.Errorf("failed to restore network namespace: %w", err)
+	}
+
+	runtime.UnlockOSThread()
+
+	return fnErr
+}
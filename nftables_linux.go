@@ -0,0 +1,194 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"fmt"
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	"net"
+)
+
+// nftConn opens an nftables connection in ns, or in the caller's namespace when ns is not open.
+func nftConn(ns netns.NsHandle) (*nftables.Conn, error) {
+	var opts []nftables.ConnOption
+	if ns.IsOpen() {
+		opts = append(opts, nftables.WithNetNSFd(int(ns)))
+	}
+
+	conn, err := nftables.New(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open nftables connection: %w", err)
+	}
+
+	return conn, nil
+}
+
+// replaceNftTable queues the atomic replacement of table: adding it first makes the deletion valid whether or not
+// the table already exists.
+func replaceNftTable(conn *nftables.Conn, table *nftables.Table) {
+	conn.AddTable(table)
+	conn.DelTable(table)
+	conn.AddTable(table)
+}
+
+// deleteNftTable removes table and everything it contains, doing nothing if it does not exist.
+func deleteNftTable(ns netns.NsHandle, table *nftables.Table) error {
+	conn, err := nftConn(ns)
+	if err != nil {
+		return err
+	}
+
+	conn.AddTable(table)
+	conn.DelTable(table)
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to delete nftables table %s: %w", table.Name, err)
+	}
+
+	return nil
+}
+
+// nftTableExists reports whether a table of family called name exists in ns.
+func nftTableExists(ns netns.NsHandle, name string, family nftables.TableFamily) (bool, error) {
+	conn, err := nftConn(ns)
+	if err != nil {
+		return false, err
+	}
+
+	tables, err := conn.ListTablesOfFamily(family)
+	if err != nil {
+		return false, fmt.Errorf("failed to list nftables tables: %w", err)
+	}
+
+	for _, table := range tables {
+		if table.Name == name {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// matchMeta matches the meta key against data.
+func matchMeta(key expr.MetaKey, data []byte) []expr.Any {
+	return []expr.Any{
+		&expr.Meta{Key: key, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: data},
+	}
+}
+
+// matchInterfaceName matches the input or output interface name of a packet.
+func matchInterfaceName(key expr.MetaKey, name string) []expr.Any {
+	data := make([]byte, unix.IFNAMSIZ)
+	copy(data, name)
+
+	return matchMeta(key, data)
+}
+
+// excludeInterfaceName matches packets whose input or output interface name differs from name.
+func excludeInterfaceName(key expr.MetaKey, name string) []expr.Any {
+	data := make([]byte, unix.IFNAMSIZ)
+	copy(data, name)
+
+	return []expr.Any{
+		&expr.Meta{Key: key, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: data},
+	}
+}
+
+// matchPrefix matches the source or destination address of a packet against prefix.
+func matchPrefix(prefix *net.IPNet, source bool) []expr.Any {
+	family, ip, offset := byte(unix.NFPROTO_IPV6), prefix.IP.To16(), uint32(24)
+	if source {
+		offset = 8
+	}
+
+	mask := prefix.Mask
+	if ipv4 := prefix.IP.To4(); ipv4 != nil {
+		family, ip, offset = unix.NFPROTO_IPV4, ipv4, 16
+		if source {
+			offset = 12
+		}
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+	}
+
+	exprs := matchMeta(expr.MetaKeyNFPROTO, []byte{family})
+	exprs = append(exprs, &expr.Payload{
+		DestRegister: 1,
+		Base:         expr.PayloadBaseNetworkHeader,
+		Offset:       offset,
+		Len:          uint32(len(ip)),
+	})
+
+	if ones, bits := mask.Size(); ones != bits {
+		exprs = append(exprs, &expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            uint32(len(ip)),
+			Mask:           mask,
+			Xor:            make([]byte, len(ip)),
+		})
+	}
+
+	return append(exprs, &expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: ip.Mask(mask)})
+}
+
+// matchDestinationPort matches the transport protocol and, when port is non-zero, the destination port of a packet.
+func matchDestinationPort(protocol int, port uint16) []expr.Any {
+	if protocol == 0 {
+		return nil
+	}
+
+	exprs := matchMeta(expr.MetaKeyL4PROTO, []byte{byte(protocol)})
+	if port == 0 {
+		return exprs
+	}
+
+	return append(exprs,
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(port)},
+	)
+}
+
+// matchNeighborDiscovery matches ICMPv6 router and neighbor solicitations and advertisements and redirects, the
+// types 133 to 137 IPv6 needs to reach any next hop.
+func matchNeighborDiscovery() []expr.Any {
+	exprs := matchMeta(expr.MetaKeyNFPROTO, []byte{unix.NFPROTO_IPV6})
+	exprs = append(exprs, matchMeta(expr.MetaKeyL4PROTO, []byte{unix.IPPROTO_ICMPV6})...)
+
+	return append(exprs,
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 0, Len: 1},
+		&expr.Range{Op: expr.CmpOpEq, Register: 1, FromData: []byte{133}, ToData: []byte{137}},
+	)
+}
+
+// matchEstablished matches packets of established or related connections.
+func matchEstablished() []expr.Any {
+	return []expr.Any{
+		&expr.Ct{Register: 1, Key: expr.CtKeySTATE},
+		&expr.Bitwise{
+			SourceRegister: 1,
+			DestRegister:   1,
+			Len:            4,
+			Mask:           binaryutil.NativeEndian.PutUint32(expr.CtStateBitESTABLISHED | expr.CtStateBitRELATED),
+			Xor:            binaryutil.NativeEndian.PutUint32(0),
+		},
+		&expr.Cmp{Op: expr.CmpOpNeq, Register: 1, Data: binaryutil.NativeEndian.PutUint32(0)},
+	}
+}
+
+// verdict appends a verdict of the given kind to exprs.
+func verdict(kind expr.VerdictKind, exprs ...[]expr.Any) []expr.Any {
+	var rule []expr.Any
+	for _, e := range exprs {
+		rule = append(rule, e...)
+	}
+
+	return append(rule, &expr.Verdict{Kind: kind})
+}
@@ -0,0 +1,670 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftutils"
+	"golang.org/x/sys/unix"
+	"io"
+	"sync"
+)
+
+// Offload flags accepted by the TUNSETOFFLOAD ioctl.
+const (
+	tunFCsum = 0x01
+	tunFTSO4 = 0x02
+	tunFTSO6 = 0x04
+	tunFUSO4 = 0x20
+	tunFUSO6 = 0x40
+)
+
+// Layout and flag values of the virtio_net_hdr exchanged with the kernel when IFF_VNET_HDR is set.
+const (
+	VirtioNetHdrLen = 10
+
+	VirtioNetHdrFNeedsCsum = 0x01
+	VirtioNetHdrFDataValid = 0x02
+
+	VirtioNetHdrGSONone  = 0x00
+	VirtioNetHdrGSOTCPv4 = 0x01
+	VirtioNetHdrGSOUDP   = 0x03
+	VirtioNetHdrGSOTCPv6 = 0x04
+	VirtioNetHdrGSOUDPL4 = 0x05
+	VirtioNetHdrGSOECN   = 0x80
+)
+
+const (
+	tcpFlagFIN = 0x01
+	tcpFlagSYN = 0x02
+	tcpFlagRST = 0x04
+	tcpFlagPSH = 0x08
+	tcpFlagACK = 0x10
+	tcpFlagURG = 0x20
+	tcpFlagECE = 0x40
+	tcpFlagCWR = 0x80
+)
+
+const (
+	// offloadBufferSize fits the largest super-packet plus its virtio header.
+	offloadBufferSize = VirtioNetHdrLen + 65535
+	// maxCoalescedSize is the largest IP packet produced by coalescing.
+	maxCoalescedSize = 65535
+	// maxCoalescedSegments keeps coalesced packets below the kernel's UDP segmentation limit.
+	maxCoalescedSegments = 64
+)
+
+var (
+	ErrMalformedVnetHdr = errors.New("malformed virtio-net header or super-packet")
+)
+
+// VirtioNetHdr is the virtio_net_hdr that precedes every packet of an offload-enabled device.
+type VirtioNetHdr struct {
+	Flags      uint8
+	GSOType    uint8
+	HdrLen     uint16
+	GSOSize    uint16
+	CsumStart  uint16
+	CsumOffset uint16
+}
+
+// Decode reads the header from the first VirtioNetHdrLen bytes of b.
+func (h *VirtioNetHdr) Decode(b []byte) error {
+	if len(b) < VirtioNetHdrLen {
+		return io.ErrShortBuffer
+	}
+
+	h.Flags = b[0]
+	h.GSOType = b[1]
+	h.HdrLen = binary.NativeEndian.Uint16(b[2:])
+	h.GSOSize = binary.NativeEndian.Uint16(b[4:])
+	h.CsumStart = binary.NativeEndian.Uint16(b[6:])
+	h.CsumOffset = binary.NativeEndian.Uint16(b[8:])
+
+	return nil
+}
+
+// Encode writes the header into the first VirtioNetHdrLen bytes of b.
+func (h *VirtioNetHdr) Encode(b []byte) error {
+	if len(b) < VirtioNetHdrLen {
+		return io.ErrShortBuffer
+	}
+
+	b[0] = h.Flags
+	b[1] = h.GSOType
+	binary.NativeEndian.PutUint16(b[2:], h.HdrLen)
+	binary.NativeEndian.PutUint16(b[4:], h.GSOSize)
+	binary.NativeEndian.PutUint16(b[6:], h.CsumStart)
+	binary.NativeEndian.PutUint16(b[8:], h.CsumOffset)
+
+	return nil
+}
+
+// setOffload enables checksum and segmentation offloads, falling back when UDP segmentation is unavailable.
+func setOffload(fd uintptr) (bool, error) {
+	flags := uintptr(tunFCsum | tunFTSO4 | tunFTSO6)
+
+	if err := ioctl(fd, unix.TUNSETOFFLOAD, flags|tunFUSO4|tunFUSO6); err == nil {
+		return true, nil
+	}
+
+	return false, ioctl(fd, unix.TUNSETOFFLOAD, flags)
+}
+
+// offload translates between virtio super-packets and plain IP packets for a single queue.
+type offload struct {
+	raw bool
+	uso bool
+
+	readMu     sync.Mutex
+	readBuf    []byte
+	segBuf     []byte
+	pending    [][]byte
+	pendingIdx int
+
+	writeMu  sync.Mutex
+	writeBuf []byte
+	flows    []groFlow
+	open     map[groKey]int
+}
+
+// newOffload allocates the buffers used by an offload-enabled queue.
+func newOffload(raw, uso bool) *offload {
+	o := &offload{
+		raw: raw,
+		uso: uso,
+	}
+
+	if !raw {
+		o.readBuf = make([]byte, offloadBufferSize)
+		o.writeBuf = make([]byte, offloadBufferSize)
+		o.open = make(map[groKey]int)
+	}
+
+	return o
+}
+
+// read returns the next plain IP packet, reading and segmenting a new super-packet when none is pending.
+func (o *offload) read(r io.Reader, buf []byte) (int, error) {
+	var sizes [1]int
+
+	if _, err := o.readBatch(r, [][]byte{buf}, sizes[:]); err != nil {
+		return 0, err
+	}
+
+	return sizes[0], nil
+}
+
+// readBatch hands out the pending segments of the current super-packet, reading a new one when none is left.
+func (o *offload) readBatch(r io.Reader, bufs [][]byte, sizes []int) (int, error) {
+	if len(sizes) < len(bufs) {
+		return 0, ErrBatchSizeMismatch
+	}
+
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+
+	o.readMu.Lock()
+	defer o.readMu.Unlock()
+
+	for o.pendingIdx >= len(o.pending) {
+		n, err := r.Read(o.readBuf)
+		if err != nil {
+			return 0, err
+		}
+
+		if err := o.split(o.readBuf[:n]); err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	for count < len(bufs) && o.pendingIdx < len(o.pending) {
+		segment := o.pending[o.pendingIdx]
+		o.pendingIdx++
+
+		if len(bufs[count]) < len(segment) {
+			return count, io.ErrShortBuffer
+		}
+
+		sizes[count] = copy(bufs[count], segment)
+		count++
+	}
+
+	return count, nil
+}
+
+// write sends a single plain IP packet behind an empty virtio header.
+func (o *offload) write(w io.Writer, buf []byte) (int, error) {
+	if len(buf) > maxCoalescedSize {
+		return 0, fmt.Errorf("packet of %d bytes exceeds the maximum of %d", len(buf), maxCoalescedSize)
+	}
+
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+
+	clear(o.writeBuf[:VirtioNetHdrLen])
+	n := copy(o.writeBuf[VirtioNetHdrLen:], buf)
+
+	if _, err := w.Write(o.writeBuf[:VirtioNetHdrLen+n]); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// split decodes the virtio header of frame and stores the resulting IP packets as pending segments.
+func (o *offload) split(frame []byte) error {
+	var hdr VirtioNetHdr
+	if err := hdr.Decode(frame); err != nil {
+		return ErrMalformedVnetHdr
+	}
+
+	o.pending = o.pending[:0]
+	o.pendingIdx = 0
+
+	segments, segBuf, err := gsoSplit(frame[VirtioNetHdrLen:], &hdr, o.segBuf, o.pending)
+	if err != nil {
+		return err
+	}
+
+	o.pending = segments
+	o.segBuf = segBuf
+
+	return nil
+}
+
+// gsoSplit segments pkt according to hdr, appending the resulting packets to segments.
+// Segments are carved out of scratch, which is grown and returned when too small.
+func gsoSplit(pkt []byte, hdr *VirtioNetHdr, scratch []byte, segments [][]byte) ([][]byte, []byte, error) {
+	if len(pkt) == 0 {
+		return segments, scratch, nil
+	}
+
+	if hdr.GSOType == VirtioNetHdrGSONone {
+		if hdr.Flags&VirtioNetHdrFNeedsCsum != 0 {
+			if err := completeChecksum(pkt, hdr); err != nil {
+				return segments, scratch, err
+			}
+		}
+
+		return append(segments, pkt), scratch, nil
+	}
+
+	version := pkt[0] >> 4
+	transportStart := int(hdr.CsumStart)
+
+	var protocol uint8
+	var checksumOffset int
+
+	switch hdr.GSOType &^ VirtioNetHdrGSOECN {
+	case VirtioNetHdrGSOTCPv4:
+		if version != 4 {
+			return segments, scratch, ErrMalformedVnetHdr
+		}
+		protocol, checksumOffset = unix.IPPROTO_TCP, 16
+	case VirtioNetHdrGSOTCPv6:
+		if version != 6 {
+			return segments, scratch, ErrMalformedVnetHdr
+		}
+		protocol, checksumOffset = unix.IPPROTO_TCP, 16
+	case VirtioNetHdrGSOUDPL4:
+		protocol, checksumOffset = unix.IPPROTO_UDP, 6
+	default:
+		return segments, scratch, fmt.Errorf("unsupported GSO type %d", hdr.GSOType)
+	}
+
+	src, dst, ok := packetAddresses(pkt)
+	if !ok || transportStart < ipv4HeaderMin || (version == 6 && transportStart < ipv6HeaderLen) {
+		return segments, scratch, ErrMalformedVnetHdr
+	}
+
+	transportLen := 8
+	if protocol == unix.IPPROTO_TCP {
+		if len(pkt) < transportStart+20 {
+			return segments, scratch, ErrMalformedVnetHdr
+		}
+		if transportLen = int(pkt[transportStart+12]>>4) * 4; transportLen < 20 {
+			return segments, scratch, ErrMalformedVnetHdr
+		}
+	}
+
+	headerLen := transportStart + transportLen
+	gsoSize := int(hdr.GSOSize)
+
+	if headerLen > len(pkt) || gsoSize == 0 {
+		return segments, scratch, ErrMalformedVnetHdr
+	}
+
+	payload := pkt[headerLen:]
+	count := max((len(payload)+gsoSize-1)/gsoSize, 1)
+
+	if need := count*headerLen + len(payload); cap(scratch) < need {
+		scratch = make([]byte, need)
+	}
+
+	var ipID uint16
+	if version == 4 {
+		ipID = binary.BigEndian.Uint16(pkt[4:])
+	}
+
+	var seq uint32
+	if protocol == unix.IPPROTO_TCP {
+		seq = binary.BigEndian.Uint32(pkt[transportStart+4:])
+	}
+
+	offset := 0
+	for i := 0; i < count; i++ {
+		chunk := payload[min(i*gsoSize, len(payload)):min((i+1)*gsoSize, len(payload))]
+
+		segment := scratch[offset : offset+headerLen+len(chunk)]
+		copy(segment, pkt[:headerLen])
+		copy(segment[headerLen:], chunk)
+		offset += len(segment)
+
+		if version == 4 {
+			binary.BigEndian.PutUint16(segment[2:], uint16(len(segment)))
+			binary.BigEndian.PutUint16(segment[4:], ipID+uint16(i))
+			swiftutils.IPv4HeaderChecksum(segment)
+		} else {
+			binary.BigEndian.PutUint16(segment[4:], uint16(len(segment)-ipv6HeaderLen))
+		}
+
+		transport := segment[transportStart:]
+
+		if protocol == unix.IPPROTO_TCP {
+			binary.BigEndian.PutUint32(transport[4:], seq+uint32(i*gsoSize))
+			if i > 0 {
+				transport[13] &^= tcpFlagCWR
+			}
+			if i < count-1 {
+				transport[13] &^= tcpFlagFIN | tcpFlagPSH
+			}
+		} else {
+			binary.BigEndian.PutUint16(transport[4:], uint16(len(transport)))
+		}
+
+		transport[checksumOffset], transport[checksumOffset+1] = 0, 0
+		sum := ^swiftutils.Checksum(transport, swiftutils.PseudoHeaderChecksum(protocol, src, dst, uint16(len(transport))))
+		if sum == 0 && protocol == unix.IPPROTO_UDP {
+			sum = 0xFFFF
+		}
+		binary.BigEndian.PutUint16(transport[checksumOffset:], sum)
+
+		segments = append(segments, segment)
+	}
+
+	return segments, scratch, nil
+}
+
+// completeChecksum finishes a partial checksum left by the kernel for a packet flagged NEEDS_CSUM.
+func completeChecksum(pkt []byte, hdr *VirtioNetHdr) error {
+	start := int(hdr.CsumStart)
+	field := start + int(hdr.CsumOffset)
+
+	if field+2 > len(pkt) {
+		return ErrMalformedVnetHdr
+	}
+
+	sum := ^swiftutils.Checksum(pkt[start:], 0)
+	if sum == 0 && packetProtocol(pkt) == unix.IPPROTO_UDP {
+		sum = 0xFFFF
+	}
+
+	binary.BigEndian.PutUint16(pkt[field:], sum)
+
+	return nil
+}
+
+// packetAddresses returns the source and destination addresses of an IPv4 or IPv6 packet.
+func packetAddresses(pkt []byte) ([]byte, []byte, bool) {
+	switch {
+	case swiftutils.IsIPv4(pkt) && len(pkt) >= ipv4HeaderMin:
+		return pkt[12:16], pkt[16:20], true
+	case swiftutils.IsIPv6(pkt) && len(pkt) >= ipv6HeaderLen:
+		return pkt[8:24], pkt[24:40], true
+	default:
+		return nil, nil, false
+	}
+}
+
+// packetProtocol returns the transport protocol carried directly by an IP packet.
+func packetProtocol(pkt []byte) int {
+	if swiftutils.IsIPv6(pkt) {
+		return swiftutils.IPv6NextHeader(pkt)
+	}
+
+	return swiftutils.IPv4Protocol(pkt)
+}
+
+// groKey identifies the transport flow a packet belongs to.
+type groKey struct {
+	src, dst         [16]byte
+	srcPort, dstPort uint16
+	protocol         uint8
+	version          uint8
+}
+
+// groPacket holds the fields of a packet that decide whether it can be coalesced.
+type groPacket struct {
+	key          groKey
+	ipHeaderLen  int
+	headerLen    int
+	payloadLen   int
+	seq, ack     uint32
+	window       uint16
+	flags        uint8
+	coalescePort bool
+}
+
+// groFlow is a run of packets that will be written as one super-packet.
+type groFlow struct {
+	packets   []int
+	head      groPacket
+	gsoSize   int
+	totalLen  int
+	nextSeq   uint32
+	short     bool
+	psh       bool
+	coalesced bool
+}
+
+// parseGRO extracts coalescing metadata from pkt, reporting false when the packet must be written alone.
+func parseGRO(pkt []byte) (groPacket, bool) {
+	var p groPacket
+
+	switch {
+	case swiftutils.IsIPv4(pkt):
+		if len(pkt) < ipv4HeaderMin || pkt[0]&0x0F != 5 || int(binary.BigEndian.Uint16(pkt[2:])) != len(pkt) {
+			return p, false
+		}
+		if binary.BigEndian.Uint16(pkt[6:])&0x3FFF != 0 {
+			return p, false
+		}
+		p.key.version = 4
+		p.key.protocol = pkt[9]
+		p.ipHeaderLen = ipv4HeaderMin
+		copy(p.key.src[:], pkt[12:16])
+		copy(p.key.dst[:], pkt[16:20])
+	case swiftutils.IsIPv6(pkt):
+		if len(pkt) < ipv6HeaderLen || int(binary.BigEndian.Uint16(pkt[4:]))+ipv6HeaderLen != len(pkt) {
+			return p, false
+		}
+		p.key.version = 6
+		p.key.protocol = pkt[6]
+		p.ipHeaderLen = ipv6HeaderLen
+		copy(p.key.src[:], pkt[8:24])
+		copy(p.key.dst[:], pkt[24:40])
+	default:
+		return p, false
+	}
+
+	transport := pkt[p.ipHeaderLen:]
+
+	switch p.key.protocol {
+	case unix.IPPROTO_TCP:
+		if len(transport) < 20 {
+			return p, false
+		}
+		transportLen := int(transport[12]>>4) * 4
+		if transportLen < 20 || transportLen > len(transport) {
+			return p, false
+		}
+		p.flags = transport[13]
+		if p.flags&tcpFlagACK == 0 || p.flags&^(tcpFlagACK|tcpFlagPSH) != 0 {
+			return p, false
+		}
+		p.headerLen = p.ipHeaderLen + transportLen
+		p.seq = binary.BigEndian.Uint32(transport[4:])
+		p.ack = binary.BigEndian.Uint32(transport[8:])
+		p.window = binary.BigEndian.Uint16(transport[14:])
+	case unix.IPPROTO_UDP:
+		if len(transport) < 8 || int(binary.BigEndian.Uint16(transport[4:])) != len(transport) {
+			return p, false
+		}
+		p.headerLen = p.ipHeaderLen + 8
+	default:
+		return p, false
+	}
+
+	p.key.srcPort = binary.BigEndian.Uint16(transport[0:])
+	p.key.dstPort = binary.BigEndian.Uint16(transport[2:])
+	p.payloadLen = len(pkt) - p.headerLen
+
+	return p, p.payloadLen > 0
+}
+
+// canAppend reports whether pkt continues the flow without breaking segmentation rules.
+func (f *groFlow) canAppend(pkts [][]byte, pkt []byte, p *groPacket) bool {
+	head := pkts[f.packets[0]]
+
+	if f.short || f.psh || len(f.packets) >= maxCoalescedSegments {
+		return false
+	}
+	if p.headerLen != f.head.headerLen || p.payloadLen > f.gsoSize || f.totalLen+p.payloadLen > maxCoalescedSize {
+		return false
+	}
+
+	if p.key.version == 4 {
+		if head[1] != pkt[1] || head[8] != pkt[8] || head[6]&0x40 != pkt[6]&0x40 {
+			return false
+		}
+	} else if binary.BigEndian.Uint32(head) != binary.BigEndian.Uint32(pkt) || head[7] != pkt[7] {
+		return false
+	}
+
+	if p.key.protocol == unix.IPPROTO_TCP {
+		if p.seq != f.nextSeq || p.ack != f.head.ack || p.window != f.head.window {
+			return false
+		}
+
+		optionsStart := f.head.ipHeaderLen + 20
+		if string(head[optionsStart:f.head.headerLen]) != string(pkt[optionsStart:p.headerLen]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// append adds the packet at index to the flow.
+func (f *groFlow) append(index int, p *groPacket) {
+	f.packets = append(f.packets, index)
+	f.totalLen += p.payloadLen
+	f.nextSeq = p.seq + uint32(p.payloadLen)
+	f.short = p.payloadLen < f.gsoSize
+	f.psh = p.flags&tcpFlagPSH != 0
+	f.coalesced = true
+}
+
+// groupPackets partitions pkts into flows, merging consecutive segments of the same TCP or UDP stream. A packet
+// written alone closes every open flow, as it may belong to one of them and must not be overtaken by later segments.
+func (o *offload) groupPackets(pkts [][]byte) []groFlow {
+	flows := o.flows[:0]
+	clear(o.open)
+
+	for i, pkt := range pkts {
+		p, ok := parseGRO(pkt)
+		if !ok {
+			clear(o.open)
+			flows = append(flows, groFlow{packets: []int{i}})
+			continue
+		}
+
+		if p.key.protocol == unix.IPPROTO_UDP && !o.uso {
+			flows = append(flows, groFlow{packets: []int{i}})
+			continue
+		}
+
+		if index, exists := o.open[p.key]; exists {
+			if flows[index].canAppend(pkts, pkt, &p) {
+				flows[index].append(i, &p)
+				continue
+			}
+		}
+
+		o.open[p.key] = len(flows)
+		flows = append(flows, groFlow{
+			packets:  []int{i},
+			head:     p,
+			gsoSize:  p.payloadLen,
+			totalLen: len(pkt),
+			nextSeq:  p.seq + uint32(p.payloadLen),
+			psh:      p.flags&tcpFlagPSH != 0,
+		})
+	}
+
+	o.flows = flows
+
+	return flows
+}
+
+// buildFlow writes the super-packet for flow, preceded by its virtio header, into buf.
+func buildFlow(buf []byte, pkts [][]byte, flow *groFlow) (int, error) {
+	var hdr VirtioNetHdr
+
+	head := pkts[flow.packets[0]]
+
+	if !flow.coalesced {
+		if VirtioNetHdrLen+len(head) > len(buf) {
+			return 0, fmt.Errorf("packet of %d bytes exceeds the maximum of %d", len(head), maxCoalescedSize)
+		}
+
+		_ = hdr.Encode(buf)
+		return VirtioNetHdrLen + copy(buf[VirtioNetHdrLen:], head), nil
+	}
+
+	out := buf[VirtioNetHdrLen:]
+	n := copy(out, head)
+
+	for _, index := range flow.packets[1:] {
+		n += copy(out[n:], pkts[index][flow.head.headerLen:])
+	}
+
+	pkt := out[:n]
+	src, dst, _ := packetAddresses(pkt)
+	ipHeaderLen := flow.head.ipHeaderLen
+	transport := pkt[ipHeaderLen:]
+
+	if flow.head.key.version == 4 {
+		binary.BigEndian.PutUint16(pkt[2:], uint16(n))
+		swiftutils.IPv4HeaderChecksum(pkt)
+	} else {
+		binary.BigEndian.PutUint16(pkt[4:], uint16(n-ipv6HeaderLen))
+	}
+
+	hdr.Flags = VirtioNetHdrFNeedsCsum
+	hdr.HdrLen = uint16(flow.head.headerLen)
+	hdr.GSOSize = uint16(flow.gsoSize)
+	hdr.CsumStart = uint16(ipHeaderLen)
+
+	if flow.head.key.protocol == unix.IPPROTO_TCP {
+		if flow.psh {
+			transport[13] |= tcpFlagPSH
+		}
+
+		hdr.GSOType = VirtioNetHdrGSOTCPv4
+		if flow.head.key.version == 6 {
+			hdr.GSOType = VirtioNetHdrGSOTCPv6
+		}
+		hdr.CsumOffset = 16
+	} else {
+		binary.BigEndian.PutUint16(transport[4:], uint16(len(transport)))
+
+		hdr.GSOType = VirtioNetHdrGSOUDPL4
+		hdr.CsumOffset = 6
+	}
+
+	partial := swiftutils.Checksum(nil, swiftutils.PseudoHeaderChecksum(flow.head.key.protocol, src, dst, uint16(len(transport))))
+	binary.BigEndian.PutUint16(transport[hdr.CsumOffset:], partial)
+
+	_ = hdr.Encode(buf)
+
+	return VirtioNetHdrLen + n, nil
+}
+
+// writePackets coalesces pkts into as few super-packets as possible and writes them.
+// It returns the number of input packets that were written.
+func (o *offload) writePackets(w io.Writer, pkts [][]byte) (int, error) {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+
+	written := 0
+	for _, flow := range o.groupPackets(pkts) {
+		n, err := buildFlow(o.writeBuf, pkts, &flow)
+		if err != nil {
+			return written, err
+		}
+
+		if _, err := w.Write(o.writeBuf[:n]); err != nil {
+			return written, err
+		}
+
+		written += len(flow.packets)
+	}
+
+	return written, nil
+}
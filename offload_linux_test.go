@@ -0,0 +1,434 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftconfig"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftutils"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"golang.org/x/sys/unix"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	testSrc4 = []byte{10, 0, 0, 1}
+	testDst4 = []byte{10, 0, 0, 2}
+	testSrc6 = []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	testDst6 = []byte{0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2}
+)
+
+// buildTestPacket assembles an IPv4 or IPv6 TCP/UDP packet with valid checksums.
+func buildTestPacket(version int, protocol uint8, seq uint32, flags uint8, payload []byte) []byte {
+	ipHeaderLen := ipv4HeaderMin
+	src, dst := testSrc4, testDst4
+	if version == 6 {
+		ipHeaderLen = ipv6HeaderLen
+		src, dst = testSrc6, testDst6
+	}
+
+	transportLen := 8
+	if protocol == unix.IPPROTO_TCP {
+		transportLen = 20
+	}
+
+	pkt := make([]byte, ipHeaderLen+transportLen+len(payload))
+	copy(pkt[ipHeaderLen+transportLen:], payload)
+
+	if version == 4 {
+		pkt[0] = 0x45
+		binary.BigEndian.PutUint16(pkt[2:], uint16(len(pkt)))
+		binary.BigEndian.PutUint16(pkt[4:], 0x1234)
+		pkt[6] = 0x40
+		pkt[8] = 64
+		pkt[9] = protocol
+		copy(pkt[12:], src)
+		copy(pkt[16:], dst)
+		swiftutils.IPv4HeaderChecksum(pkt)
+	} else {
+		pkt[0] = 0x60
+		binary.BigEndian.PutUint16(pkt[4:], uint16(len(pkt)-ipv6HeaderLen))
+		pkt[6] = protocol
+		pkt[7] = 64
+		copy(pkt[8:], src)
+		copy(pkt[24:], dst)
+	}
+
+	transport := pkt[ipHeaderLen:]
+	binary.BigEndian.PutUint16(transport[0:], 40000)
+	binary.BigEndian.PutUint16(transport[2:], 443)
+
+	checksumOffset := 6
+	if protocol == unix.IPPROTO_TCP {
+		binary.BigEndian.PutUint32(transport[4:], seq)
+		binary.BigEndian.PutUint32(transport[8:], 7)
+		transport[12] = 5 << 4
+		transport[13] = flags
+		binary.BigEndian.PutUint16(transport[14:], 512)
+		checksumOffset = 16
+	} else {
+		binary.BigEndian.PutUint16(transport[4:], uint16(len(transport)))
+	}
+
+	sum := ^swiftutils.Checksum(transport, swiftutils.PseudoHeaderChecksum(protocol, src, dst, uint16(len(transport))))
+	binary.BigEndian.PutUint16(transport[checksumOffset:], sum)
+
+	return pkt
+}
+
+// verifyChecksums fails the test when the IP or transport checksum of pkt is invalid.
+func verifyChecksums(t *testing.T, pkt []byte) {
+	t.Helper()
+
+	src, dst, _ := packetAddresses(pkt)
+	ipHeaderLen := ipv6HeaderLen
+
+	if swiftutils.IsIPv4(pkt) {
+		ipHeaderLen = swiftutils.IPv4HeaderLength(pkt)
+		if swiftutils.Checksum(pkt[:ipHeaderLen], 0) != 0xFFFF {
+			t.Fatalf("invalid IPv4 header checksum")
+		}
+	}
+
+	transport := pkt[ipHeaderLen:]
+	protocol := uint8(packetProtocol(pkt))
+	if swiftutils.Checksum(transport, swiftutils.PseudoHeaderChecksum(protocol, src, dst, uint16(len(transport)))) != 0xFFFF {
+		t.Fatalf("invalid transport checksum")
+	}
+}
+
+func TestGSOSplitTCPv4(t *testing.T) {
+	payload := bytes.Repeat([]byte("swiftunnel"), 250)
+	super := buildTestPacket(4, unix.IPPROTO_TCP, 1000, tcpFlagACK|tcpFlagPSH, payload)
+
+	hdr := &VirtioNetHdr{
+		Flags:      VirtioNetHdrFNeedsCsum,
+		GSOType:    VirtioNetHdrGSOTCPv4,
+		HdrLen:     40,
+		GSOSize:    1000,
+		CsumStart:  20,
+		CsumOffset: 16,
+	}
+
+	segments, _, err := gsoSplit(super, hdr, nil, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 segments, got %d", len(segments))
+	}
+
+	for i, segment := range segments {
+		verifyChecksums(t, segment)
+
+		if seq := binary.BigEndian.Uint32(segment[24:]); seq != uint32(1000+i*1000) {
+			t.Errorf("segment %d: expected seq %d, got %d", i, 1000+i*1000, seq)
+		}
+
+		if psh := segment[33]&tcpFlagPSH != 0; psh != (i == 2) {
+			t.Errorf("segment %d: unexpected PSH flag %v", i, psh)
+		}
+	}
+
+	if got := len(segments[2]) - 40; got != 500 {
+		t.Errorf("expected last segment payload of 500 bytes, got %d", got)
+	}
+}
+
+func TestGSOSplitUDPv6(t *testing.T) {
+	payload := bytes.Repeat([]byte{0xAB}, 3000)
+	super := buildTestPacket(6, unix.IPPROTO_UDP, 0, 0, payload)
+
+	hdr := &VirtioNetHdr{
+		Flags:      VirtioNetHdrFNeedsCsum,
+		GSOType:    VirtioNetHdrGSOUDPL4,
+		HdrLen:     48,
+		GSOSize:    1200,
+		CsumStart:  40,
+		CsumOffset: 6,
+	}
+
+	segments, _, err := gsoSplit(super, hdr, nil, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(segments) != 3 {
+		t.Fatalf("expected 3 segments, got %d", len(segments))
+	}
+
+	for _, segment := range segments {
+		verifyChecksums(t, segment)
+
+		if length := binary.BigEndian.Uint16(segment[44:]); int(length) != len(segment)-40 {
+			t.Errorf("expected UDP length %d, got %d", len(segment)-40, length)
+		}
+	}
+}
+
+func TestGSOSplitMalformed(t *testing.T) {
+	tcp := &VirtioNetHdr{GSOType: VirtioNetHdrGSOTCPv4, GSOSize: 8, CsumStart: 20, CsumOffset: 16}
+
+	tests := []struct {
+		name string
+		hdr  *VirtioNetHdr
+		pkt  func() []byte
+	}{
+		{
+			name: "wrong IP version",
+			hdr:  &VirtioNetHdr{GSOType: VirtioNetHdrGSOTCPv6, GSOSize: 8, CsumStart: 20, CsumOffset: 16},
+			pkt:  func() []byte { return buildTestPacket(4, unix.IPPROTO_TCP, 1, tcpFlagACK, make([]byte, 100)) },
+		},
+		{
+			name: "transport inside the IP header",
+			hdr:  &VirtioNetHdr{GSOType: VirtioNetHdrGSOTCPv4, GSOSize: 8, CsumStart: 10, CsumOffset: 16},
+			pkt:  func() []byte { return buildTestPacket(4, unix.IPPROTO_TCP, 1, tcpFlagACK, make([]byte, 100)) },
+		},
+		{
+			name: "zero TCP data offset",
+			hdr:  tcp,
+			pkt: func() []byte {
+				pkt := buildTestPacket(4, unix.IPPROTO_TCP, 1, tcpFlagACK, make([]byte, 100))
+				pkt[ipv4HeaderMin+12] = 0
+				return pkt
+			},
+		},
+		{
+			name: "TCP data offset below 5 words",
+			hdr:  tcp,
+			pkt: func() []byte {
+				pkt := buildTestPacket(4, unix.IPPROTO_TCP, 1, tcpFlagACK, make([]byte, 100))
+				pkt[ipv4HeaderMin+12] = 4 << 4
+				return pkt
+			},
+		},
+		{
+			name: "zero GSO size",
+			hdr:  &VirtioNetHdr{GSOType: VirtioNetHdrGSOTCPv4, CsumStart: 20, CsumOffset: 16},
+			pkt:  func() []byte { return buildTestPacket(4, unix.IPPROTO_TCP, 1, tcpFlagACK, make([]byte, 100)) },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := gsoSplit(test.pkt(), test.hdr, nil, nil); !errors.Is(err, ErrMalformedVnetHdr) {
+				t.Fatalf("expected ErrMalformedVnetHdr, got %v", err)
+			}
+		})
+	}
+}
+
+func TestCoalesceRoundTrip(t *testing.T) {
+	o := newOffload(false, true)
+
+	var pkts [][]byte
+	for i := 0; i < 4; i++ {
+		pkts = append(pkts, buildTestPacket(4, unix.IPPROTO_TCP, uint32(5000+i*1200), tcpFlagACK, bytes.Repeat([]byte{byte(i)}, 1200)))
+	}
+	pkts = append(pkts, buildTestPacket(6, unix.IPPROTO_UDP, 0, 0, []byte("standalone")))
+
+	flows := o.groupPackets(pkts)
+	if len(flows) != 2 {
+		t.Fatalf("expected 2 flows, got %d", len(flows))
+	}
+
+	buf := make([]byte, offloadBufferSize)
+	n, err := buildFlow(buf, pkts, &flows[0])
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var hdr VirtioNetHdr
+	if err := hdr.Decode(buf); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if hdr.GSOType != VirtioNetHdrGSOTCPv4 || hdr.GSOSize != 1200 {
+		t.Fatalf("unexpected virtio header %+v", hdr)
+	}
+
+	segments, _, err := gsoSplit(buf[VirtioNetHdrLen:n], &hdr, nil, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(segments) != 4 {
+		t.Fatalf("expected 4 segments, got %d", len(segments))
+	}
+
+	for i, segment := range segments {
+		if !bytes.Equal(segment[2:4], pkts[i][2:4]) || !bytes.Equal(segment[20:], pkts[i][20:]) {
+			t.Errorf("segment %d does not match the original packet", i)
+		}
+	}
+}
+
+func TestCoalesceSkipsNonContiguous(t *testing.T) {
+	o := newOffload(false, true)
+
+	pkts := [][]byte{
+		buildTestPacket(4, unix.IPPROTO_TCP, 100, tcpFlagACK, make([]byte, 100)),
+		buildTestPacket(4, unix.IPPROTO_TCP, 500, tcpFlagACK, make([]byte, 100)),
+		buildTestPacket(4, unix.IPPROTO_TCP, 600, tcpFlagACK|tcpFlagSYN, make([]byte, 100)),
+	}
+
+	if flows := o.groupPackets(pkts); len(flows) != 3 {
+		t.Fatalf("expected 3 flows, got %d", len(flows))
+	}
+}
+
+func TestCoalescePreservesFlowOrder(t *testing.T) {
+	o := newOffload(false, true)
+
+	pkts := [][]byte{
+		buildTestPacket(4, unix.IPPROTO_TCP, 100, tcpFlagACK, make([]byte, 100)),
+		buildTestPacket(4, unix.IPPROTO_TCP, 200, tcpFlagACK|tcpFlagFIN, make([]byte, 100)),
+		buildTestPacket(4, unix.IPPROTO_TCP, 200, tcpFlagACK, make([]byte, 100)),
+	}
+
+	flows := o.groupPackets(pkts)
+	if len(flows) != 3 {
+		t.Fatalf("expected 3 flows, got %d", len(flows))
+	}
+
+	for i, flow := range flows {
+		if len(flow.packets) != 1 || flow.packets[0] != i {
+			t.Fatalf("flow %d holds packets %v, expected [%d]", i, flow.packets, i)
+		}
+	}
+}
+
+func TestVnetHdrRead(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunvnet0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		MTU:           1500,
+		VnetHdr:       true,
+		UnicastConfig: testUnicastConfig(t, "10.199.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error setting status, got %v", err)
+	}
+
+	conn, err := net.Dial("udp4", "10.199.0.2:9999")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("swiftunnel")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	received := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, err := adapter.Read(buf)
+			if err != nil {
+				return
+			}
+
+			if swiftutils.IsIPv4(buf[:n]) && packetProtocol(buf[:n]) == unix.IPPROTO_UDP {
+				received <- append([]byte(nil), buf[:n]...)
+				return
+			}
+		}
+	}()
+
+	select {
+	case pkt := <-received:
+		verifyChecksums(t, pkt)
+
+		if !bytes.HasSuffix(pkt, []byte("swiftunnel")) {
+			t.Fatalf("unexpected payload in %x", pkt)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a packet")
+	}
+}
+
+func TestVnetHdrWriteBatch(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunvnet1",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		MTU:           1500,
+		VnetHdr:       true,
+		UnicastConfig: testUnicastConfig(t, "10.0.0.2/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error setting status, got %v", err)
+	}
+
+	var pkts [][]byte
+	for i := 0; i < 8; i++ {
+		pkts = append(pkts, buildTestPacket(4, unix.IPPROTO_TCP, uint32(1+i*1000), tcpFlagACK, make([]byte, 1000)))
+	}
+
+	n, err := adapter.WriteBatch(pkts)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if n != len(pkts) {
+		t.Fatalf("expected %d packets written, got %d", len(pkts), n)
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunbatch0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		MTU:           1500,
+		UnicastConfig: testUnicastConfig(t, "10.197.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error setting status, got %v", err)
+	}
+
+	conn, err := net.Dial("udp4", "10.197.0.2:9999")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("swiftunnel")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	bufs := [][]byte{make([]byte, 2048), make([]byte, 2048)}
+	sizes := make([]int, len(bufs))
+
+	if _, err := adapter.ReadBatch(bufs, sizes[:1]); !errors.Is(err, ErrBatchSizeMismatch) {
+		t.Fatalf("expected ErrBatchSizeMismatch, got %v", err)
+	}
+
+	n, err := adapter.ReadBatch(bufs, sizes)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if n < 1 || sizes[0] == 0 {
+		t.Fatalf("expected at least one packet, got %d", n)
+	}
+}
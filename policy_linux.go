@@ -0,0 +1,183 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"syscall"
+)
+
+// NewFwmarkRule returns a rule looking up table for packets whose firewall mark, under mask, equals mark.
+// A zero mask matches the whole mark.
+func NewFwmarkRule(table int, mark, mask uint32) *netlink.Rule {
+	if mask == 0 {
+		mask = ^uint32(0)
+	}
+
+	rule := netlink.NewRule()
+	rule.Table = table
+	rule.Mark = mark
+	rule.Mask = &mask
+
+	return rule
+}
+
+// NewSourceRule returns a rule looking up table for packets sent from src.
+func NewSourceRule(table int, src *net.IPNet) *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Table = table
+	rule.Src = src
+
+	return rule
+}
+
+// NewUIDRangeRule returns a rule looking up table for packets of sockets owned by a uid between start and end.
+func NewUIDRangeRule(table int, start, end uint32) *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Table = table
+	rule.UIDRange = netlink.NewRuleUIDRange(start, end)
+
+	return rule
+}
+
+// NewIifRule returns a rule looking up table for packets received on the interface named iface.
+func NewIifRule(table int, iface string) *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Table = table
+	rule.IifName = iface
+
+	return rule
+}
+
+// AddRule installs a policy routing rule. Rules without a Family, Src or Dst are installed for both IPv4 and IPv6.
+// The rule is removed again on Close.
+func (a *SwiftInterface) AddRule(rule *netlink.Rule) error {
+	for _, familyRule := range ruleFamilies(rule) {
+		if err := a.handle().RuleAdd(familyRule); err != nil {
+			return fmt.Errorf("failed to add rule %v: %w", familyRule, err)
+		}
+
+		a.changes.record(ruleKey(familyRule), func() error {
+			return a.handle().RuleDel(familyRule)
+		})
+	}
+
+	return nil
+}
+
+// RemoveRule deletes a policy routing rule, for both IPv4 and IPv6 when it has no Family, Src or Dst.
+// Rules that were not added through the interface are restored on Close.
+func (a *SwiftInterface) RemoveRule(rule *netlink.Rule) error {
+	for _, familyRule := range ruleFamilies(rule) {
+		if err := a.handle().RuleDel(familyRule); err != nil {
+			return fmt.Errorf("failed to remove rule %v: %w", familyRule, err)
+		}
+
+		if !a.changes.forget(ruleKey(familyRule)) {
+			a.changes.record(ruleKey(familyRule), func() error {
+				return a.handle().RuleAdd(familyRule)
+			})
+		}
+	}
+
+	return nil
+}
+
+// Rules lists the IPv4 and IPv6 policy routing rules looking up table.
+func (a *SwiftInterface) Rules(table int) ([]netlink.Rule, error) {
+	rules, err := a.handle().RuleListFiltered(unix.AF_UNSPEC, &netlink.Rule{Table: table}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+// RouteAllExceptMark sends all IPv4 and IPv6 traffic through the interface using a dedicated routing table, except
+// packets carrying mark, such as those of the transport socket (see SetSocketMark). More specific routes of the
+// main table keep precedence over the tunnel. Everything is removed again on Close.
+func (a *SwiftInterface) RouteAllExceptMark(table int, mark uint32) error {
+	if table == 0 || table == unix.RT_TABLE_MAIN || table == unix.RT_TABLE_LOCAL {
+		return fmt.Errorf("invalid routing table %d", table)
+	}
+
+	for _, dst := range []string{"0.0.0.0/0", "::/0"} {
+		_, ipNet, _ := net.ParseCIDR(dst)
+		if err := a.AddRoute(&netlink.Route{Dst: ipNet, Table: table}); err != nil {
+			return err
+		}
+	}
+
+	unmarked := NewFwmarkRule(table, mark, 0)
+	unmarked.Invert = true
+	if err := a.AddRule(unmarked); err != nil {
+		return err
+	}
+
+	suppress := netlink.NewRule()
+	suppress.Table = unix.RT_TABLE_MAIN
+	suppress.SuppressPrefixlen = 0
+
+	return a.AddRule(suppress)
+}
+
+// SetSocketMark sets the firewall mark of the packets sent through conn, e.g. to exempt the transport socket of a
+// VPN from RouteAllExceptMark.
+func SetSocketMark(conn syscall.Conn, mark uint32) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("failed to access socket: %w", err)
+	}
+
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(mark))
+	}); err != nil {
+		return fmt.Errorf("failed to access socket: %w", err)
+	}
+
+	if sockErr != nil {
+		return fmt.Errorf("failed to set socket mark: %w", os.NewSyscallError("setsockopt", sockErr))
+	}
+
+	return nil
+}
+
+// ruleFamilies expands a rule without an address family into one rule per family.
+func ruleFamilies(rule *netlink.Rule) []*netlink.Rule {
+	if rule == nil {
+		return nil
+	}
+
+	if rule.Family != 0 || rule.Src != nil || rule.Dst != nil {
+		single := *rule
+		return []*netlink.Rule{&single}
+	}
+
+	v4, v6 := *rule, *rule
+	v4.Family = unix.AF_INET
+	v6.Family = unix.AF_INET6
+
+	return []*netlink.Rule{&v4, &v6}
+}
+
+// ruleKey identifies a rule in the change log by its selector and table.
+func ruleKey(rule *netlink.Rule) string {
+	var mask uint32
+	if rule.Mask != nil {
+		mask = *rule.Mask
+	}
+
+	var uidRange netlink.RuleUIDRange
+	if rule.UIDRange != nil {
+		uidRange = *rule.UIDRange
+	}
+
+	return fmt.Sprintf("rule family %d priority %d from %s to %s iif %s fwmark %#x/%#x uid %d-%d invert %t suppress %d table %d",
+		rule.Family, rule.Priority, ipNetString(rule.Src), ipNetString(rule.Dst), rule.IifName, rule.Mark, mask,
+		uidRange.Start, uidRange.End, rule.Invert, rule.SuppressPrefixlen, rule.Table)
+}
@@ -0,0 +1,308 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"io/fs"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// DefaultResolvConfPath is the resolver file rewritten by SetDNS when systemd-resolved and resolvconf are absent.
+	DefaultResolvConfPath = "/etc/resolv.conf"
+
+	// resolvConfBackupSuffix names the copy of the original resolver file, or the symbolic link it was.
+	resolvConfBackupSuffix = ".swiftunnel.bak"
+	// resolvConfMarkerSuffix names the recovery marker listing the SHA-256 sums of the files written in its place.
+	resolvConfMarkerSuffix = ".swiftunnel"
+)
+
+// resolvConfContent renders config in resolv.conf syntax.
+func resolvConfContent(name string, config *swiftypes.DNSConfig) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# Generated by swiftunnel for %s; the original is restored when the interface closes.\n", name)
+	for _, server := range config.DnsServers {
+		fmt.Fprintf(&b, "nameserver %s\n", server)
+	}
+	if config.Domain != "" {
+		fmt.Fprintf(&b, "search %s\n", config.Domain)
+	}
+
+	return []byte(b.String())
+}
+
+// readResolvConf parses the servers and first search domain of the resolver file at path.
+func readResolvConf(path string) (*swiftypes.DNSConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	config := &swiftypes.DNSConfig{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "nameserver":
+			if ip := net.ParseIP(fields[1]); ip != nil {
+				config.DnsServers = append(config.DnsServers, ip)
+			}
+		case "search", "domain":
+			if config.Domain == "" {
+				config.Domain = fields[1]
+			}
+		}
+	}
+
+	return config, nil
+}
+
+// hasResolvconf reports whether a resolvconf program manages the system resolver file.
+func hasResolvconf() bool {
+	_, err := exec.LookPath("resolvconf")
+	return err == nil
+}
+
+// runResolvconf registers content as the resolver configuration of the interface called name.
+func runResolvconf(name string, content []byte) error {
+	cmd := exec.Command("resolvconf", "-a", name)
+	cmd.Stdin = bytes.NewReader(content)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to run resolvconf: %w: %s", err, bytes.TrimSpace(output))
+	}
+
+	return nil
+}
+
+// deleteResolvconf removes the resolver configuration registered for the interface called name.
+func deleteResolvconf(name string) error {
+	if output, err := exec.Command("resolvconf", "-d", name).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to run resolvconf: %w: %s", err, bytes.TrimSpace(output))
+	}
+
+	return nil
+}
+
+// writeResolvConf replaces the resolver file at path with content. The first write backs up the original file next to
+// it and creates a recovery marker holding the sums of the written content, so that RestoreResolvConf can put the
+// original back even after a crash. A file whose sum is not in the marker was written by someone else and is left
+// alone.
+func writeResolvConf(path string, content []byte) error {
+	current, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	sums, err := readResolvConfMarker(path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case sums == nil:
+		if err := backupResolvConf(path); err != nil {
+			return err
+		}
+		sums = []string{resolvConfSum(content)}
+	case slices.Contains(sums, resolvConfSum(current)):
+		// Both sums stay valid until the new content is in place.
+		sums = []string{resolvConfSum(current), resolvConfSum(content)}
+	case resolvConfIsOriginal(path):
+		// A previous run crashed before replacing the file.
+		sums = []string{resolvConfSum(content)}
+	default:
+		return fmt.Errorf("refusing to overwrite %s: it was modified by another program", path)
+	}
+
+	if err := writeFileAtomic(path+resolvConfMarkerSuffix, []byte(strings.Join(sums, "\n")+"\n"), 0o600); err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, content, 0o644)
+}
+
+// RestoreResolvConf puts back the resolver file at path, or DefaultResolvConfPath when empty, saved by SetDNS.
+// It does nothing without a recovery marker, so a later run can call it unconditionally to recover from a crash.
+// A file modified by another program since SetDNS is left in place and reported.
+func RestoreResolvConf(path string) error {
+	if path == "" {
+		path = DefaultResolvConfPath
+	}
+
+	sums, err := readResolvConfMarker(path)
+	if err != nil || sums == nil {
+		return err
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	backup := path + resolvConfBackupSuffix
+	_, err = os.Lstat(backup)
+	hasBackup := err == nil
+
+	switch {
+	case slices.Contains(sums, resolvConfSum(current)):
+		if hasBackup {
+			err = os.Rename(backup, path)
+		} else {
+			err = os.Remove(path)
+		}
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+	case !hasBackup || resolvConfIsOriginal(path):
+		// The original is already back, e.g. after a crash during a previous restore.
+		if err := os.Remove(backup); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to remove %s: %w", backup, err)
+		}
+	default:
+		return fmt.Errorf("refusing to restore %s: it was modified by another program", path)
+	}
+
+	if err := os.Remove(path + resolvConfMarkerSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove recovery marker: %w", err)
+	}
+
+	return syncDir(filepath.Dir(path))
+}
+
+// readResolvConfMarker returns the sums listed by the recovery marker of path, or nil when there is none.
+func readResolvConfMarker(path string) ([]string, error) {
+	data, err := os.ReadFile(path + resolvConfMarkerSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recovery marker: %w", err)
+	}
+
+	return append([]string{}, strings.Fields(string(data))...), nil
+}
+
+// backupResolvConf atomically copies the file at path next to it, keeping symbolic links as links. Nothing is saved
+// when the file does not exist, in which case restoring removes it.
+func backupResolvConf(path string) error {
+	backup := path + resolvConfBackupSuffix
+
+	info, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return fmt.Errorf("failed to read link %s: %w", path, err)
+		}
+
+		tmp := backup + ".tmp"
+		_ = os.Remove(tmp)
+		if err := os.Symlink(target, tmp); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		if err := os.Rename(tmp, backup); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+
+		return syncDir(filepath.Dir(path))
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	return writeFileAtomic(backup, data, info.Mode().Perm())
+}
+
+// resolvConfIsOriginal reports whether the file at path is the one backed up, including when both are missing or
+// dangling symbolic links.
+func resolvConfIsOriginal(path string) bool {
+	current, err := os.ReadFile(path)
+	backup, backupErr := os.ReadFile(path + resolvConfBackupSuffix)
+
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(backupErr, fs.ErrNotExist) {
+		return errors.Is(err, fs.ErrNotExist) && errors.Is(backupErr, fs.ErrNotExist)
+	}
+
+	return err == nil && backupErr == nil && bytes.Equal(current, backup)
+}
+
+// resolvConfSum returns the hexadecimal SHA-256 sum of content.
+func resolvConfSum(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic writes data to a temporary file next to path, syncs it and renames it over path.
+func writeFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	dir := filepath.Dir(path)
+
+	file, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	if err := file.Chmod(perm); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to set permissions of %s: %w", path, err)
+	}
+
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	if err := os.Rename(file.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	return syncDir(dir)
+}
+
+// syncDir flushes the directory entries of dir so that renames survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", dir, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", dir, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"strings"
+)
+
+const (
+	// DefaultServerTable is the nftables table used when ServerConfig.Table is empty.
+	DefaultServerTable = "swiftunnel-nat"
+	// serverChangeKey identifies the NAT table in the change log.
+	serverChangeKey = "server nat"
+
+	ipv4ForwardingSysctl = "/proc/sys/net/ipv4/ip_forward"
+	ipv6ForwardingSysctl = "/proc/sys/net/ipv6/conf/all/forwarding"
+)
+
+// ServerConfig describes how the clients behind a server-side interface reach other networks.
+type ServerConfig struct {
+	// Subnets are the client prefixes to translate; empty means the prefixes of the interface addresses, such as the
+	// UnicastConfig.IPNet it was created with.
+	Subnets []*net.IPNet
+	// Egress names the interface clients leave through; empty means any interface except the tunnel.
+	Egress string
+	// SNAT rewrites the sources of the subnets of its family to this address instead of masquerading behind the egress
+	// address; subnets of the other family are still masqueraded.
+	SNAT net.IP
+	// Table names the nftables table.
+	Table string
+}
+
+// EnableServer turns on IPv4 forwarding, and IPv6 forwarding when a subnet is IPv6, and installs an nftables table
+// masquerading, or with SNAT source-translating, the client subnets on their way out of the egress interface.
+// A SNAT address of a family no subnet has is rejected.
+// The previous sysctl values are restored and the table removed on DisableServer or Close.
+func (a *SwiftInterface) EnableServer(config *ServerConfig) error {
+	if config == nil {
+		return errors.New("server config cannot be nil")
+	}
+
+	subnets := config.Subnets
+	if len(subnets) == 0 {
+		var err error
+		if subnets, err = a.addressSubnets(); err != nil {
+			return err
+		}
+	}
+
+	if len(subnets) == 0 {
+		return errors.New("no client subnet to translate")
+	}
+
+	ipv4, ipv6 := false, false
+	for _, subnet := range subnets {
+		if subnet.IP.To4() == nil {
+			ipv6 = true
+		} else {
+			ipv4 = true
+		}
+	}
+
+	snat, snatFamily := config.SNAT.To4(), byte(unix.NFPROTO_IPV4)
+	if snat == nil && config.SNAT != nil {
+		if snat, snatFamily = config.SNAT.To16(), unix.NFPROTO_IPV6; snat == nil {
+			return fmt.Errorf("invalid SNAT address: %v", config.SNAT)
+		}
+	}
+
+	if snat != nil && (snatFamily == unix.NFPROTO_IPV4 && !ipv4 || snatFamily == unix.NFPROTO_IPV6 && !ipv6) {
+		return fmt.Errorf("SNAT address %v does not match the family of any client subnet", config.SNAT)
+	}
+
+	if err := a.enableForwarding(ipv4ForwardingSysctl); err != nil {
+		return err
+	}
+
+	if ipv6 {
+		if err := a.enableForwarding(ipv6ForwardingSysctl); err != nil {
+			return err
+		}
+	}
+
+	conn, err := nftConn(a.ns)
+	if err != nil {
+		return err
+	}
+
+	table := serverTable(config.Table)
+	replaceNftTable(conn, table)
+
+	postrouting := conn.AddChain(&nftables.Chain{
+		Name:     "postrouting",
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPostrouting,
+		Priority: nftables.ChainPriorityNATSource,
+	})
+
+	egress := excludeInterfaceName(expr.MetaKeyOIFNAME, a.name)
+	if config.Egress != "" {
+		egress = matchInterfaceName(expr.MetaKeyOIFNAME, config.Egress)
+	}
+
+	for _, subnet := range subnets {
+		rule := append(matchPrefix(subnet, true), egress...)
+
+		subnetFamily := byte(unix.NFPROTO_IPV6)
+		if subnet.IP.To4() != nil {
+			subnetFamily = unix.NFPROTO_IPV4
+		}
+
+		if snat != nil && subnetFamily == snatFamily {
+			rule = append(rule,
+				&expr.Immediate{Register: 1, Data: snat},
+				&expr.NAT{Type: expr.NATTypeSourceNAT, Family: uint32(snatFamily), RegAddrMin: 1},
+			)
+		} else {
+			rule = append(rule, &expr.Masq{})
+		}
+
+		conn.AddRule(&nftables.Rule{Table: table, Chain: postrouting, Exprs: rule})
+	}
+
+	if err := conn.Flush(); err != nil {
+		return fmt.Errorf("failed to install NAT rules: %w", err)
+	}
+
+	ns := a.ns
+	a.changes.forget(serverChangeKey)
+	a.changes.record(serverChangeKey, func() error {
+		return deleteNftTable(ns, table)
+	})
+
+	return nil
+}
+
+// DisableServer removes the NAT table and restores the forwarding sysctls changed by EnableServer.
+func (a *SwiftInterface) DisableServer() error {
+	return errors.Join(
+		a.changes.undo(serverChangeKey),
+		a.changes.undo(ipv6ForwardingSysctl),
+		a.changes.undo(ipv4ForwardingSysctl),
+	)
+}
+
+// enableForwarding sets a forwarding sysctl to 1, recording its previous value once.
+func (a *SwiftInterface) enableForwarding(path string) error {
+	previous, err := writeSysctl(a.ns, path, "1")
+	if err != nil {
+		return err
+	}
+
+	if previous != "1" && !a.changes.has(path) {
+		ns := a.ns
+		a.changes.record(path, func() error {
+			_, err := writeSysctl(ns, path, previous)
+			return err
+		})
+	}
+
+	return nil
+}
+
+// addressSubnets returns the prefixes of the global addresses of the interface.
+func (a *SwiftInterface) addressSubnets() ([]*net.IPNet, error) {
+	addrs, err := a.Addresses()
+	if err != nil {
+		return nil, err
+	}
+
+	var subnets []*net.IPNet
+	for _, addr := range addrs {
+		if addr.IPNet == nil || addr.IPNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+
+		subnets = append(subnets, &net.IPNet{IP: addr.IPNet.IP.Mask(addr.IPNet.Mask), Mask: addr.IPNet.Mask})
+	}
+
+	return subnets, nil
+}
+
+// writeSysctl writes value to the sysctl at path in ns and returns its previous value.
+func writeSysctl(ns netns.NsHandle, path, value string) (string, error) {
+	var previous string
+
+	write := func() error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		previous = strings.TrimSpace(string(data))
+
+		if err := os.WriteFile(path, []byte(value), 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+
+		return nil
+	}
+
+	var err error
+	if ns.IsOpen() {
+		err = inNetNS(ns, write)
+	} else {
+		err = write()
+	}
+
+	return previous, err
+}
+
+// serverTable returns the inet table called name, or DefaultServerTable when empty.
+func serverTable(name string) *nftables.Table {
+	if name == "" {
+		name = DefaultServerTable
+	}
+
+	return &nftables.Table{Name: name, Family: nftables.TableFamilyINet}
+}
@@ -0,0 +1,243 @@
+package swiftunnel
+
+import (
+	"errors"
+	"github.com/vishvananda/netlink"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+// SplitTunnel keeps the routes of a SwiftInterface in sync with lists of included and excluded prefixes.
+// Routes are installed through AddRoute, so Close on the interface removes whatever is still in place.
+type SplitTunnel struct {
+	iface  *SwiftInterface
+	mu     sync.Mutex
+	routes []netip.Prefix
+}
+
+// NewSplitTunnel returns a SplitTunnel managing the routes of iface. No route is installed until Update.
+func NewSplitTunnel(iface *SwiftInterface) *SplitTunnel {
+	return &SplitTunnel{iface: iface}
+}
+
+// Update routes the included prefixes minus the excluded ones through the interface. An empty include list with
+// excludes means everything, IPv4 and IPv6. Only routes that differ from the previous Update are added or removed;
+// new routes are added before stale ones are removed so traffic never falls back to the default route in between.
+func (s *SplitTunnel) Update(include, exclude []*net.IPNet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(include) == 0 && len(exclude) > 0 {
+		include = []*net.IPNet{
+			{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 8*net.IPv4len)},
+			{IP: net.IPv6zero, Mask: net.CIDRMask(0, 8*net.IPv6len)},
+		}
+	}
+
+	desired := splitDefaultRoutes(splitPrefixes(toPrefixes(include), toPrefixes(exclude)))
+
+	var errs []error
+
+	for _, prefix := range desired {
+		if slices.Contains(s.routes, prefix) {
+			continue
+		}
+
+		if err := s.iface.AddRoute(&netlink.Route{Dst: prefixToIPNet(prefix)}); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.routes = append(s.routes, prefix)
+	}
+
+	s.routes = slices.DeleteFunc(s.routes, func(prefix netip.Prefix) bool {
+		if slices.Contains(desired, prefix) {
+			return false
+		}
+
+		if err := s.iface.RemoveRoute(&netlink.Route{Dst: prefixToIPNet(prefix)}); err != nil {
+			errs = append(errs, err)
+			return false
+		}
+		return true
+	})
+
+	return errors.Join(errs...)
+}
+
+// Routes returns the prefixes currently routed through the interface.
+func (s *SplitTunnel) Routes() []*net.IPNet {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	routes := make([]*net.IPNet, 0, len(s.routes))
+	for _, prefix := range s.routes {
+		routes = append(routes, prefixToIPNet(prefix))
+	}
+
+	return routes
+}
+
+// Clear removes every route installed by the SplitTunnel.
+func (s *SplitTunnel) Clear() error {
+	return s.Update(nil, nil)
+}
+
+// splitPrefixes returns the smallest set of prefixes covering include but none of exclude.
+func splitPrefixes(include, exclude []netip.Prefix) []netip.Prefix {
+	var result []netip.Prefix
+
+	for _, prefix := range include {
+		remaining := []netip.Prefix{prefix}
+
+		for _, excluded := range exclude {
+			var next []netip.Prefix
+
+			for _, candidate := range remaining {
+				switch {
+				case !candidate.Overlaps(excluded):
+					next = append(next, candidate)
+				case candidate.Bits() < excluded.Bits():
+					next = append(next, subtractPrefix(candidate, excluded)...)
+				}
+			}
+
+			remaining = next
+		}
+
+		result = append(result, remaining...)
+	}
+
+	return aggregatePrefixes(result)
+}
+
+// subtractPrefix splits prefix into the prefixes covering it except excluded, which it must strictly contain.
+func subtractPrefix(prefix, excluded netip.Prefix) []netip.Prefix {
+	var result []netip.Prefix
+
+	for prefix.Bits() < excluded.Bits() {
+		low, high := splitPrefix(prefix)
+		if low.Contains(excluded.Addr()) {
+			result, prefix = append(result, high), low
+		} else {
+			result, prefix = append(result, low), high
+		}
+	}
+
+	return result
+}
+
+// splitPrefix returns the two halves of prefix.
+func splitPrefix(prefix netip.Prefix) (netip.Prefix, netip.Prefix) {
+	bits := prefix.Bits() + 1
+
+	addr := prefix.Addr().AsSlice()
+	addr[prefix.Bits()/8] |= 0x80 >> (prefix.Bits() % 8)
+	high, _ := netip.AddrFromSlice(addr)
+
+	return netip.PrefixFrom(prefix.Addr(), bits), netip.PrefixFrom(high, bits)
+}
+
+// aggregatePrefixes drops prefixes covered by others and merges adjacent halves into their parent.
+func aggregatePrefixes(prefixes []netip.Prefix) []netip.Prefix {
+	for {
+		slices.SortFunc(prefixes, func(a, b netip.Prefix) int {
+			if c := a.Addr().Compare(b.Addr()); c != 0 {
+				return c
+			}
+			return a.Bits() - b.Bits()
+		})
+
+		var result []netip.Prefix
+		merged := false
+
+		for _, prefix := range prefixes {
+			if len(result) == 0 {
+				result = append(result, prefix)
+				continue
+			}
+
+			last := result[len(result)-1]
+			if last.Overlaps(prefix) {
+				continue
+			}
+
+			if last.Bits() == prefix.Bits() && last.Bits() > 0 {
+				parent := netip.PrefixFrom(last.Addr(), last.Bits()-1).Masked()
+				if parent == netip.PrefixFrom(prefix.Addr(), prefix.Bits()-1).Masked() {
+					result[len(result)-1] = parent
+					merged = true
+					continue
+				}
+			}
+
+			result = append(result, prefix)
+		}
+
+		if !merged {
+			return result
+		}
+		prefixes = result
+	}
+}
+
+// splitDefaultRoutes replaces default prefixes by their two halves, which win over the system default route
+// without replacing it.
+func splitDefaultRoutes(prefixes []netip.Prefix) []netip.Prefix {
+	var result []netip.Prefix
+
+	for _, prefix := range prefixes {
+		if prefix.Bits() == 0 {
+			low, high := splitPrefix(prefix)
+			result = append(result, low, high)
+			continue
+		}
+		result = append(result, prefix)
+	}
+
+	return result
+}
+
+// toPrefixes converts IP networks into masked prefixes, with IPv4 kept in its 4-byte form.
+func toPrefixes(ipNets []*net.IPNet) []netip.Prefix {
+	prefixes := make([]netip.Prefix, 0, len(ipNets))
+
+	for _, ipNet := range ipNets {
+		if ipNet == nil {
+			continue
+		}
+
+		addr, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+
+		ones, bits := ipNet.Mask.Size()
+		if bits == 0 {
+			continue
+		}
+
+		if addr.Is4In6() {
+			addr = addr.Unmap()
+			if bits == 8*net.IPv6len {
+				ones -= 8 * (net.IPv6len - net.IPv4len)
+			}
+		}
+
+		if prefix, err := addr.Prefix(ones); err == nil {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+
+	return prefixes
+}
+
+// prefixToIPNet converts a prefix into an IP network.
+func prefixToIPNet(prefix netip.Prefix) *net.IPNet {
+	return &net.IPNet{
+		IP:   prefix.Addr().AsSlice(),
+		Mask: net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen()),
+	}
+}
@@ -0,0 +1,77 @@
+package swiftunnel
+
+import (
+	"net"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestSplitPrefixes(t *testing.T) {
+	parse := func(cidrs ...string) []netip.Prefix {
+		var prefixes []netip.Prefix
+		for _, cidr := range cidrs {
+			prefixes = append(prefixes, netip.MustParsePrefix(cidr))
+		}
+		return prefixes
+	}
+
+	for _, tc := range []struct {
+		name             string
+		include, exclude []netip.Prefix
+		expected         []netip.Prefix
+	}{
+		{
+			name:     "IncludeOnly",
+			include:  parse("10.0.0.0/8", "10.1.0.0/16", "192.168.0.0/24", "192.168.1.0/24"),
+			expected: parse("10.0.0.0/8", "192.168.0.0/23"),
+		},
+		{
+			name:     "ExcludeInside",
+			include:  parse("10.0.0.0/8"),
+			exclude:  parse("10.128.0.0/9", "10.64.0.0/10"),
+			expected: parse("10.0.0.0/10"),
+		},
+		{
+			name:     "ExcludeCovering",
+			include:  parse("10.1.0.0/16", "172.16.0.0/12"),
+			exclude:  parse("10.0.0.0/8"),
+			expected: parse("172.16.0.0/12"),
+		},
+		{
+			name:     "EverythingExceptPrivate",
+			include:  parse("0.0.0.0/0"),
+			exclude:  parse("10.0.0.0/8", "128.0.0.0/1"),
+			expected: parse("0.0.0.0/5", "8.0.0.0/7", "11.0.0.0/8", "12.0.0.0/6", "16.0.0.0/4", "32.0.0.0/3", "64.0.0.0/2"),
+		},
+		{
+			name:     "IPv6",
+			include:  parse("::/0", "10.0.0.0/8"),
+			exclude:  parse("8000::/1", "4000::/2"),
+			expected: parse("10.0.0.0/8", "::/2"),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := splitPrefixes(tc.include, tc.exclude); !slices.Equal(got, tc.expected) {
+				t.Fatalf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestToPrefixes(t *testing.T) {
+	_, v4, _ := net.ParseCIDR("10.1.2.3/16")
+	mapped := &net.IPNet{IP: net.ParseIP("192.168.1.1"), Mask: net.CIDRMask(120, 128)}
+
+	got := toPrefixes([]*net.IPNet{v4, mapped, nil})
+	expected := []netip.Prefix{netip.MustParsePrefix("10.1.0.0/16"), netip.MustParsePrefix("192.168.1.0/24")}
+
+	if !slices.Equal(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	if defaults := splitDefaultRoutes([]netip.Prefix{netip.MustParsePrefix("::/0")}); len(defaults) != 2 ||
+		defaults[0].String() != "::/1" || defaults[1].String() != "8000::/1" {
+		t.Fatalf("expected ::/0 to be split into halves, got %v", defaults)
+	}
+}
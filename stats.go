@@ -0,0 +1,158 @@
+package swiftunnel
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Statistics is a snapshot of the traffic moved by a SwiftInterface.
+type Statistics struct {
+	// Time is when the snapshot was taken.
+	Time time.Time
+	// Link holds the counters reported by the operating system for the interface.
+	Link LinkStatistics
+	// Library holds the counters maintained by Read, Write and their batch variants.
+	Library IOStatistics
+}
+
+// LinkStatistics contains the kernel counters of a network interface.
+type LinkStatistics struct {
+	RxPackets uint64
+	TxPackets uint64
+	RxBytes   uint64
+	TxBytes   uint64
+	RxErrors  uint64
+	TxErrors  uint64
+	RxDropped uint64
+	TxDropped uint64
+}
+
+// IOStatistics contains the packets and bytes moved through the library, as seen by its callers.
+type IOStatistics struct {
+	ReadPackets       uint64
+	ReadBytes         uint64
+	WritePackets      uint64
+	WriteBytes        uint64
+	ShortBufferErrors uint64
+}
+
+// Rates holds per-second throughput derived from two Statistics snapshots.
+type Rates struct {
+	Interval time.Duration
+
+	RxPacketsPerSecond float64
+	RxBytesPerSecond   float64
+	TxPacketsPerSecond float64
+	TxBytesPerSecond   float64
+
+	ReadPacketsPerSecond  float64
+	ReadBytesPerSecond    float64
+	WritePacketsPerSecond float64
+	WriteBytesPerSecond   float64
+}
+
+// RatesSince computes the throughput between an earlier snapshot and s.
+// Counters that went backwards, e.g. after the interface was recreated, yield a zero rate.
+func (s Statistics) RatesSince(prev Statistics) Rates {
+	interval := s.Time.Sub(prev.Time)
+	rates := Rates{Interval: interval}
+
+	if interval <= 0 {
+		return rates
+	}
+
+	seconds := interval.Seconds()
+	rate := func(cur, old uint64) float64 {
+		if cur < old {
+			return 0
+		}
+		return float64(cur-old) / seconds
+	}
+
+	rates.RxPacketsPerSecond = rate(s.Link.RxPackets, prev.Link.RxPackets)
+	rates.RxBytesPerSecond = rate(s.Link.RxBytes, prev.Link.RxBytes)
+	rates.TxPacketsPerSecond = rate(s.Link.TxPackets, prev.Link.TxPackets)
+	rates.TxBytesPerSecond = rate(s.Link.TxBytes, prev.Link.TxBytes)
+
+	rates.ReadPacketsPerSecond = rate(s.Library.ReadPackets, prev.Library.ReadPackets)
+	rates.ReadBytesPerSecond = rate(s.Library.ReadBytes, prev.Library.ReadBytes)
+	rates.WritePacketsPerSecond = rate(s.Library.WritePackets, prev.Library.WritePackets)
+	rates.WriteBytesPerSecond = rate(s.Library.WriteBytes, prev.Library.WriteBytes)
+
+	return rates
+}
+
+// ioCounters accumulates IOStatistics from concurrent readers and writers.
+type ioCounters struct {
+	readPackets  atomic.Uint64
+	readBytes    atomic.Uint64
+	writePackets atomic.Uint64
+	writeBytes   atomic.Uint64
+	shortBuffer  atomic.Uint64
+}
+
+// countRead records the outcome of a single packet read.
+func (c *ioCounters) countRead(n int, err error) {
+	if n > 0 {
+		c.readPackets.Add(1)
+		c.readBytes.Add(uint64(n))
+	}
+	c.countError(err)
+}
+
+// countReadBatch records the outcome of a batched read of n packets.
+func (c *ioCounters) countReadBatch(n int, sizes []int, err error) {
+	if n > 0 {
+		var bytes uint64
+		for _, size := range sizes[:n] {
+			bytes += uint64(size)
+		}
+
+		c.readPackets.Add(uint64(n))
+		c.readBytes.Add(bytes)
+	}
+	c.countError(err)
+}
+
+// countWrite records the outcome of a single packet write.
+func (c *ioCounters) countWrite(n int, err error) {
+	if n > 0 {
+		c.writePackets.Add(1)
+		c.writeBytes.Add(uint64(n))
+	}
+	c.countError(err)
+}
+
+// countWriteBatch records the outcome of a batched write that transmitted the first n packets of bufs.
+func (c *ioCounters) countWriteBatch(n int, bufs [][]byte, err error) {
+	if n > 0 {
+		var bytes uint64
+		for _, buf := range bufs[:n] {
+			bytes += uint64(len(buf))
+		}
+
+		c.writePackets.Add(uint64(n))
+		c.writeBytes.Add(bytes)
+	}
+	c.countError(err)
+}
+
+// countError records errors caused by a caller buffer that was too small for a packet.
+func (c *ioCounters) countError(err error) {
+	if errors.Is(err, io.ErrShortBuffer) {
+		c.shortBuffer.Add(1)
+	}
+}
+
+// snapshot returns the current counter values.
+func (c *ioCounters) snapshot() IOStatistics {
+	return IOStatistics{
+		ReadPackets:       c.readPackets.Load(),
+		ReadBytes:         c.readBytes.Load(),
+		WritePackets:      c.writePackets.Load(),
+		WriteBytes:        c.writeBytes.Load(),
+		ShortBufferErrors: c.shortBuffer.Load(),
+	}
+}
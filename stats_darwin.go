@@ -0,0 +1,15 @@
+//go:build darwin
+
+package swiftunnel
+
+import (
+	"time"
+)
+
+// Statistics returns the library counters of the interface; kernel link counters are not collected on macOS.
+func (a *SwiftInterface) Statistics() (Statistics, error) {
+	return Statistics{
+		Time:    time.Now(),
+		Library: a.tunReadCloser.counters.snapshot(),
+	}, nil
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"fmt"
+	"time"
+)
+
+// Statistics returns the kernel link counters of the interface together with the counters of every queue.
+// The kernel transmits the packets returned by Read and receives the packets passed to Write.
+func (a *SwiftInterface) Statistics() (Statistics, error) {
+	link, err := a.handle().LinkByName(a.name)
+	if err != nil {
+		return Statistics{}, fmt.Errorf("failed to get link: %w", err)
+	}
+
+	stats := Statistics{
+		Time:    time.Now(),
+		Library: a.counters.snapshot(),
+	}
+
+	if ls := link.Attrs().Statistics; ls != nil {
+		stats.Link = LinkStatistics{
+			RxPackets: ls.RxPackets,
+			TxPackets: ls.TxPackets,
+			RxBytes:   ls.RxBytes,
+			TxBytes:   ls.TxBytes,
+			RxErrors:  ls.RxErrors,
+			TxErrors:  ls.TxErrors,
+			RxDropped: ls.RxDropped,
+			TxDropped: ls.TxDropped,
+		}
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,67 @@
+package swiftunnel
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRatesSince(t *testing.T) {
+	start := time.Now()
+
+	prev := Statistics{
+		Time:    start,
+		Link:    LinkStatistics{RxPackets: 100, RxBytes: 10000, TxPackets: 50, TxBytes: 5000},
+		Library: IOStatistics{ReadPackets: 50, ReadBytes: 5000},
+	}
+	cur := Statistics{
+		Time:    start.Add(2 * time.Second),
+		Link:    LinkStatistics{RxPackets: 300, RxBytes: 30000, TxPackets: 10, TxBytes: 1000},
+		Library: IOStatistics{ReadPackets: 70, ReadBytes: 7000},
+	}
+
+	rates := cur.RatesSince(prev)
+
+	if rates.Interval != 2*time.Second {
+		t.Fatalf("expected interval of 2s, got %v", rates.Interval)
+	}
+
+	if rates.RxPacketsPerSecond != 100 || rates.RxBytesPerSecond != 10000 {
+		t.Fatalf("unexpected receive rates %+v", rates)
+	}
+
+	if rates.TxPacketsPerSecond != 0 || rates.TxBytesPerSecond != 0 {
+		t.Fatalf("expected zero rates for counters that went backwards, got %+v", rates)
+	}
+
+	if rates.ReadPacketsPerSecond != 10 || rates.ReadBytesPerSecond != 1000 {
+		t.Fatalf("unexpected read rates %+v", rates)
+	}
+
+	if zero := prev.RatesSince(cur); zero.RxPacketsPerSecond != 0 {
+		t.Fatalf("expected zero rates for a negative interval, got %+v", zero)
+	}
+}
+
+func TestIOCounters(t *testing.T) {
+	var c ioCounters
+
+	c.countRead(100, nil)
+	c.countRead(0, fmt.Errorf("read: %w", io.ErrShortBuffer))
+	c.countReadBatch(2, []int{10, 20, 30}, nil)
+	c.countWrite(40, nil)
+	c.countWriteBatch(1, [][]byte{make([]byte, 60), make([]byte, 70)}, io.ErrShortWrite)
+
+	want := IOStatistics{
+		ReadPackets:       3,
+		ReadBytes:         130,
+		WritePackets:      2,
+		WriteBytes:        100,
+		ShortBufferErrors: 1,
+	}
+
+	if got := c.snapshot(); got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build windows
+
+package swiftunnel
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/sys/windows"
+	"time"
+	"unsafe"
+)
+
+// Statistics returns the interface counters reported by GetIfEntry together with the library counters.
+// The 32-bit GetIfEntry counters wrap around on busy links; RatesSince treats a wrap as a zero rate.
+func (a *SwiftInterface) Statistics() (Statistics, error) {
+	adapterIndex, err := a.GetAdapterIndex()
+	if err != nil {
+		return Statistics{}, err
+	}
+
+	var ifRow windows.MibIfRow
+	ifRow.Index = uint32(adapterIndex)
+
+	ret, _, _ := procGetIfEntry.Call(uintptr(unsafe.Pointer(&ifRow)))
+	if err := windows.Errno(ret); !errors.Is(err, windows.ERROR_SUCCESS) {
+		return Statistics{}, fmt.Errorf("failed to retrieve interface entry: %w", err)
+	}
+
+	return Statistics{
+		Time: time.Now(),
+		Link: LinkStatistics{
+			RxPackets: uint64(ifRow.InUcastPkts) + uint64(ifRow.InNUcastPkts),
+			TxPackets: uint64(ifRow.OutUcastPkts) + uint64(ifRow.OutNUcastPkts),
+			RxBytes:   uint64(ifRow.InOctets),
+			TxBytes:   uint64(ifRow.OutOctets),
+			RxErrors:  uint64(ifRow.InErrors),
+			TxErrors:  uint64(ifRow.OutErrors),
+			RxDropped: uint64(ifRow.InDiscards),
+			TxDropped: uint64(ifRow.OutDiscards),
+		},
+		Library: a.counters.snapshot(),
+	}, nil
+}
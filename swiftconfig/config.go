@@ -0,0 +1,165 @@
+package swiftconfig
+
+import (
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"net"
+)
+
+// maxQueues mirrors the kernel's MAX_TAP_QUEUES limit.
+const maxQueues = 256
+
+// DriverType selects the tunnel implementation on platforms offering several.
+type DriverType int
+
+const (
+	// DriverTypeDefault is Wintun on Windows, TunTapOSX on macOS and the kernel TUN/TAP driver on Linux.
+	DriverTypeDefault DriverType = iota
+	// DriverTypeWintun is the Wintun driver on Windows.
+	DriverTypeWintun
+	// DriverTypeOpenVPN is the TAP-Windows driver shipped with OpenVPN.
+	DriverTypeOpenVPN
+	// DriverTypeTunTapOSX is the TunTapOSX kext on macOS.
+	DriverTypeTunTapOSX
+	// DriverTypeSystem is the utun interface built into macOS.
+	DriverTypeSystem
+)
+
+// String returns the name of the driver type.
+func (d DriverType) String() string {
+	switch d {
+	case DriverTypeDefault:
+		return "default"
+	case DriverTypeWintun:
+		return "wintun"
+	case DriverTypeOpenVPN:
+		return "openvpn"
+	case DriverTypeTunTapOSX:
+		return "tuntaposx"
+	case DriverTypeSystem:
+		return "system"
+	}
+
+	return fmt.Sprintf("DriverType(%d)", int(d))
+}
+
+// Permissions defines user and group ownership for the Linux tunnel device.
+type Permissions struct {
+	Owner uint
+	Group uint
+}
+
+// NewPermissions creates a new ownership configuration.
+func NewPermissions(owner, group uint) *Permissions {
+	return &Permissions{owner, group}
+}
+
+// NetNS references the network namespace a Linux device lives in, either by name or by file descriptor.
+// When Move is set the device is created in the caller's namespace and moved into the target afterwards.
+type NetNS struct {
+	Name string
+	FD   int
+	Move bool
+}
+
+// NewNetNSByName references a named namespace under /var/run/netns.
+func NewNetNSByName(name string) *NetNS {
+	return &NetNS{Name: name, FD: -1}
+}
+
+// NewNetNSByFD references a namespace by an open descriptor, such as one of /proc/<pid>/ns/net.
+func NewNetNSByFD(fd int) *NetNS {
+	return &NetNS{FD: fd}
+}
+
+// Config holds configuration parameters for a tunnel interface. The common fields apply on every platform; the
+// platform extensions are ignored elsewhere, except those changing what Read and Write carry, where the interface
+// lives or which driver opens it, which Validate rejects on the wrong platform.
+type Config struct {
+	AdapterName string
+	AdapterType swiftypes.AdapterType
+
+	MTU           int
+	UnicastConfig *swiftypes.UnicastConfig
+	// Addresses are assigned in addition to UnicastConfig.
+	Addresses []*swiftypes.Address
+	// Routes are destinations routed through the interface, which is brought up to install them.
+	Routes []*net.IPNet
+	// DNSConfig is applied with SetDNS once the interface is configured. It is not supported on macOS, where Validate
+	// rejects it.
+	DNSConfig *swiftypes.DNSConfig
+
+	// DriverType selects the Windows or macOS driver.
+	DriverType DriverType
+
+	// Linux extensions.
+	MultiQueue  bool
+	Queues      int
+	Permissions *Permissions
+	Persist     bool
+	VnetHdr     bool
+	RawVnetHdr  bool
+
+	DeleteOnClose bool
+	NetNS         *NetNS
+
+	// ResolvedBus is the D-Bus address used to reach systemd-resolved; empty means the system bus.
+	ResolvedBus string
+	// ResolvConfPath is the resolver file rewritten by SetDNS instead of using systemd-resolved or resolvconf;
+	// empty means /etc/resolv.conf, used only when systemd-resolved is not running. An interface in a named NetNS
+	// always rewrites /etc/netns/<name>/resolv.conf by default; one in a namespace referenced by FD needs a path.
+	ResolvConfPath string
+
+	// Windows extensions.
+	AdapterTypeName string
+	AdapterGUID     swiftypes.GUID
+	RingBuffer      uint32
+}
+
+// New initializes a Config struct with the defaults of the current platform and functional options.
+func New(opts ...Option) (*Config, error) {
+	cfg := &Config{
+		AdapterName: "Swiftunnel VPN",
+		AdapterType: swiftypes.AdapterTypeTUN,
+		MTU:         1500,
+	}
+	cfg.setPlatformDefaults()
+
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.UnicastConfig == nil && len(cfg.Addresses) == 0 {
+		return nil, errors.New("unicast configuration (IP/Net) or an address is required")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// Validate checks the configuration against the current platform.
+func (c *Config) Validate() error {
+	if c == nil {
+		return errors.New("config cannot be nil")
+	}
+
+	for _, addr := range c.Addresses {
+		if addr == nil || addr.IPNet == nil {
+			return errors.New("address cannot be nil")
+		}
+	}
+
+	for _, route := range c.Routes {
+		if route == nil {
+			return errors.New("route cannot be nil")
+		}
+	}
+
+	return c.validatePlatform()
+}
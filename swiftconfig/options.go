@@ -1,4 +1,255 @@
 package swiftconfig
 
+import (
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"net"
+)
+
 // Option defines a functional configuration option for Config.
 type Option func(*Config) error
+
+// WithAdapterName sets the interface name, or the friendly name of the Windows adapter.
+func WithAdapterName(name string) Option {
+	return func(c *Config) error {
+		if name == "" {
+			return errors.New("AdapterName cannot be empty")
+		}
+
+		c.AdapterName = name
+
+		return nil
+	}
+}
+
+// WithAdapterType specifies TUN or TAP.
+func WithAdapterType(adapterType swiftypes.AdapterType) Option {
+	return func(c *Config) error {
+		c.AdapterType = adapterType
+		return nil
+	}
+}
+
+// WithMTU sets the interface MTU.
+func WithMTU(mtu int) Option {
+	return func(c *Config) error {
+		if mtu < 576 || mtu > 65535 {
+			return errors.New("MTU must be between 576 and 65535")
+		}
+
+		c.MTU = mtu
+		return nil
+	}
+}
+
+// WithUnicastIP parses and sets the primary IP/Subnet.
+func WithUnicastIP(ipStr string) Option {
+	return func(c *Config) error {
+		ip, ipNet, err := net.ParseCIDR(ipStr)
+		if err != nil {
+			return err
+		}
+
+		c.UnicastConfig = newUnicastConfig(ip, ipNet)
+		return nil
+	}
+}
+
+// WithUnicastConfig sets the primary IP/Subnet.
+func WithUnicastConfig(unicast *swiftypes.UnicastConfig) Option {
+	return func(c *Config) error {
+		c.UnicastConfig = unicast
+		return nil
+	}
+}
+
+// WithAddresses parses CIDR strings into addresses assigned besides the unicast configuration.
+func WithAddresses(cidrs ...string) Option {
+	return func(c *Config) error {
+		for _, cidr := range cidrs {
+			addr, err := swiftypes.ParseAddress(cidr)
+			if err != nil {
+				return fmt.Errorf("invalid address %q: %w", cidr, err)
+			}
+
+			c.Addresses = append(c.Addresses, addr)
+		}
+
+		return nil
+	}
+}
+
+// WithRoutes parses CIDR strings into destinations routed through the interface.
+func WithRoutes(cidrs ...string) Option {
+	return func(c *Config) error {
+		for _, cidr := range cidrs {
+			_, route, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("invalid route %q: %w", cidr, err)
+			}
+
+			c.Routes = append(c.Routes, route)
+		}
+
+		return nil
+	}
+}
+
+// WithDNSConfig applies DNS settings to the adapter.
+func WithDNSConfig(dnsConfig *swiftypes.DNSConfig) Option {
+	return func(c *Config) error {
+		c.DNSConfig = dnsConfig
+		return nil
+	}
+}
+
+// WithDriverType selects the Windows or macOS driver.
+func WithDriverType(driverType DriverType) Option {
+	return func(c *Config) error {
+		c.DriverType = driverType
+		return nil
+	}
+}
+
+// WithMultiQueue toggles support for multiple packet queues on Linux.
+func WithMultiQueue(multiqueue bool) Option {
+	return func(c *Config) error {
+		c.MultiQueue = multiqueue
+		return nil
+	}
+}
+
+// WithQueues opens the given number of packet queues on Linux, enabling multi-queue support when more than one is
+// requested.
+func WithQueues(queues int) Option {
+	return func(c *Config) error {
+		if queues < 1 || queues > maxQueues {
+			return fmt.Errorf("queues must be between 1 and %d", maxQueues)
+		}
+
+		c.Queues = queues
+		if queues > 1 {
+			c.MultiQueue = true
+		}
+
+		return nil
+	}
+}
+
+// WithVnetHdr enables virtio-net headers with checksum and segmentation offloads on Linux.
+func WithVnetHdr(vnetHdr bool) Option {
+	return func(c *Config) error {
+		c.VnetHdr = vnetHdr
+		return nil
+	}
+}
+
+// WithRawVnetHdr exposes super-packets and their virtio-net headers to callers unmodified on Linux.
+func WithRawVnetHdr(raw bool) Option {
+	return func(c *Config) error {
+		c.RawVnetHdr = raw
+		if raw {
+			c.VnetHdr = true
+		}
+		return nil
+	}
+}
+
+// WithPersist sets whether the Linux interface remains after the application exits.
+func WithPersist(persist bool) Option {
+	return func(c *Config) error {
+		c.Persist = persist
+		return nil
+	}
+}
+
+// WithDeleteOnClose removes a persistent interface when the SwiftInterface is closed, so only crashes leave it behind.
+func WithDeleteOnClose(deleteOnClose bool) Option {
+	return func(c *Config) error {
+		c.DeleteOnClose = deleteOnClose
+		return nil
+	}
+}
+
+// WithNetNS creates and manages the interface inside the given Linux network namespace.
+func WithNetNS(ns *NetNS) Option {
+	return func(c *Config) error {
+		if ns != nil && ns.Name == "" && ns.FD < 0 {
+			return errors.New("network namespace requires a name or a file descriptor")
+		}
+
+		c.NetNS = ns
+		return nil
+	}
+}
+
+// WithResolvedBus reaches systemd-resolved on the D-Bus at address instead of the system bus.
+func WithResolvedBus(address string) Option {
+	return func(c *Config) error {
+		c.ResolvedBus = address
+		return nil
+	}
+}
+
+// WithResolvConfPath makes SetDNS rewrite the resolver file at path, e.g. in a container or a test.
+func WithResolvConfPath(path string) Option {
+	return func(c *Config) error {
+		c.ResolvConfPath = path
+		return nil
+	}
+}
+
+// WithPermissions sets the UID and GID of the Linux device.
+func WithPermissions(permissions *Permissions) Option {
+	return func(c *Config) error {
+		c.Permissions = permissions
+		return nil
+	}
+}
+
+// WithAdapterTypeName sets the Windows adapter class name (e.g., "Wintun").
+func WithAdapterTypeName(name string) Option {
+	return func(c *Config) error {
+		if name == "" {
+			return errors.New("adapter type name cannot be empty")
+		}
+
+		c.AdapterTypeName = name
+		return nil
+	}
+}
+
+// WithRingBuffer sets the Wintun ring buffer capacity.
+func WithRingBuffer(ringBuffer uint32) Option {
+	return func(c *Config) error {
+		if ringBuffer < 1024 {
+			return errors.New("ring buffer must be at least 1024 bytes")
+		}
+
+		c.RingBuffer = ringBuffer
+		return nil
+	}
+}
+
+// WithGUIDStr assigns a specific GUID to the Windows adapter.
+func WithGUIDStr(guidStr string) Option {
+	return func(c *Config) error {
+		g, err := swiftypes.ParseGUID(guidStr)
+		if err != nil {
+			return err
+		}
+
+		c.AdapterGUID = g
+
+		return nil
+	}
+}
+
+// WithGUID assigns a specific GUID to the Windows adapter.
+func WithGUID(guid swiftypes.GUID) Option {
+	return func(c *Config) error {
+		c.AdapterGUID = guid
+		return nil
+	}
+}
@@ -0,0 +1,169 @@
+package swiftdns
+
+import (
+	"golang.org/x/net/dns/dnsmessage"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheKey identifies a question in the cache; names are compared case-insensitively.
+type cacheKey struct {
+	name  string
+	qtype dnsmessage.Type
+	class dnsmessage.Class
+}
+
+// cacheEntry is a response stored until its smallest TTL expires.
+type cacheEntry struct {
+	msg     dnsmessage.Message
+	stored  time.Time
+	expires time.Time
+}
+
+// cache is a bounded in-memory store of responses honouring their TTLs.
+type cache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[cacheKey]*cacheEntry
+	now     func() time.Time
+}
+
+// newCache returns a cache holding at most size responses.
+func newCache(size int) *cache {
+	return &cache{size: size, entries: make(map[cacheKey]*cacheEntry), now: time.Now}
+}
+
+// newCacheKey returns the cache key of question.
+func newCacheKey(question dnsmessage.Question) cacheKey {
+	return cacheKey{name: strings.ToLower(question.Name.String()), qtype: question.Type, class: question.Class}
+}
+
+// get returns a copy of the cached response to question with its TTLs reduced by the time spent in the cache.
+func (c *cache) get(question dnsmessage.Question) (*dnsmessage.Message, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := newCacheKey(question)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	now := c.now()
+	if !now.Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+
+	elapsed := uint32(now.Sub(entry.stored) / time.Second)
+
+	msg := entry.msg
+	msg.Answers = agedResources(msg.Answers, elapsed)
+	msg.Authorities = agedResources(msg.Authorities, elapsed)
+	msg.Additionals = agedResources(msg.Additionals, elapsed)
+
+	return &msg, true
+}
+
+// put stores msg as the response to question for its TTL. Errors other than NXDOMAIN, truncated responses and
+// responses without a TTL are not cached.
+func (c *cache) put(question dnsmessage.Question, msg *dnsmessage.Message) {
+	if c.size <= 0 || msg.Truncated {
+		return
+	}
+
+	if msg.RCode != dnsmessage.RCodeSuccess && msg.RCode != dnsmessage.RCodeNameError {
+		return
+	}
+
+	ttl, ok := responseTTL(msg)
+	if !ok || ttl == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	key := newCacheKey(question)
+
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.size {
+		c.evict(now)
+	}
+
+	c.entries[key] = &cacheEntry{
+		msg:     *msg,
+		stored:  now,
+		expires: now.Add(time.Duration(ttl) * time.Second),
+	}
+}
+
+// evict drops expired entries, or the entry closest to expiry when none has expired.
+func (c *cache) evict(now time.Time) {
+	var oldest cacheKey
+	var oldestExpiry time.Time
+
+	for key, entry := range c.entries {
+		if !now.Before(entry.expires) {
+			delete(c.entries, key)
+			continue
+		}
+
+		if oldestExpiry.IsZero() || entry.expires.Before(oldestExpiry) {
+			oldest, oldestExpiry = key, entry.expires
+		}
+	}
+
+	if len(c.entries) >= c.size {
+		delete(c.entries, oldest)
+	}
+}
+
+// responseTTL returns the smallest TTL of the answers, or for negative responses the SOA minimum bounded by its TTL.
+func responseTTL(msg *dnsmessage.Message) (uint32, bool) {
+	var ttl uint32
+	found := false
+
+	for _, answer := range msg.Answers {
+		if !found || answer.Header.TTL < ttl {
+			ttl, found = answer.Header.TTL, true
+		}
+	}
+
+	if found {
+		return ttl, true
+	}
+
+	for _, authority := range msg.Authorities {
+		soa, ok := authority.Body.(*dnsmessage.SOAResource)
+		if !ok {
+			continue
+		}
+
+		return min(authority.Header.TTL, soa.MinTTL), true
+	}
+
+	return 0, false
+}
+
+// agedResources returns a copy of resources with elapsed seconds taken off their TTLs. EDNS OPT records, whose TTL
+// field carries flags, are left untouched.
+func agedResources(resources []dnsmessage.Resource, elapsed uint32) []dnsmessage.Resource {
+	if len(resources) == 0 {
+		return resources
+	}
+
+	aged := make([]dnsmessage.Resource, len(resources))
+	copy(aged, resources)
+
+	for i := range aged {
+		if aged[i].Header.Type == dnsmessage.TypeOPT {
+			continue
+		}
+
+		aged[i].Header.TTL -= min(aged[i].Header.TTL, elapsed)
+	}
+
+	return aged
+}
@@ -0,0 +1,514 @@
+// Package swiftdns implements a caching DNS forwarder meant to listen on a tunnel address, so that the queries of the
+// system resolver are answered through the tunnel instead of leaking to the local network.
+package swiftdns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPort is the port the forwarder listens on, and queries upstreams on when they name none.
+	DefaultPort = 53
+	// DefaultCacheSize is the number of responses cached when Config.CacheSize is zero.
+	DefaultCacheSize = 1024
+	// DefaultTimeout bounds an exchange with an upstream when Config.Timeout is zero.
+	DefaultTimeout = 5 * time.Second
+	// DefaultMaxQueries is the number of UDP queries, and of TCP clients, served at once when Config.MaxQueries is zero.
+	DefaultMaxQueries = 256
+
+	// minUDPSize is the largest UDP response accepted by clients that do not advertise a size through EDNS.
+	minUDPSize = 512
+	// maxMessageSize is the largest DNS message carried over TCP.
+	maxMessageSize = 65535
+	// tcpIdleTimeout closes TCP clients that send no query for this long.
+	tcpIdleTimeout = 10 * time.Second
+)
+
+// Config describes where a Forwarder listens and where it forwards queries.
+type Config struct {
+	// Listen is the address the forwarder answers on, typically the UnicastConfig.IP of the tunnel. A nil Listen
+	// creates a forwarder only answering the queries passed to Resolve.
+	Listen net.IP
+	// Port is the UDP and TCP port to listen on; zero means DefaultPort.
+	Port int
+	// Upstreams are the servers queries are forwarded to in plaintext, in order of preference, as IP addresses with an
+	// optional port, e.g. "10.8.0.1" or "[2001:db8::1]:5353".
+	Upstreams []string
+	// Encrypted are DNS-over-TLS and DNS-over-HTTPS servers, tried after Upstreams. Leave Upstreams empty for queries
+	// to only leave the host encrypted.
+	Encrypted []swiftypes.DNSUpstream
+	// Dialer opens the upstream connections. Binding it to the tunnel keeps queries from leaking.
+	Dialer *net.Dialer
+	// Timeout bounds each upstream exchange; zero means DefaultTimeout.
+	Timeout time.Duration
+	// CacheSize is the number of responses cached; zero means DefaultCacheSize and a negative value disables caching.
+	CacheSize int
+	// MaxQueries bounds the UDP queries and, separately, the TCP clients served at once; zero means DefaultMaxQueries.
+	// UDP queries over the limit get a SERVFAIL response and TCP clients over it are disconnected.
+	MaxQueries int
+	// OnResponse is called with every successful response, cached or not, before it is returned to the client, e.g.
+	// to route the answered addresses before the client connects to them.
+	OnResponse func(response []byte)
+}
+
+// Forwarder answers DNS queries over UDP and TCP from its cache or by forwarding them to upstream servers.
+type Forwarder struct {
+	upstreams  []upstream
+	timeout    time.Duration
+	cache      *cache
+	onResponse func(response []byte)
+
+	udp net.PacketConn
+	tcp net.Listener
+
+	// queries and clients hold a slot per UDP query and TCP client being served.
+	queries chan struct{}
+	clients chan struct{}
+
+	ctx       context.Context
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewForwarder listens on the configured address over UDP and TCP and starts answering queries until Close.
+func NewForwarder(config *Config) (*Forwarder, error) {
+	if config == nil {
+		return nil, errors.New("forwarder config cannot be nil")
+	}
+
+	if len(config.Upstreams) == 0 && len(config.Encrypted) == 0 {
+		return nil, errors.New("forwarder needs at least one upstream")
+	}
+
+	dialer := config.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+
+	f := &Forwarder{
+		timeout:    config.Timeout,
+		cache:      newCache(config.CacheSize),
+		onResponse: config.OnResponse,
+	}
+
+	for _, upstream := range config.Upstreams {
+		address, err := parseUpstream(upstream)
+		if err != nil {
+			return nil, err
+		}
+		f.upstreams = append(f.upstreams, &plainUpstream{address: address, dialer: dialer})
+	}
+
+	for _, config := range config.Encrypted {
+		upstream, err := newEncryptedUpstream(config, dialer)
+		if err != nil {
+			return nil, err
+		}
+		f.upstreams = append(f.upstreams, upstream)
+	}
+
+	if f.timeout <= 0 {
+		f.timeout = DefaultTimeout
+	}
+
+	if config.CacheSize == 0 {
+		f.cache.size = DefaultCacheSize
+	}
+
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+
+	if config.Listen == nil {
+		return f, nil
+	}
+
+	port := config.Port
+	if port == 0 {
+		port = DefaultPort
+	}
+
+	maxQueries := config.MaxQueries
+	if maxQueries <= 0 {
+		maxQueries = DefaultMaxQueries
+	}
+	f.queries = make(chan struct{}, maxQueries)
+	f.clients = make(chan struct{}, maxQueries)
+
+	address := net.JoinHostPort(config.Listen.String(), strconv.Itoa(port))
+
+	var err error
+	if f.udp, err = net.ListenPacket("udp", address); err != nil {
+		f.cancel()
+		return nil, fmt.Errorf("failed to listen on udp %s: %w", address, err)
+	}
+
+	if f.tcp, err = net.Listen("tcp", address); err != nil {
+		f.cancel()
+		_ = f.udp.Close()
+		return nil, fmt.Errorf("failed to listen on tcp %s: %w", address, err)
+	}
+
+	f.wg.Add(2)
+	go f.serveUDP()
+	go f.serveTCP()
+
+	return f, nil
+}
+
+// Addr returns the UDP address the forwarder listens on, which TCP shares, or nil when it does not listen.
+func (f *Forwarder) Addr() net.Addr {
+	if f.udp == nil {
+		return nil
+	}
+
+	return f.udp.LocalAddr()
+}
+
+// Close stops listening, aborts pending upstream exchanges and waits for them to return.
+func (f *Forwarder) Close() error {
+	f.closeOnce.Do(func() {
+		f.cancel()
+		if f.udp != nil {
+			f.closeErr = errors.Join(f.udp.Close(), f.tcp.Close())
+		}
+		f.wg.Wait()
+
+		for _, upstream := range f.upstreams {
+			upstream.close()
+		}
+	})
+
+	return f.closeErr
+}
+
+// Resolve answers the wire-format query from the cache or the upstreams; plaintext ones are asked over UDP first and
+// again over TCP when the response is truncated. A SERVFAIL response is returned when no upstream answers; an error only
+// when the query cannot be parsed.
+func (f *Forwarder) Resolve(ctx context.Context, query []byte) ([]byte, error) {
+	return f.resolve(ctx, query, false)
+}
+
+// resolve answers query, forwarding it over TCP only when tcp is set.
+func (f *Forwarder) resolve(ctx context.Context, query []byte, tcp bool) ([]byte, error) {
+	var parser dnsmessage.Parser
+
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse query: %w", err)
+	}
+
+	if header.Response {
+		return nil, errors.New("message is not a query")
+	}
+
+	question, err := parser.Question()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse question: %w", err)
+	}
+
+	if header.OpCode == 0 {
+		if msg, ok := f.cache.get(question); ok {
+			msg.ID = header.ID
+			msg.RecursionDesired = header.RecursionDesired
+
+			response, err := msg.Pack()
+			if err != nil {
+				return nil, err
+			}
+
+			f.observe(response)
+			return response, nil
+		}
+	}
+
+	for _, upstream := range f.upstreams {
+		response, msg, err := f.forward(ctx, upstream, query, tcp)
+		if err != nil {
+			continue
+		}
+
+		if header.OpCode == 0 && len(msg.Questions) == 1 && newCacheKey(msg.Questions[0]) == newCacheKey(question) {
+			f.cache.put(question, msg)
+		}
+
+		f.observe(response)
+		return response, nil
+	}
+
+	return failureResponse(header, question, dnsmessage.RCodeServerFailure)
+}
+
+// observe passes response to the OnResponse callback, if any.
+func (f *Forwarder) observe(response []byte) {
+	if f.onResponse != nil {
+		f.onResponse(response)
+	}
+}
+
+// forward exchanges query with upstream within the forwarder timeout and parses the response.
+func (f *Forwarder) forward(ctx context.Context, upstream upstream, query []byte, tcp bool) ([]byte, *dnsmessage.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	response, err := upstream.exchange(ctx, query, tcp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(response); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	return response, &msg, nil
+}
+
+// serveUDP answers the queries received on the UDP socket, each in its own goroutine. Queries arriving while
+// MaxQueries are pending get a SERVFAIL response instead.
+func (f *Forwarder) serveUDP() {
+	defer f.wg.Done()
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := f.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		query := slices.Clone(buf[:n])
+
+		select {
+		case f.queries <- struct{}{}:
+		default:
+			if response, err := serverFailure(query); err == nil {
+				_, _ = f.udp.WriteTo(response, addr)
+			}
+			continue
+		}
+
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			defer func() { <-f.queries }()
+
+			response, err := f.resolve(f.ctx, query, false)
+			if err != nil {
+				return
+			}
+
+			if response, err = TruncateResponse(response, query); err != nil {
+				return
+			}
+
+			_, _ = f.udp.WriteTo(response, addr)
+		}()
+	}
+}
+
+// serveTCP accepts TCP clients and serves each in its own goroutine, disconnecting those arriving while MaxQueries
+// are connected.
+func (f *Forwarder) serveTCP() {
+	defer f.wg.Done()
+
+	for {
+		conn, err := f.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		select {
+		case f.clients <- struct{}{}:
+		default:
+			_ = conn.Close()
+			continue
+		}
+
+		f.wg.Add(1)
+		go func() {
+			defer f.wg.Done()
+			defer func() { <-f.clients }()
+			f.serveConn(conn)
+		}()
+	}
+}
+
+// serveConn answers the length-prefixed queries of a TCP client until it goes idle or the forwarder closes.
+func (f *Forwarder) serveConn(conn net.Conn) {
+	defer conn.Close()
+
+	stop := context.AfterFunc(f.ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	for {
+		if err := conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout)); err != nil {
+			return
+		}
+
+		query, err := readTCPMessage(conn)
+		if err != nil {
+			return
+		}
+
+		response, err := f.resolve(f.ctx, query, true)
+		if err != nil {
+			return
+		}
+
+		if err := writeTCPMessage(conn, response); err != nil {
+			return
+		}
+	}
+}
+
+// readTCPMessage reads a DNS message preceded by its two-byte length.
+func readTCPMessage(r io.Reader) ([]byte, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// writeTCPMessage writes msg preceded by its two-byte length.
+func writeTCPMessage(w io.Writer, msg []byte) error {
+	if len(msg) > maxMessageSize {
+		return errors.New("DNS message too large")
+	}
+
+	buf := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(buf, uint16(len(msg)))
+	copy(buf[2:], msg)
+
+	_, err := w.Write(buf)
+	return err
+}
+
+// udpPayloadSize returns the largest UDP response the sender of query accepts, as advertised by its EDNS record.
+func udpPayloadSize(query []byte) int {
+	var parser dnsmessage.Parser
+	if _, err := parser.Start(query); err != nil {
+		return minUDPSize
+	}
+
+	if parser.SkipAllQuestions() != nil || parser.SkipAllAnswers() != nil || parser.SkipAllAuthorities() != nil {
+		return minUDPSize
+	}
+
+	for {
+		header, err := parser.AdditionalHeader()
+		if err != nil {
+			return minUDPSize
+		}
+
+		if header.Type == dnsmessage.TypeOPT {
+			return max(minUDPSize, int(header.Class))
+		}
+
+		if err := parser.SkipAdditional(); err != nil {
+			return minUDPSize
+		}
+	}
+}
+
+// TruncateResponse fits a response to query into the UDP payload size advertised by the query, 512 bytes without
+// EDNS. A larger response is replaced by its header and question with the truncated flag set, telling the client to
+// retry over TCP.
+func TruncateResponse(response, query []byte) ([]byte, error) {
+	if len(response) <= udpPayloadSize(query) {
+		return response, nil
+	}
+
+	var parser dnsmessage.Parser
+
+	header, err := parser.Start(response)
+	if err != nil {
+		return nil, err
+	}
+
+	questions, err := parser.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+
+	header.Truncated = true
+	msg := dnsmessage.Message{Header: header, Questions: questions}
+
+	return msg.Pack()
+}
+
+// serverFailure returns the SERVFAIL response to query, which must be a query with a question.
+func serverFailure(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse query: %w", err)
+	}
+
+	if header.Response {
+		return nil, errors.New("message is not a query")
+	}
+
+	question, err := parser.Question()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse question: %w", err)
+	}
+
+	return failureResponse(header, question, dnsmessage.RCodeServerFailure)
+}
+
+// failureResponse builds a response to the query described by header and question carrying rcode.
+func failureResponse(header dnsmessage.Header, question dnsmessage.Question, rcode dnsmessage.RCode) ([]byte, error) {
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:                 header.ID,
+			Response:           true,
+			OpCode:             header.OpCode,
+			RecursionDesired:   header.RecursionDesired,
+			RecursionAvailable: true,
+			RCode:              rcode,
+		},
+		Questions: []dnsmessage.Question{question},
+	}
+
+	return msg.Pack()
+}
+
+// parseUpstream returns the host:port address of an upstream given as an IP address with an optional port.
+func parseUpstream(upstream string) (string, error) {
+	if addrPort, err := netip.ParseAddrPort(upstream); err == nil {
+		return addrPort.String(), nil
+	}
+
+	addr, err := netip.ParseAddr(upstream)
+	if err != nil {
+		return "", fmt.Errorf("invalid upstream %q: %w", upstream, err)
+	}
+
+	return netip.AddrPortFrom(addr, DefaultPort).String(), nil
+}
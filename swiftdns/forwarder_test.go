@@ -0,0 +1,347 @@
+package swiftdns
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testUpstream is a DNS server answering A queries with 192.0.2.1 and counting them. Names starting with "large"
+// get a truncated response over UDP.
+type testUpstream struct {
+	udp     net.PacketConn
+	tcp     net.Listener
+	queries atomic.Int32
+}
+
+func newTestUpstream(t *testing.T) *testUpstream {
+	t.Helper()
+
+	udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	u := &testUpstream{udp: udp, tcp: tcp}
+	t.Cleanup(func() {
+		_ = udp.Close()
+		_ = tcp.Close()
+	})
+
+	go func() {
+		buf := make([]byte, maxMessageSize)
+		for {
+			n, addr, err := udp.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = udp.WriteTo(u.answer(buf[:n], false), addr)
+		}
+	}()
+
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				_ = writeTCPMessage(conn, u.answer(query, true))
+			}()
+		}
+	}()
+
+	return u
+}
+
+func (u *testUpstream) address() string {
+	return u.udp.LocalAddr().String()
+}
+
+func (u *testUpstream) answer(query []byte, tcp bool) []byte {
+	u.queries.Add(1)
+	return testAnswer(query, tcp)
+}
+
+// testAnswer answers an A query with 192.0.2.1, or truncates the response over UDP for names starting with "large".
+func testAnswer(query []byte, tcp bool) []byte {
+	var msg dnsmessage.Message
+	if err := msg.Unpack(query); err != nil {
+		return nil
+	}
+
+	msg.Response = true
+	msg.RecursionAvailable = true
+
+	if name := msg.Questions[0].Name.String(); len(name) >= 5 && name[:5] == "large" && !tcp {
+		msg.Truncated = true
+	} else {
+		msg.Answers = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: msg.Questions[0].Name, Type: dnsmessage.TypeA, Class: dnsmessage.ClassINET, TTL: 60},
+			Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+		}}
+	}
+
+	response, _ := msg.Pack()
+	return response
+}
+
+func newTestQuery(t *testing.T, id uint16, name string) []byte {
+	t.Helper()
+
+	msg := dnsmessage.Message{
+		Header: dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{
+			Name:  dnsmessage.MustNewName(name),
+			Type:  dnsmessage.TypeA,
+			Class: dnsmessage.ClassINET,
+		}},
+	}
+
+	query, err := msg.Pack()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	return query
+}
+
+func newTestForwarder(t *testing.T, upstreams ...string) *Forwarder {
+	t.Helper()
+
+	forwarder, err := NewForwarder(&Config{
+		Listen:    net.ParseIP("127.0.53.1"),
+		Upstreams: upstreams,
+		Timeout:   time.Second,
+	})
+	if errors.Is(err, syscall.EACCES) {
+		t.Skip("binding port 53 requires privileges")
+	}
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	t.Cleanup(func() { _ = forwarder.Close() })
+
+	return forwarder
+}
+
+func parseTestResponse(t *testing.T, response []byte, id uint16) *dnsmessage.Message {
+	t.Helper()
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(response); err != nil {
+		t.Fatalf("expected a valid response, got %v", err)
+	}
+
+	if msg.ID != id || !msg.Response {
+		t.Fatalf("expected response %d, got %+v", id, msg.Header)
+	}
+
+	return &msg
+}
+
+func TestForwarderCache(t *testing.T) {
+	upstream := newTestUpstream(t)
+	forwarder := newTestForwarder(t, upstream.address())
+
+	var now atomic.Int64
+	now.Store(time.Now().UnixNano())
+	forwarder.cache.now = func() time.Time { return time.Unix(0, now.Load()) }
+
+	conn, err := net.Dial("udp", forwarder.Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, maxMessageSize)
+	for i, elapsed := range []time.Duration{0, 15 * time.Second} {
+		now.Add(int64(elapsed))
+		id := uint16(100 + i)
+
+		if _, err := conn.Write(newTestQuery(t, id, "cached.example.")); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		msg := parseTestResponse(t, buf[:n], id)
+		if len(msg.Answers) != 1 || msg.Answers[0].Header.TTL != 60-uint32(elapsed/time.Second) {
+			t.Fatalf("unexpected answers %+v", msg.Answers)
+		}
+	}
+
+	if queries := upstream.queries.Load(); queries != 1 {
+		t.Fatalf("expected the second query to be answered from the cache, got %d upstream queries", queries)
+	}
+
+	now.Add(int64(time.Minute))
+
+	if _, err := forwarder.Resolve(context.Background(), newTestQuery(t, 102, "cached.example.")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if queries := upstream.queries.Load(); queries != 2 {
+		t.Fatalf("expected the expired entry to be refreshed, got %d upstream queries", queries)
+	}
+}
+
+func TestForwarderTCP(t *testing.T) {
+	upstream := newTestUpstream(t)
+	forwarder := newTestForwarder(t, upstream.address())
+
+	conn, err := net.Dial("tcp", forwarder.Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if err := writeTCPMessage(conn, newTestQuery(t, 200, "tcp.example.")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	response, err := readTCPMessage(conn)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if msg := parseTestResponse(t, response, 200); len(msg.Answers) != 1 {
+		t.Fatalf("unexpected answers %+v", msg.Answers)
+	}
+
+	// Truncated UDP responses are retried over TCP.
+	response, err = forwarder.Resolve(context.Background(), newTestQuery(t, 201, "large.example."))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if msg := parseTestResponse(t, response, 201); msg.Truncated || len(msg.Answers) != 1 {
+		t.Fatalf("expected a full response over TCP, got %+v", msg)
+	}
+}
+
+func TestForwarderServerFailure(t *testing.T) {
+	closed, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	address := closed.LocalAddr().String()
+	_ = closed.Close()
+
+	forwarder := newTestForwarder(t, address)
+
+	response, err := forwarder.Resolve(context.Background(), newTestQuery(t, 300, "down.example."))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if msg := parseTestResponse(t, response, 300); msg.RCode != dnsmessage.RCodeServerFailure {
+		t.Fatalf("expected SERVFAIL, got %v", msg.RCode)
+	}
+}
+
+func TestParseUpstream(t *testing.T) {
+	for upstream, expected := range map[string]string{
+		"10.8.0.1":           "10.8.0.1:53",
+		"10.8.0.1:5353":      "10.8.0.1:5353",
+		"2001:db8::1":        "[2001:db8::1]:53",
+		"[2001:db8::1]:5353": "[2001:db8::1]:5353",
+	} {
+		address, err := parseUpstream(upstream)
+		if err != nil {
+			t.Fatalf("expected no error for %s, got %v", upstream, err)
+		}
+
+		if address != expected {
+			t.Fatalf("expected %s for %s, got %s", expected, upstream, address)
+		}
+	}
+
+	if _, err := parseUpstream("dns.example"); err == nil {
+		t.Fatal("expected an error for a host name")
+	}
+}
+
+func TestForwarderMaxQueries(t *testing.T) {
+	// The upstream never answers, so the first query keeps its slot until the forwarder closes.
+	blackhole, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer blackhole.Close()
+
+	forwarder, err := NewForwarder(&Config{
+		Listen:     net.ParseIP("127.0.53.1"),
+		Upstreams:  []string{blackhole.LocalAddr().String()},
+		Timeout:    time.Minute,
+		MaxQueries: 1,
+	})
+	if errors.Is(err, syscall.EACCES) {
+		t.Skip("binding port 53 requires privileges")
+	}
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer forwarder.Close()
+
+	conn, err := net.Dial("udp", forwarder.Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	for id := uint16(400); id <= 401; id++ {
+		if _, err := conn.Write(newTestQuery(t, id, "busy.example.")); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	buf := make([]byte, maxMessageSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if msg := parseTestResponse(t, buf[:n], 401); msg.RCode != dnsmessage.RCodeServerFailure {
+		t.Fatalf("expected SERVFAIL over the limit, got %v", msg.RCode)
+	}
+
+	first, err := net.Dial("tcp", forwarder.Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer first.Close()
+
+	second, err := net.Dial("tcp", forwarder.Addr().String())
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer second.Close()
+	_ = second.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := second.Read(buf); !errors.Is(err, io.EOF) && !errors.Is(err, syscall.ECONNRESET) {
+		t.Fatalf("expected the client over the limit to be disconnected, got %v", err)
+	}
+}
@@ -0,0 +1,323 @@
+package swiftdns
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	// dotPort and dohPort are the default ports of DNS over TLS and DNS over HTTPS.
+	dotPort = 853
+	dohPort = 443
+
+	// dnsMessageType is the media type of DNS-over-HTTPS requests and responses.
+	dnsMessageType = "application/dns-message"
+)
+
+// upstream exchanges queries with a DNS server.
+type upstream interface {
+	// exchange sends query and returns the response carrying the same ID. tcp asks plain upstreams to skip UDP.
+	exchange(ctx context.Context, query []byte, tcp bool) ([]byte, error)
+	// close releases idle connections.
+	close()
+}
+
+// plainUpstream is a DNS server queried over UDP, and over TCP for truncated responses.
+type plainUpstream struct {
+	address string
+	dialer  *net.Dialer
+}
+
+// tlsUpstream is a DNS-over-TLS server, queried over a new connection per query.
+type tlsUpstream struct {
+	addresses []string
+	config    *tls.Config
+	dialer    *net.Dialer
+}
+
+// httpsUpstream is a DNS-over-HTTPS server queried with POST requests.
+type httpsUpstream struct {
+	url       string
+	client    *http.Client
+	transport *http.Transport
+}
+
+// newEncryptedUpstream returns the DNS-over-TLS or DNS-over-HTTPS upstream described by config. Connections are
+// opened with dialer to the bootstrap addresses, or the address in the URL.
+func newEncryptedUpstream(config swiftypes.DNSUpstream, dialer *net.Dialer) (upstream, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid upstream %q: %w", config.URL, err)
+	}
+
+	port := dotPort
+	switch u.Scheme {
+	case "tls":
+	case "https":
+		port = dohPort
+	default:
+		return nil, fmt.Errorf("unsupported upstream scheme %q", u.Scheme)
+	}
+
+	if u.Port() != "" {
+		if port, err = strconv.Atoi(u.Port()); err != nil {
+			return nil, fmt.Errorf("invalid upstream port %q: %w", u.Port(), err)
+		}
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return nil, fmt.Errorf("upstream %q has no host", config.URL)
+	}
+
+	bootstrap := config.Bootstrap
+	if ip := net.ParseIP(host); ip != nil {
+		bootstrap = []net.IP{ip}
+	}
+
+	if len(bootstrap) == 0 {
+		return nil, fmt.Errorf("upstream %q needs bootstrap addresses", config.URL)
+	}
+
+	addresses := make([]string, 0, len(bootstrap))
+	for _, ip := range bootstrap {
+		addresses = append(addresses, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+	}
+
+	tlsConfig := pinnedTLSConfig(host, config.Pins)
+
+	if u.Scheme == "tls" {
+		return &tlsUpstream{addresses: addresses, config: tlsConfig, dialer: dialer}, nil
+	}
+
+	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialAny(ctx, dialer, network, addresses)
+		},
+		TLSClientConfig:   tlsConfig,
+		ForceAttemptHTTP2: true,
+		IdleConnTimeout:   30 * time.Second,
+	}
+
+	return &httpsUpstream{url: u.String(), client: &http.Client{Transport: transport}, transport: transport}, nil
+}
+
+// pinnedTLSConfig returns a TLS configuration for serverName. Without pins the chain is verified as usual. With pins,
+// a leaf matching a pin is accepted once it is valid for serverName, and any other chain must verify against the
+// system roots and contain a pinned certificate.
+func pinnedTLSConfig(serverName string, pins [][]byte) *tls.Config {
+	config := &tls.Config{ServerName: serverName, MinVersion: tls.VersionTLS12}
+	if len(pins) == 0 {
+		return config
+	}
+
+	pinned := func(cert *x509.Certificate) bool {
+		sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+		return slices.ContainsFunc(pins, func(pin []byte) bool { return bytes.Equal(pin, sum[:]) })
+	}
+
+	// Chain verification is done below, as a pinned self-signed leaf must pass without a CA.
+	config.InsecureSkipVerify = true
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return errors.New("server presented no certificate")
+		}
+
+		leaf := state.PeerCertificates[0]
+		if err := leaf.VerifyHostname(serverName); err != nil {
+			return err
+		}
+
+		if pinned(leaf) {
+			return nil
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range state.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		chains, err := leaf.Verify(x509.VerifyOptions{DNSName: serverName, Intermediates: intermediates})
+		if err != nil {
+			return err
+		}
+
+		for _, chain := range chains {
+			if slices.ContainsFunc(chain, pinned) {
+				return nil
+			}
+		}
+
+		return errors.New("server certificate does not match any pin")
+	}
+
+	return config
+}
+
+// dialAny connects to the first reachable address.
+func dialAny(ctx context.Context, dialer *net.Dialer, network string, addresses []string) (net.Conn, error) {
+	var errs []error
+
+	for _, address := range addresses {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+func (u *plainUpstream) exchange(ctx context.Context, query []byte, tcp bool) ([]byte, error) {
+	if !tcp {
+		response, err := exchangeUDP(ctx, u.dialer, u.address, query)
+		if err != nil {
+			return nil, err
+		}
+
+		// Truncated flag.
+		if len(response) < 3 || response[2]&0x02 == 0 {
+			return response, nil
+		}
+	}
+
+	conn, err := u.dialer.DialContext(ctx, "tcp", u.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", u.address, err)
+	}
+
+	return exchangeStream(ctx, conn, u.address, query)
+}
+
+func (u *plainUpstream) close() {}
+
+func (u *tlsUpstream) exchange(ctx context.Context, query []byte, _ bool) ([]byte, error) {
+	conn, err := dialAny(ctx, u.dialer, "tcp", u.addresses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", u.config.ServerName, err)
+	}
+
+	tlsConn := tls.Client(conn, u.config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed TLS handshake with %s: %w", u.config.ServerName, err)
+	}
+
+	return exchangeStream(ctx, tlsConn, u.config.ServerName, query)
+}
+
+func (u *tlsUpstream) close() {}
+
+// exchange posts query with a zero ID, as recommended for HTTP caching, and gives the response the query's ID.
+func (u *httpsUpstream) exchange(ctx context.Context, query []byte, _ bool) ([]byte, error) {
+	if len(query) < 2 {
+		return nil, errors.New("query too short")
+	}
+
+	body := slices.Clone(query)
+	body[0], body[1] = 0, 0
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", dnsMessageType)
+	request.Header.Set("Accept", dnsMessageType)
+
+	response, err := u.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", u.url, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query %s: %s", u.url, response.Status)
+	}
+
+	if contentType := response.Header.Get("Content-Type"); contentType != dnsMessageType {
+		return nil, fmt.Errorf("unexpected content type %q from %s", contentType, u.url)
+	}
+
+	msg, err := io.ReadAll(io.LimitReader(response.Body, maxMessageSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", u.url, err)
+	}
+
+	if len(msg) < 2 {
+		return nil, fmt.Errorf("short response from %s", u.url)
+	}
+
+	copy(msg, query[:2])
+	return msg, nil
+}
+
+func (u *httpsUpstream) close() {
+	u.transport.CloseIdleConnections()
+}
+
+// exchangeUDP sends query to address over UDP and returns the first response carrying its ID.
+func exchangeUDP(ctx context.Context, dialer *net.Dialer, address string, query []byte) ([]byte, error) {
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("failed to send query to %s: %w", address, err)
+	}
+
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response from %s: %w", address, err)
+		}
+
+		if n >= 2 && bytes.Equal(buf[:2], query[:2]) {
+			return slices.Clone(buf[:n]), nil
+		}
+	}
+}
+
+// exchangeStream sends query over the TCP or TLS connection conn, which it closes, and returns the response.
+func exchangeStream(ctx context.Context, conn net.Conn, server string, query []byte) ([]byte, error) {
+	defer conn.Close()
+
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	if err := writeTCPMessage(conn, query); err != nil {
+		return nil, fmt.Errorf("failed to send query to %s: %w", server, err)
+	}
+
+	response, err := readTCPMessage(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", server, err)
+	}
+
+	if len(response) < 2 || binary.BigEndian.Uint16(response) != binary.BigEndian.Uint16(query) {
+		return nil, fmt.Errorf("mismatched response from %s", server)
+	}
+
+	return response, nil
+}
@@ -0,0 +1,124 @@
+package swiftdns
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"golang.org/x/net/dns/dnsmessage"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newTestDoHServer starts a DNS-over-HTTPS server answering through testAnswer. Its certificate is valid for
+// example.com and 127.0.0.1.
+func newTestDoHServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, err := io.ReadAll(r.Body)
+		if err != nil || r.Method != http.MethodPost || r.Header.Get("Content-Type") != dnsMessageType {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		if query[0] != 0 || query[1] != 0 {
+			http.Error(w, "expected a zero ID", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", dnsMessageType)
+		_, _ = w.Write(testAnswer(query, true))
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// newTestDoTServer starts a DNS-over-TLS server using the certificate of server and returns its port.
+func newTestDoTServer(t *testing.T, server *httptest.Server) int {
+	t.Helper()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: server.TLS.Certificates})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				query, err := readTCPMessage(conn)
+				if err != nil {
+					return
+				}
+				_ = writeTCPMessage(conn, testAnswer(query, true))
+			}()
+		}
+	}()
+
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestEncryptedUpstreams(t *testing.T) {
+	doh := newTestDoHServer(t)
+	dohPort := doh.Listener.Addr().(*net.TCPAddr).Port
+	dotPort := newTestDoTServer(t, doh)
+
+	sum := sha256.Sum256(doh.Certificate().RawSubjectPublicKeyInfo)
+	pin, wrongPin := sum[:], make([]byte, sha256.Size)
+	bootstrap := []net.IP{net.ParseIP("127.0.0.1")}
+
+	for i, test := range []struct {
+		upstream swiftypes.DNSUpstream
+		answered bool
+	}{
+		{swiftypes.DNSUpstream{URL: "https://example.com:" + strconv.Itoa(dohPort) + "/dns-query", Bootstrap: bootstrap, Pins: [][]byte{pin}}, true},
+		{swiftypes.DNSUpstream{URL: "tls://example.com:" + strconv.Itoa(dotPort), Bootstrap: bootstrap, Pins: [][]byte{pin}}, true},
+		{swiftypes.DNSUpstream{URL: "tls://127.0.0.1:" + strconv.Itoa(dotPort), Pins: [][]byte{pin}}, true},
+		{swiftypes.DNSUpstream{URL: "https://example.com:" + strconv.Itoa(dohPort) + "/dns-query", Bootstrap: bootstrap, Pins: [][]byte{wrongPin}}, false},
+		{swiftypes.DNSUpstream{URL: "tls://example.com:" + strconv.Itoa(dotPort), Bootstrap: bootstrap}, false},
+		{swiftypes.DNSUpstream{URL: "tls://example.org:" + strconv.Itoa(dotPort), Bootstrap: bootstrap, Pins: [][]byte{pin}}, false},
+	} {
+		forwarder, err := NewForwarder(&Config{Encrypted: []swiftypes.DNSUpstream{test.upstream}, Timeout: 2 * time.Second})
+		if err != nil {
+			t.Fatalf("expected no error for %s, got %v", test.upstream.URL, err)
+		}
+
+		id := uint16(400 + i)
+		response, err := forwarder.Resolve(context.Background(), newTestQuery(t, id, "encrypted.example."))
+		_ = forwarder.Close()
+		if err != nil {
+			t.Fatalf("expected no error for %s, got %v", test.upstream.URL, err)
+		}
+
+		msg := parseTestResponse(t, response, id)
+		if answered := msg.RCode == dnsmessage.RCodeSuccess && len(msg.Answers) == 1; answered != test.answered {
+			t.Fatalf("expected answered=%v for %s with pins %x, got %+v", test.answered, test.upstream.URL, test.upstream.Pins, msg)
+		}
+	}
+}
+
+func TestEncryptedUpstreamConfig(t *testing.T) {
+	for _, upstream := range []swiftypes.DNSUpstream{
+		{URL: "tls://dns.example"},
+		{URL: "udp://10.8.0.1"},
+		{URL: "https:///dns-query", Bootstrap: []net.IP{net.ParseIP("10.8.0.1")}},
+	} {
+		if _, err := NewForwarder(&Config{Encrypted: []swiftypes.DNSUpstream{upstream}}); err == nil {
+			t.Fatalf("expected an error for %s", upstream.URL)
+		}
+	}
+}
@@ -3,12 +3,66 @@
 package swiftunnel
 
 import (
+	"context"
+	"errors"
 	"github.com/SyNdicateFoundation/swiftunnel/swiftconfig"
+	"github.com/SyNdicateFoundation/swiftunnel/swiftutils"
 	"github.com/SyNdicateFoundation/swiftunnel/swiftypes"
+	"github.com/google/nftables"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
 	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
+// testUnicastConfig parses cidr into a UnicastConfig for test adapters.
+func testUnicastConfig(t *testing.T, cidr string) *swiftypes.UnicastConfig {
+	t.Helper()
+
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	return &swiftypes.UnicastConfig{
+		IPNet: ipNet,
+		IP:    ip,
+	}
+}
+
+// waitAddressesReady waits until no IPv6 address of the link called name is tentative. The kernel finishes even
+// NoDAD addresses asynchronously once the link is up, and drops packets sent to them until then.
+func waitAddressesReady(t *testing.T, name string) {
+	t.Helper()
+
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if !slices.ContainsFunc(addrs, func(addr netlink.Addr) bool { return addr.Flags&unix.IFA_F_TENTATIVE != 0 }) {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the addresses of %s to leave the tentative state, got %v", name, addrs)
+		}
+	}
+}
+
 func TestNewSwiftInterface(t *testing.T) {
 	ip, ipNet, err := net.ParseCIDR("172.0.10.2/24")
 	if err != nil {
@@ -91,6 +145,28 @@ func TestSetUnicastIpAddressEntry(t *testing.T) {
 	if err := adapter.SetUnicastIpAddressEntry(unicastConfig); err != nil {
 		t.Fatalf("expected no error setting IP address, got %v", err)
 	}
+
+	// IP is assigned with the prefix length of IPNet, and IPNet as is when IP is nil.
+	_, hostNet, _ := net.ParseCIDR("172.0.11.7/24")
+	if err := adapter.SetUnicastIpAddressEntry(&swiftypes.UnicastConfig{IPNet: hostNet}); err != nil {
+		t.Fatalf("expected no error setting IP address, got %v", err)
+	}
+
+	addrs, err := adapter.Addresses()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	var got []string
+	for _, addr := range addrs {
+		if addr.IPNet.IP.To4() != nil {
+			got = append(got, addr.IPNet.String())
+		}
+	}
+
+	if !slices.Equal(got, []string{"172.0.10.2/24", "172.0.11.0/24"}) {
+		t.Fatalf("expected 172.0.10.2/24 and 172.0.11.0/24, got %v", got)
+	}
 }
 
 func TestTunReadCloser_Read(t *testing.T) {
@@ -125,3 +201,1633 @@ func TestTunReadCloser_Read(t *testing.T) {
 	}
 
 }
+
+func TestMultiQueue(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tunmq0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+		MultiQueue:  true,
+		Queues:      4,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	queues := adapter.Queues()
+	if len(queues) != 4 {
+		t.Fatalf("expected 4 queues, got %d", len(queues))
+	}
+
+	for i, queue := range queues {
+		if queue.Index() != i {
+			t.Errorf("expected queue index %d, got %d", i, queue.Index())
+		}
+	}
+
+	if err := queues[1].Detach(); err != nil {
+		t.Fatalf("expected no error detaching queue, got %v", err)
+	}
+
+	if err := queues[1].Attach(); err != nil {
+		t.Fatalf("expected no error attaching queue, got %v", err)
+	}
+}
+
+func TestSingleQueueAttach(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tun0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.Queues()[0].Detach(); !errors.Is(err, ErrNotMultiQueue) {
+		t.Fatalf("expected ErrNotMultiQueue, got %v", err)
+	}
+}
+
+func TestCloseUnblocksRead(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tun0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := adapter.Read(make([]byte, 2048))
+		result <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	select {
+	case err := <-result:
+		if !errors.Is(err, os.ErrClosed) {
+			t.Fatalf("expected os.ErrClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read was not unblocked by Close")
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tun0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := adapter.Read(make([]byte, 2048)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected os.ErrDeadlineExceeded, got %v", err)
+	}
+}
+
+func TestReadContext(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tun0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if _, err := adapter.ReadContext(ctx, make([]byte, 2048)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if err := adapter.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := adapter.Read(make([]byte, 2048)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected os.ErrDeadlineExceeded after ReadContext, got %v", err)
+	}
+}
+
+func TestNewSwiftInterfaceFromFD(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tun0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	fd, err := unix.Dup(int(adapter.Queues()[0].Fd()))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := NewSwiftInterfaceFromFD(uintptr(fd), "tun0", swiftypes.AdapterTypeTAP); err == nil {
+		t.Fatal("expected an adapter type mismatch error")
+	}
+
+	fd, err = unix.Dup(int(adapter.Queues()[0].Fd()))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	adopted, err := NewSwiftInterfaceFromFD(uintptr(fd), "", swiftypes.AdapterTypeTUN)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adopted.Close()
+
+	if name, _ := adopted.GetAdapterName(); name != "tun0" {
+		t.Errorf("expected adopted adapter name tun0, got %s", name)
+	}
+}
+
+func TestSendReceiveSwiftInterface(t *testing.T) {
+	pair, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range pair {
+		file := os.NewFile(uintptr(fd), "socketpair")
+		conn, err := net.FileConn(file)
+		_ = file.Close()
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		conns[i] = conn.(*net.UnixConn)
+		defer conns[i].Close()
+	}
+
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tunpass0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+		MultiQueue:  true,
+		Queues:      2,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := SendSwiftInterface(conns[0], adapter); err != nil {
+		t.Fatalf("expected no error sending, got %v", err)
+	}
+
+	received, err := ReceiveSwiftInterface(conns[1])
+	if err != nil {
+		t.Fatalf("expected no error receiving, got %v", err)
+	}
+	defer received.Close()
+
+	// The device must survive the sender closing its own descriptors.
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing sender, got %v", err)
+	}
+
+	if name, _ := received.GetAdapterName(); name != "tunpass0" {
+		t.Errorf("expected adapter name tunpass0, got %s", name)
+	}
+
+	if len(received.Queues()) != 2 {
+		t.Errorf("expected 2 queues, got %d", len(received.Queues()))
+	}
+
+	if _, err := received.GetAdapterIndex(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := received.SetMTU(1400); err != nil {
+		t.Fatalf("expected no error setting MTU, got %v", err)
+	}
+}
+
+func TestPersistentInterfaceLifecycle(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tunpersist0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+		MultiQueue:  true,
+		Persist:     true,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+	defer DeletePersistentInterface("tunpersist0")
+
+	interfaces, err := ListPersistentInterfaces()
+	if err != nil {
+		t.Fatalf("expected no error listing, got %v", err)
+	}
+
+	found := false
+	for _, info := range interfaces {
+		if info.Name == "tunpersist0" {
+			found = info.MultiQueue && info.AdapterType == swiftypes.AdapterTypeTUN
+		}
+	}
+	if !found {
+		t.Fatalf("expected tunpersist0 to be listed, got %+v", interfaces)
+	}
+
+	// The alias belongs to the user and survives reattaching.
+	link, err := netlink.LinkByName("tunpersist0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := netlink.LinkSetAlias(link, "lab uplink"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	attached, err := AttachPersistentInterface("tunpersist0")
+	if err != nil {
+		t.Fatalf("expected no error attaching, got %v", err)
+	}
+	_ = attached.Close()
+
+	if link, err = netlink.LinkByName("tunpersist0"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if alias := link.Attrs().Alias; alias != "lab uplink" {
+		t.Fatalf("expected the alias to be kept, got %q", alias)
+	}
+
+	if err := DeletePersistentInterface("tunpersist0"); err != nil {
+		t.Fatalf("expected no error deleting, got %v", err)
+	}
+
+	if _, err := net.InterfaceByName("tunpersist0"); err == nil {
+		t.Fatal("expected tunpersist0 to be removed")
+	}
+
+	if err := DeletePersistentInterface("lo"); !errors.Is(err, ErrNotPersistentInterface) {
+		t.Fatalf("expected ErrNotPersistentInterface, got %v", err)
+	}
+}
+
+func TestPersistentInterfaceWithoutAltName(t *testing.T) {
+	// Another link holding the alternative name makes tagging fail, as on kernels without alternative names.
+	holder := &netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Name: "tunholder0"}, Mode: netlink.TUNTAP_MODE_TUN}
+	if err := netlink.LinkAdd(holder); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer netlink.LinkDel(holder)
+
+	if err := netlink.LinkAddAltName(holder, persistentAltNamePrefix+"tunpersist2"); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tunpersist2",
+		AdapterType: swiftypes.AdapterTypeTUN,
+		Persist:     true,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+	defer DeletePersistentInterface("tunpersist2")
+
+	link, err := netlink.LinkByName("tunpersist2")
+	if err != nil {
+		t.Fatalf("expected tunpersist2 to persist, got %v", err)
+	}
+
+	if alias := link.Attrs().Alias; alias != persistentAlias {
+		t.Fatalf("expected the %q alias, got %q", persistentAlias, alias)
+	}
+
+	if err := DeletePersistentInterface("tunpersist2"); err != nil {
+		t.Fatalf("expected no error deleting, got %v", err)
+	}
+
+	if _, err := net.InterfaceByName("tunpersist2"); err == nil {
+		t.Fatal("expected tunpersist2 to be removed")
+	}
+}
+
+func TestDeleteOnClose(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunpersist1",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		Persist:       true,
+		DeleteOnClose: true,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	if _, err := net.InterfaceByName("tunpersist1"); err == nil {
+		_ = DeletePersistentInterface("tunpersist1")
+		t.Fatal("expected tunpersist1 to be removed on close")
+	}
+}
+
+// newTestNetNS creates a named network namespace without leaving the calling thread inside it.
+func newTestNetNS(t *testing.T, name string) netns.NsHandle {
+	t.Helper()
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer origin.Close()
+
+	ns, err := netns.NewNamed(name)
+	if err != nil {
+		t.Fatalf("expected no error creating namespace, got %v", err)
+	}
+
+	if err := netns.Set(origin); err != nil {
+		t.Fatalf("expected no error restoring namespace, got %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = ns.Close()
+		_ = netns.DeleteNamed(name)
+	})
+
+	return ns
+}
+
+func TestNetNSInterface(t *testing.T) {
+	ns := newTestNetNS(t, "swiftunnel-test0")
+
+	for _, tc := range []struct {
+		name  string
+		netNS *swiftconfig.NetNS
+	}{
+		{"ByName", swiftconfig.NewNetNSByName("swiftunnel-test0")},
+		{"MoveByFD", &swiftconfig.NetNS{FD: int(ns), Move: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			adapter, err := NewSwiftInterface(&swiftconfig.Config{
+				AdapterName:   "tunns0",
+				AdapterType:   swiftypes.AdapterTypeTUN,
+				MTU:           1400,
+				UnicastConfig: testUnicastConfig(t, "10.196.0.1/24"),
+				NetNS:         tc.netNS,
+			})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			defer adapter.Close()
+
+			if _, err := netlink.LinkByName("tunns0"); err == nil {
+				t.Fatal("expected tunns0 to be absent from the caller's namespace")
+			}
+
+			handle, err := netlink.NewHandleAt(ns)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			defer handle.Close()
+
+			link, err := handle.LinkByName("tunns0")
+			if err != nil {
+				t.Fatalf("expected tunns0 inside the namespace, got %v", err)
+			}
+
+			if link.Attrs().MTU != 1400 {
+				t.Errorf("expected MTU 1400, got %d", link.Attrs().MTU)
+			}
+
+			if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+				t.Fatalf("expected no error setting status, got %v", err)
+			}
+
+			if _, err := adapter.RouteList(netlink.FAMILY_V4); err != nil {
+				t.Fatalf("expected no error listing routes, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStatistics(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunstats0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		MTU:           1500,
+		UnicastConfig: testUnicastConfig(t, "10.194.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error setting status, got %v", err)
+	}
+
+	before, err := adapter.Statistics()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	conn, err := net.Dial("udp4", "10.194.0.2:9999")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("swiftunnel")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	n, err := adapter.Read(buf)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if _, err := adapter.Write(buildTestPacket(4, unix.IPPROTO_UDP, 0, 0, []byte("swiftunnel"))); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	after, err := adapter.Statistics()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if after.Library.ReadPackets != before.Library.ReadPackets+1 || after.Library.ReadBytes != before.Library.ReadBytes+uint64(n) {
+		t.Fatalf("unexpected read counters %+v", after.Library)
+	}
+
+	if after.Library.WritePackets != before.Library.WritePackets+1 {
+		t.Fatalf("unexpected write counters %+v", after.Library)
+	}
+
+	if after.Link.TxPackets <= before.Link.TxPackets || after.Link.RxPackets <= before.Link.RxPackets {
+		t.Fatalf("expected link counters to grow, got %+v then %+v", before.Link, after.Link)
+	}
+}
+
+// waitForEvent returns the first event accepted by match, failing the test after a timeout.
+func waitForEvent(t *testing.T, events <-chan Event, match func(Event) bool) Event {
+	t.Helper()
+
+	timeout := time.After(2 * time.Second)
+
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				t.Fatal("event channel closed unexpectedly")
+			}
+
+			if match(event) {
+				return event
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for event")
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tunwatch0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+		MTU:         1500,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := adapter.Watch(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error setting status, got %v", err)
+	}
+
+	waitForEvent(t, events, func(event Event) bool {
+		link, ok := event.(LinkEvent)
+		return ok && link.Up && link.Name == "tunwatch0"
+	})
+
+	if err := adapter.SetUnicastIpAddressEntry(testUnicastConfig(t, "10.193.0.1/24")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	waitForEvent(t, events, func(event Event) bool {
+		addr, ok := event.(AddressEvent)
+		return ok && !addr.Removed && addr.Address.Contains(net.ParseIP("10.193.0.1"))
+	})
+
+	_, dst, _ := net.ParseCIDR("10.192.0.0/24")
+	index, err := adapter.GetAdapterIndex()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	route := &netlink.Route{LinkIndex: index, Dst: dst}
+	if err := adapter.AddRoute(route); err != nil {
+		t.Fatalf("expected no error adding route, got %v", err)
+	}
+
+	if err := adapter.RemoveRoute(route); err != nil {
+		t.Fatalf("expected no error removing route, got %v", err)
+	}
+
+	waitForEvent(t, events, func(event Event) bool {
+		update, ok := event.(RouteEvent)
+		return ok && update.Removed && update.Route.Dst != nil && update.Route.Dst.String() == dst.String()
+	})
+
+	if err := adapter.SetStatus(swiftypes.InterfaceDown); err != nil {
+		t.Fatalf("expected no error setting status, got %v", err)
+	}
+
+	waitForEvent(t, events, func(event Event) bool {
+		link, ok := event.(LinkEvent)
+		return ok && !link.Up
+	})
+
+	cancel()
+
+	for range events {
+	}
+}
+
+func TestAddresses(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tunaddr0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+		MTU:         1500,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	v4, err := swiftypes.ParseAddress("10.191.0.1/24")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	v6, err := swiftypes.ParseAddress("fd00:191::1/64")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	v6.NoDAD = true
+	v6.PreferredLifetime = 10 * time.Minute
+	v6.ValidLifetime = time.Hour
+
+	ptp, err := swiftypes.ParseAddress("10.191.1.1/32")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	_, ptp.Peer, _ = net.ParseCIDR("10.191.1.2/32")
+
+	for _, addr := range []*swiftypes.Address{v4, v6, ptp} {
+		if err := adapter.AddAddress(addr); err != nil {
+			t.Fatalf("expected no error adding %v, got %v", addr, err)
+		}
+	}
+
+	addrs, err := adapter.Addresses()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	find := func(ip string) *swiftypes.Address {
+		for i := range addrs {
+			if addrs[i].IPNet.IP.Equal(net.ParseIP(ip)) {
+				return &addrs[i]
+			}
+		}
+		return nil
+	}
+
+	if got := find("10.191.0.1"); got == nil || got.IPNet.String() != "10.191.0.1/24" || got.ValidLifetime != 0 {
+		t.Fatalf("expected permanent 10.191.0.1/24, got %v", got)
+	}
+
+	got := find("fd00:191::1")
+	if got == nil || !got.NoDAD {
+		t.Fatalf("expected fd00:191::1 with nodad, got %v", got)
+	}
+
+	if got.ValidLifetime <= 59*time.Minute || got.ValidLifetime > time.Hour || got.PreferredLifetime > 10*time.Minute {
+		t.Fatalf("unexpected lifetimes %v/%v", got.PreferredLifetime, got.ValidLifetime)
+	}
+
+	if got := find("10.191.1.1"); got == nil || got.Peer == nil || got.Peer.String() != "10.191.1.2/32" {
+		t.Fatalf("expected peer address, got %v", got)
+	}
+
+	if err := adapter.RemoveAddress(v4); err != nil {
+		t.Fatalf("expected no error removing address, got %v", err)
+	}
+
+	addrs, err = adapter.Addresses()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if find("10.191.0.1") != nil {
+		t.Fatal("expected 10.191.0.1 to be removed")
+	}
+
+	if err := adapter.AddAddress(&swiftypes.Address{IPNet: v4.IPNet, PreferredLifetime: time.Hour, ValidLifetime: time.Minute}); err == nil {
+		t.Fatal("expected error for preferred lifetime exceeding valid lifetime")
+	}
+}
+
+func TestApply(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName: "tunapply0",
+		AdapterType: swiftypes.AdapterTypeTUN,
+		MTU:         1500,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	v4, _ := swiftypes.ParseAddress("10.190.0.1/24")
+	v6, _ := swiftypes.ParseAddress("fd00:190::1/64")
+	v6.NoDAD = true
+	_, dst, _ := net.ParseCIDR("10.189.0.0/24")
+
+	up := swiftypes.InterfaceUp
+	state := &InterfaceState{
+		Addresses: []swiftypes.Address{*v4, *v6},
+		Routes:    []netlink.Route{{Dst: dst}},
+		MTU:       1400,
+		Status:    &up,
+	}
+
+	report, err := adapter.Apply(state)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !report.MTUChanged || !report.StatusChanged || len(report.AddedAddresses) != 2 || len(report.AddedRoutes) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	report, err = adapter.Apply(state)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if report.Changed() {
+		t.Fatalf("expected second apply to be a no-op, got %+v", report)
+	}
+
+	other, _ := swiftypes.ParseAddress("10.188.0.1/24")
+	state.Addresses = []swiftypes.Address{*other, *v6}
+	state.Routes = []netlink.Route{}
+
+	report, err = adapter.Apply(state)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(report.RemovedAddresses) != 1 || report.RemovedAddresses[0].IPNet.String() != "10.190.0.1/24" {
+		t.Fatalf("expected 10.190.0.1/24 to be removed, got %+v", report.RemovedAddresses)
+	}
+
+	if len(report.AddedAddresses) != 1 || len(report.RemovedRoutes) != 1 || report.MTUChanged || report.StatusChanged {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	routes, err := adapter.RouteList(netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, route := range routes {
+		if route.Dst != nil && route.Dst.String() == dst.String() {
+			t.Fatalf("expected route %v to be removed", dst)
+		}
+	}
+
+	if report, err = adapter.Apply(state); err != nil || report.Changed() {
+		t.Fatalf("expected third apply to be a no-op, got %+v, %v", report, err)
+	}
+}
+
+func TestApplyRouteTable(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunapply1",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		UnicastConfig: testUnicastConfig(t, "10.198.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	_, dst, _ := net.ParseCIDR("10.200.0.0/24")
+	up := swiftypes.InterfaceUp
+	state := &InterfaceState{
+		Routes: []netlink.Route{{Dst: dst, Table: 100}},
+		Status: &up,
+	}
+
+	report, err := adapter.Apply(state)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(report.AddedRoutes) != 1 {
+		t.Fatalf("expected the route to be added, got %+v", report)
+	}
+
+	if report, err = adapter.Apply(state); err != nil || report.Changed() {
+		t.Fatalf("expected second apply to be a no-op, got %+v, %v", report, err)
+	}
+}
+
+func TestRollbackOnClose(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunrollback0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		Persist:       true,
+		UnicastConfig: testUnicastConfig(t, "10.187.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer DeletePersistentInterface("tunrollback0")
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	link, err := netlink.LinkByName("tunrollback0")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// An address added outside the interface is left alone and keeps the kept route reachable.
+	external, _ := netlink.ParseAddr("10.186.0.1/24")
+	if err := netlink.AddrAdd(link, external); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	added, _ := swiftypes.ParseAddress("10.185.0.1/24")
+	if err := adapter.AddAddress(added); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	_, dst, _ := net.ParseCIDR("10.184.0.0/24")
+	_, keptDst, _ := net.ParseCIDR("10.183.0.0/24")
+
+	if err := adapter.AddRoute(&netlink.Route{Dst: dst}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	kept := &netlink.Route{Dst: keptDst}
+	if err := adapter.AddRoute(kept); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	adapter.KeepRoute(kept)
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if len(addrs) != 1 || addrs[0].IPNet.String() != "10.186.0.1/24" {
+		t.Fatalf("expected only 10.186.0.1/24 to remain, got %v", addrs)
+	}
+
+	routes, err := netlink.RouteList(link, netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	foundKept := false
+	for _, route := range routes {
+		if route.Dst == nil {
+			continue
+		}
+
+		switch route.Dst.String() {
+		case dst.String():
+			t.Fatalf("expected route %v to be removed", dst)
+		case keptDst.String():
+			foundKept = true
+		}
+	}
+
+	if !foundKept {
+		t.Fatalf("expected route %v to be kept, got %v", keptDst, routes)
+	}
+}
+
+func TestPolicyRouting(t *testing.T) {
+	ns := newTestNetNS(t, "swiftunnel-test1")
+
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunpolicy0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		UnicastConfig: testUnicastConfig(t, "10.182.0.1/24"),
+		NetNS:         swiftconfig.NewNetNSByName("swiftunnel-test1"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	const table, mark = 2182, 0x882
+
+	if err := adapter.RouteAllExceptMark(table, mark); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	handle, err := netlink.NewHandleAt(ns)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer handle.Close()
+
+	routes, err := handle.RouteGetWithOptions(net.ParseIP("192.0.2.10"), &netlink.RouteGetOptions{})
+	if err != nil {
+		t.Fatalf("expected unmarked traffic to be routed, got %v", err)
+	}
+
+	index, _ := adapter.GetAdapterIndex()
+	if len(routes) != 1 || routes[0].LinkIndex != index {
+		t.Fatalf("expected unmarked traffic to use tunpolicy0, got %v", routes)
+	}
+
+	if _, err := handle.RouteGetWithOptions(net.ParseIP("192.0.2.10"), &netlink.RouteGetOptions{Mark: mark}); err == nil {
+		t.Fatal("expected marked traffic to bypass the tunnel table")
+	}
+
+	_, src, _ := net.ParseCIDR("10.182.0.0/24")
+	uidRule := NewUIDRangeRule(table, 1000, 1999)
+
+	for _, rule := range []*netlink.Rule{NewSourceRule(table, src), uidRule, NewIifRule(table, "lo")} {
+		if err := adapter.AddRule(rule); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	if err := adapter.RemoveRule(uidRule); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	rules, err := adapter.Rules(table)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// Fwmark and iif rules exist for both families, the source rule for IPv4 only.
+	if len(rules) != 5 {
+		t.Fatalf("expected 5 rules for table %d, got %v", table, rules)
+	}
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	rules, err = handle.RuleList(unix.AF_UNSPEC)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, rule := range rules {
+		if rule.Table == table || rule.SuppressPrefixlen == 0 {
+			t.Fatalf("expected rule %v to be removed on close", rule)
+		}
+	}
+}
+
+func TestFullTunnel(t *testing.T) {
+	ns := newTestNetNS(t, "swiftunnel-test2")
+
+	// Gateway discovery uses the namespace of the calling thread, so run the whole test inside the namespace.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer origin.Close()
+
+	if err := netns.Set(ns); err != nil {
+		t.Fatalf("expected no error entering namespace, got %v", err)
+	}
+	defer netns.Set(origin)
+
+	uplink := &netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, Mode: netlink.TUNTAP_MODE_TUN}
+	if err := netlink.LinkAdd(uplink); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, cidr := range []string{"10.181.0.2/24", "fd00:181::2/64"} {
+		addr, _ := netlink.ParseAddr(cidr)
+		addr.Flags = ifaFlagNoDAD
+		if err := netlink.AddrAdd(uplink, addr); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	if err := netlink.LinkSetUp(uplink); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// The IPv6 gateway is link-local, as usual, so the endpoint route needs the uplink as its output interface.
+	for _, gw := range []string{"10.181.0.1", "fe80::1"} {
+		if err := netlink.RouteAdd(&netlink.Route{LinkIndex: uplink.Attrs().Index, Gw: net.ParseIP(gw)}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunfull0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		UnicastConfig: testUnicastConfig(t, "10.180.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	config := &FullTunnelConfig{
+		Endpoints: []net.IP{net.ParseIP("203.0.113.7"), net.ParseIP("2001:db8::7")},
+		IPv4:      true,
+		IPv6:      true,
+	}
+
+	if err := adapter.EnableFullTunnel(config); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	index, _ := adapter.GetAdapterIndex()
+
+	// outgoingIndex returns the index of the interface the kernel picks for dst.
+	outgoingIndex := func(dst string) int {
+		routes, err := netlink.RouteGet(net.ParseIP(dst))
+		if err != nil || len(routes) == 0 {
+			t.Fatalf("expected a route to %s, got %v", dst, err)
+		}
+		return routes[0].LinkIndex
+	}
+
+	for _, dst := range []string{"198.51.100.1", "2001:db8:1::1"} {
+		if got := outgoingIndex(dst); got != index {
+			t.Fatalf("expected %s to use tunfull0, got index %d", dst, got)
+		}
+	}
+
+	for _, dst := range []string{"203.0.113.7", "2001:db8::7"} {
+		if got := outgoingIndex(dst); got != uplink.Attrs().Index {
+			t.Fatalf("expected endpoint %s to use uplink0, got index %d", dst, got)
+		}
+	}
+
+	// A new default gateway, e.g. after roaming, must not keep the endpoint routes from being removed.
+	roamed := &netlink.Route{LinkIndex: uplink.Attrs().Index, Gw: net.ParseIP("10.181.0.254")}
+	if err := netlink.RouteReplace(roamed); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := adapter.DisableFullTunnel(config); err != nil {
+		t.Fatalf("expected no error disabling, got %v", err)
+	}
+
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	for _, dst := range []string{"198.51.100.1", "2001:db8:1::1"} {
+		if got := outgoingIndex(dst); got != uplink.Attrs().Index {
+			t.Fatalf("expected %s to use uplink0 after close, got index %d", dst, got)
+		}
+	}
+
+	routes, err := netlink.RouteList(uplink, unix.AF_UNSPEC)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, route := range routes {
+		if route.Dst != nil && route.Dst.String() == "203.0.113.7/32" {
+			t.Fatalf("expected endpoint route %v to be removed", route)
+		}
+	}
+}
+
+func TestSplitTunnel(t *testing.T) {
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunsplit0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		UnicastConfig: testUnicastConfig(t, "10.179.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	parse := func(cidrs ...string) []*net.IPNet {
+		var ipNets []*net.IPNet
+		for _, cidr := range cidrs {
+			_, ipNet, _ := net.ParseCIDR(cidr)
+			ipNets = append(ipNets, ipNet)
+		}
+		return ipNets
+	}
+
+	// tunnelRoutes returns the destinations routed through tunsplit0, except the subnet of its address.
+	tunnelRoutes := func() []string {
+		routes, err := adapter.RouteList(netlink.FAMILY_V4)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		var dsts []string
+		for _, route := range routes {
+			if route.Dst != nil && route.Protocol != routeProtocolSystem {
+				dsts = append(dsts, route.Dst.String())
+			}
+		}
+		return dsts
+	}
+
+	split := NewSplitTunnel(adapter)
+
+	if err := split.Update(parse("10.178.0.0/16"), parse("10.178.128.0/17")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if got := tunnelRoutes(); len(got) != 1 || got[0] != "10.178.0.0/17" {
+		t.Fatalf("expected only 10.178.0.0/17, got %v", got)
+	}
+
+	if err := split.Update(parse("10.178.0.0/16", "10.177.0.0/16"), parse("10.178.0.0/17")); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if got := tunnelRoutes(); len(got) != 2 || !slices.Contains(got, "10.177.0.0/16") || !slices.Contains(got, "10.178.128.0/17") {
+		t.Fatalf("expected 10.177.0.0/16 and 10.178.128.0/17, got %v", got)
+	}
+
+	if len(split.Routes()) != 2 {
+		t.Fatalf("expected 2 tracked routes, got %v", split.Routes())
+	}
+
+	if err := split.Clear(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if got := tunnelRoutes(); len(got) != 0 {
+		t.Fatalf("expected no routes after clear, got %v", got)
+	}
+}
+
+func TestKillSwitch(t *testing.T) {
+	ns := newTestNetNS(t, "swiftunnel-test3")
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer origin.Close()
+
+	if err := netns.Set(ns); err != nil {
+		t.Fatalf("expected no error entering namespace, got %v", err)
+	}
+	defer netns.Set(origin)
+
+	lo, err := netlink.LinkByName("lo")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := netlink.LinkSetUp(lo); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	uplink := &netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, Mode: netlink.TUNTAP_MODE_TUN}
+	if err := netlink.LinkAdd(uplink); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	addr, _ := netlink.ParseAddr("10.175.0.2/24")
+	if err := netlink.AddrAdd(uplink, addr); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := netlink.LinkSetUp(uplink); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunkill0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		UnicastConfig: testUnicastConfig(t, "10.176.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4zero})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer conn.Close()
+
+	// send reports the error of sending a datagram to addr, which is EPERM when the kill switch drops it.
+	send := func(addr string) error {
+		udpAddr, _ := net.ResolveUDPAddr("udp4", addr)
+		_, err := conn.WriteToUDP([]byte("ping"), udpAddr)
+		return err
+	}
+
+	config := &KillSwitchConfig{
+		Endpoints: []KillSwitchEndpoint{{IP: net.ParseIP("10.175.0.50"), Protocol: unix.IPPROTO_UDP, Port: 51820}},
+	}
+
+	if err := adapter.ArmKillSwitch(config); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if armed, err := KillSwitchArmed(""); err != nil || !armed {
+		t.Fatalf("expected kill switch to be armed, got %v, %v", armed, err)
+	}
+
+	// Forwarded traffic, e.g. of containers behind the host, is dropped by default as well.
+	nft, err := nftConn(ns)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	forward, err := nft.ListChain(killSwitchTable(""), "forward")
+	if err != nil {
+		t.Fatalf("expected a forward chain, got %v", err)
+	}
+
+	if forward.Hooknum == nil || *forward.Hooknum != *nftables.ChainHookForward || forward.Policy == nil ||
+		*forward.Policy != nftables.ChainPolicyDrop {
+		t.Fatalf("expected the forward chain to drop by default, got %+v", forward)
+	}
+
+	for _, allowed := range []string{"127.0.0.1:9", "10.176.0.9:9", "10.175.0.50:51820"} {
+		if err := send(allowed); err != nil {
+			t.Fatalf("expected %s to be allowed, got %v", allowed, err)
+		}
+	}
+
+	for _, blocked := range []string{"10.175.0.9:9", "10.175.0.50:9"} {
+		if err := send(blocked); !errors.Is(err, unix.EPERM) {
+			t.Fatalf("expected %s to be blocked, got %v", blocked, err)
+		}
+	}
+
+	config.AllowLAN = true
+	if err := adapter.ArmKillSwitch(config); err != nil {
+		t.Fatalf("expected no error re-arming, got %v", err)
+	}
+
+	if err := send("10.175.0.9:9"); err != nil {
+		t.Fatalf("expected LAN traffic to be allowed, got %v", err)
+	}
+
+	adapter.KeepKillSwitch()
+	if err := adapter.Close(); err != nil {
+		t.Fatalf("expected no error closing, got %v", err)
+	}
+
+	if armed, err := KillSwitchArmed(""); err != nil || !armed {
+		t.Fatalf("expected kill switch to stay armed, got %v, %v", armed, err)
+	}
+
+	if err := RemoveKillSwitch(""); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := send("10.175.0.50:9"); err != nil {
+		t.Fatalf("expected traffic to be allowed after removal, got %v", err)
+	}
+}
+
+func TestKillSwitchIPv6(t *testing.T) {
+	ns := newTestNetNS(t, "swiftunnel-test5")
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer origin.Close()
+
+	if err := netns.Set(ns); err != nil {
+		t.Fatalf("expected no error entering namespace, got %v", err)
+	}
+	defer netns.Set(origin)
+
+	uplink := &netlink.Tuntap{LinkAttrs: netlink.LinkAttrs{Name: "uplink0"}, Mode: netlink.TUNTAP_MODE_TUN}
+	if err := netlink.LinkAdd(uplink); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	for _, cidr := range []string{"10.165.0.2/24", "fd00:165::2/64"} {
+		addr, _ := netlink.ParseAddr(cidr)
+		addr.Flags = ifaFlagNoDAD
+		if err := netlink.AddrAdd(uplink, addr); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	if err := netlink.LinkSetUp(uplink); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	waitAddressesReady(t, "uplink0")
+
+	// DHCP broadcasts and multicasts leave through the uplink.
+	for _, cidr := range []string{"255.255.255.255/32", "ff00::/8"} {
+		_, dst, _ := net.ParseCIDR(cidr)
+		if err := netlink.RouteAdd(&netlink.Route{LinkIndex: uplink.Attrs().Index, Dst: dst}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	adapter, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunkill1",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		UnicastConfig: testUnicastConfig(t, "10.164.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	if err := adapter.ArmKillSwitch(&KillSwitchConfig{
+		Endpoints: []KillSwitchEndpoint{{IP: net.ParseIP("fd00:165::50"), Protocol: unix.IPPROTO_UDP, Port: 51820}},
+	}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv6unspecified})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer udp.Close()
+
+	icmp, err := net.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer icmp.Close()
+
+	// sendUDP and sendICMP report the error of sending to addr, which is EPERM when the kill switch drops it.
+	sendUDP := func(addr string) error {
+		udpAddr, _ := net.ResolveUDPAddr("udp", addr)
+		_, err := udp.WriteToUDP([]byte("ping"), udpAddr)
+		return err
+	}
+	sendICMP := func(icmpType byte, addr string) error {
+		ipAddr, _ := net.ResolveIPAddr("ip6", addr)
+		_, err := icmp.WriteTo([]byte{icmpType, 0, 0, 0, 0, 0, 0, 0}, ipAddr)
+		return err
+	}
+
+	for _, allowed := range []string{"[fd00:165::50]:51820", "[ff02::1:2%uplink0]:547", "255.255.255.255:67"} {
+		if err := sendUDP(allowed); err != nil {
+			t.Fatalf("expected %s to be allowed, got %v", allowed, err)
+		}
+	}
+
+	// DHCP ports are no way out to other hosts.
+	for _, blocked := range []string{"[fd00:165::9]:9", "[fd00:165::9]:547", "10.165.0.9:67"} {
+		if err := sendUDP(blocked); !errors.Is(err, unix.EPERM) {
+			t.Fatalf("expected %s to be blocked, got %v", blocked, err)
+		}
+	}
+
+	// Neighbor solicitations resolve the next hop of the endpoint; echo requests stay blocked.
+	if err := sendICMP(135, "fd00:165::50"); err != nil {
+		t.Fatalf("expected a neighbor solicitation to be allowed, got %v", err)
+	}
+
+	if err := sendICMP(128, "fd00:165::50"); !errors.Is(err, unix.EPERM) {
+		t.Fatalf("expected an echo request to be blocked, got %v", err)
+	}
+}
+
+func TestServerNAT(t *testing.T) {
+	ns := newTestNetNS(t, "swiftunnel-test4")
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer origin.Close()
+
+	if err := netns.Set(ns); err != nil {
+		t.Fatalf("expected no error entering namespace, got %v", err)
+	}
+	defer netns.Set(origin)
+
+	server, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunsrv0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		UnicastConfig: testUnicastConfig(t, "10.174.0.1/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer server.Close()
+
+	uplink, err := NewSwiftInterface(&swiftconfig.Config{
+		AdapterName:   "tunup0",
+		AdapterType:   swiftypes.AdapterTypeTUN,
+		UnicastConfig: testUnicastConfig(t, "10.173.0.2/24"),
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer uplink.Close()
+
+	for _, adapter := range []*SwiftInterface{server, uplink} {
+		if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	if err := server.EnableServer(&ServerConfig{Egress: "tunup0"}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if data, err := os.ReadFile(ipv4ForwardingSysctl); err != nil || string(data) != "1\n" {
+		t.Fatalf("expected IPv4 forwarding to be enabled, got %q, %v", data, err)
+	}
+
+	pkt := buildTestPacket(4, unix.IPPROTO_UDP, 0, 0, []byte("ping"))
+	copy(pkt[12:], net.ParseIP("10.174.0.5").To4())
+	copy(pkt[16:], net.ParseIP("10.173.0.9").To4())
+	pkt[26], pkt[27] = 0, 0
+	swiftutils.IPv4HeaderChecksum(pkt)
+
+	if _, err := server.Write(pkt); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := uplink.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, err := uplink.Read(buf)
+		if err != nil {
+			t.Fatalf("expected the forwarded packet, got %v", err)
+		}
+
+		if swiftutils.IsIPv4(buf[:n]) && swiftutils.IPv4Destination(buf[:n]).Equal(net.ParseIP("10.173.0.9")) {
+			if src := swiftutils.IPv4Source(buf[:n]); !src.Equal(net.ParseIP("10.173.0.2")) {
+				t.Fatalf("expected source to be masqueraded to 10.173.0.2, got %v", src)
+			}
+			break
+		}
+	}
+
+	if err := server.DisableServer(); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if data, err := os.ReadFile(ipv4ForwardingSysctl); err != nil || string(data) != "0\n" {
+		t.Fatalf("expected IPv4 forwarding to be restored, got %q, %v", data, err)
+	}
+
+	if exists, err := nftTableExists(netns.None(), DefaultServerTable, nftables.TableFamilyINet); err != nil || exists {
+		t.Fatalf("expected NAT table to be removed, got %v, %v", exists, err)
+	}
+}
+
+func TestServerNATIPv6(t *testing.T) {
+	ns := newTestNetNS(t, "swiftunnel-test6")
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origin, err := netns.Get()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer origin.Close()
+
+	if err := netns.Set(ns); err != nil {
+		t.Fatalf("expected no error entering namespace, got %v", err)
+	}
+	defer netns.Set(origin)
+
+	adapters := map[string]*SwiftInterface{}
+	for name, cidr := range map[string]string{"tunsrv1": "fd00:163::1/64", "tunup1": "fd00:162::2/64"} {
+		adapter, err := NewSwiftInterface(&swiftconfig.Config{AdapterName: name, AdapterType: swiftypes.AdapterTypeTUN})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		defer adapter.Close()
+
+		addr, _ := swiftypes.ParseAddress(cidr)
+		addr.NoDAD = true
+		if err := adapter.AddAddress(addr); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if err := adapter.SetStatus(swiftypes.InterfaceUp); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		waitAddressesReady(t, name)
+
+		adapters[name] = adapter
+	}
+
+	server, uplink := adapters["tunsrv1"], adapters["tunup1"]
+	_, subnet, _ := net.ParseCIDR("fd00:163::/64")
+
+	if err := server.EnableServer(&ServerConfig{
+		Subnets: []*net.IPNet{subnet},
+		SNAT:    net.ParseIP("10.162.0.99"),
+	}); err == nil {
+		t.Fatal("expected an IPv4 SNAT address without IPv4 subnet to be rejected")
+	}
+
+	if err := server.EnableServer(&ServerConfig{
+		Subnets: []*net.IPNet{subnet},
+		Egress:  "tunup1",
+		SNAT:    net.ParseIP("fd00:162::99"),
+	}); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer server.DisableServer()
+
+	src, dst := net.ParseIP("fd00:163::5"), net.ParseIP("fd00:162::9")
+	pkt := buildTestPacket(6, unix.IPPROTO_UDP, 0, 0, []byte("ping"))
+	copy(pkt[8:], src)
+	copy(pkt[24:], dst)
+
+	udp := pkt[ipv6HeaderLen:]
+	udp[6], udp[7] = 0, 0
+	sum := ^swiftutils.Checksum(udp, swiftutils.PseudoHeaderChecksum(unix.IPPROTO_UDP, src, dst, uint16(len(udp))))
+	udp[6], udp[7] = byte(sum>>8), byte(sum)
+
+	if _, err := server.Write(pkt); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if err := uplink.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, err := uplink.Read(buf)
+		if err != nil {
+			t.Fatalf("expected the forwarded packet, got %v", err)
+		}
+
+		if swiftutils.IsIPv6(buf[:n]) && swiftutils.IPv6Destination(buf[:n]).Equal(dst) {
+			if src := swiftutils.IPv6Source(buf[:n]); !src.Equal(net.ParseIP("fd00:162::99")) {
+				t.Fatalf("expected source to be translated to fd00:162::99, got %v", src)
+			}
+			break
+		}
+	}
+}
+
+func TestNewSwiftInterfacePortableConfig(t *testing.T) {
+	if _, err := swiftconfig.New(
+		swiftconfig.WithUnicastIP("10.166.0.2/24"),
+		swiftconfig.WithDriverType(swiftconfig.DriverTypeWintun),
+	); err == nil {
+		t.Fatal("expected the Wintun driver to be rejected on Linux")
+	}
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte("nameserver 192.0.2.1\n"), 0o644); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	// The Windows extensions are ignored, so one call site builds the same config on every platform.
+	config, err := swiftconfig.New(
+		swiftconfig.WithAdapterName("tunportable0"),
+		swiftconfig.WithUnicastIP("10.166.0.2/24"),
+		swiftconfig.WithAddresses("10.166.1.2/24"),
+		swiftconfig.WithRoutes("10.166.8.0/24"),
+		swiftconfig.WithDNSConfig(&swiftypes.DNSConfig{DnsServers: []net.IP{net.ParseIP("10.166.0.53")}}),
+		swiftconfig.WithMTU(1400),
+		swiftconfig.WithRingBuffer(0x400000),
+		swiftconfig.WithPersist(false),
+		swiftconfig.WithResolvConfPath(path),
+	)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	adapter, err := NewSwiftInterface(config)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	defer adapter.Close()
+
+	addrs, err := adapter.Addresses()
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !slices.ContainsFunc(addrs, func(addr swiftypes.Address) bool { return addr.IPNet.String() == "10.166.1.2/24" }) {
+		t.Fatalf("expected 10.166.1.2/24 to be assigned, got %v", addrs)
+	}
+
+	routes, err := adapter.RouteList(netlink.FAMILY_V4)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !slices.ContainsFunc(routes, func(route netlink.Route) bool {
+		return route.Dst != nil && route.Dst.String() == "10.166.8.0/24"
+	}) {
+		t.Fatalf("expected a route to 10.166.8.0/24, got %v", routes)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if !strings.Contains(string(data), "nameserver 10.166.0.53\n") {
+		t.Fatalf("unexpected resolv.conf %q", data)
+	}
+}
@@ -0,0 +1,56 @@
+package swiftutils
+
+import (
+	"encoding/binary"
+)
+
+// Checksum adds the one's complement sum of data to initial and folds the result into 16 bits.
+// The returned value is not complemented, so it can be chained or stored as a partial checksum.
+func Checksum(data []byte, initial uint32) uint16 {
+	sum := uint64(initial)
+
+	for len(data) >= 2 {
+		sum += uint64(binary.BigEndian.Uint16(data))
+		data = data[2:]
+	}
+
+	if len(data) == 1 {
+		sum += uint64(data[0]) << 8
+	}
+
+	for sum>>16 > 0 {
+		sum = (sum & 0xFFFF) + (sum >> 16)
+	}
+
+	return uint16(sum)
+}
+
+// PseudoHeaderChecksum returns the unfolded sum of a TCP/UDP pseudo-header.
+// src and dst must both be 4-byte IPv4 or 16-byte IPv6 addresses.
+func PseudoHeaderChecksum(protocol uint8, src, dst []byte, length uint16) uint32 {
+	var sum uint32
+
+	for i := 0; i+1 < len(src); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(src[i:]))
+	}
+
+	for i := 0; i+1 < len(dst); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(dst[i:]))
+	}
+
+	sum += uint32(protocol)
+	sum += uint32(length)
+
+	return sum
+}
+
+// IPv4HeaderChecksum recomputes and stores the header checksum of an IPv4 packet.
+func IPv4HeaderChecksum(packet []byte) {
+	headerLength := IPv4HeaderLength(packet)
+	if headerLength < 20 || headerLength > len(packet) {
+		return
+	}
+
+	packet[10], packet[11] = 0, 0
+	binary.BigEndian.PutUint16(packet[10:], ^Checksum(packet[:headerLength], 0))
+}
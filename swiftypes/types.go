@@ -6,12 +6,41 @@ import (
 	"net"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // DNSConfig contains parameters for configuring an interface's DNS settings.
 type DNSConfig struct {
 	Domain     string
 	DnsServers []net.IP
+	// Upstreams are encrypted servers answering through the library's DNS forwarder instead of DnsServers.
+	Upstreams []DNSUpstream
+}
+
+// DNSUpstream describes a DNS-over-TLS or DNS-over-HTTPS server.
+type DNSUpstream struct {
+	// URL is "tls://host[:port]" for DNS over TLS, port 853 by default, or "https://host[:port]/path" for DNS over
+	// HTTPS, port 443 by default.
+	URL string
+	// Bootstrap are the addresses of a host given by name, so that reaching it needs no plaintext lookup.
+	Bootstrap []net.IP
+	// Pins are SHA-256 digests of the DER SubjectPublicKeyInfo of accepted certificates. A pinned leaf is trusted
+	// without a CA; otherwise the chain is verified against the system roots and must contain a pinned certificate.
+	Pins [][]byte
+}
+
+// Address describes an IP address assigned to an interface.
+type Address struct {
+	// IPNet holds the local address together with its prefix length.
+	IPNet *net.IPNet
+	// Peer is the remote address of a point-to-point link, if any.
+	Peer *net.IPNet
+	// NoDAD skips IPv6 duplicate address detection.
+	NoDAD bool
+	// PreferredLifetime is how long the address is used for new connections; zero follows ValidLifetime.
+	PreferredLifetime time.Duration
+	// ValidLifetime is how long the address stays assigned; zero means forever.
+	ValidLifetime time.Duration
 }
 
 var NilGUID = GUID{}
@@ -34,9 +63,39 @@ func (g DNSConfig) String() string {
 	for i, server := range g.DnsServers {
 		servers[i] = server.String()
 	}
+	if len(g.Upstreams) > 0 {
+		upstreams := make([]string, len(g.Upstreams))
+		for i, upstream := range g.Upstreams {
+			upstreams[i] = upstream.URL
+		}
+		return fmt.Sprintf("DNSConfig{Domain: %q, DnsServers: %v, Upstreams: %v}", g.Domain, servers, upstreams)
+	}
+
 	return fmt.Sprintf("DNSConfig{Domain: %q, DnsServers: %v}", g.Domain, servers)
 }
 
+// ParseAddress parses a CIDR string such as "10.0.0.1/24" into an Address, keeping the host part of the IP.
+func ParseAddress(cidr string) (*Address, error) {
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+
+	if ipv4 := ip.To4(); ipv4 != nil {
+		ip = ipv4
+	}
+
+	return &Address{IPNet: &net.IPNet{IP: ip, Mask: ipNet.Mask}}, nil
+}
+
+// String returns a formatted representation of the Address.
+func (a Address) String() string {
+	if a.Peer != nil {
+		return fmt.Sprintf("%v peer %v", a.IPNet, a.Peer)
+	}
+	return fmt.Sprintf("%v", a.IPNet)
+}
+
 // ToUint64 converts a Windows LUID structure into a single 64-bit integer.
 func (l LUID) ToUint64() uint64 {
 	return uint64(l.HighPart)<<32 + uint64(l.LowPart)
@@ -0,0 +1,241 @@
+//go:build linux
+
+package swiftunnel
+
+import (
+	"context"
+	"fmt"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+	"net"
+)
+
+// watchBufferSize is the number of events buffered before Watch stops reading netlink updates.
+const watchBufferSize = 64
+
+// Event is a change to the link, an address or a route of a SwiftInterface delivered by Watch.
+// It is one of LinkEvent, AddressEvent, RouteEvent or ErrorEvent.
+type Event interface {
+	isEvent()
+}
+
+// LinkEvent reports a change of the interface's link attributes, or its deletion.
+type LinkEvent struct {
+	Name    string
+	Index   int
+	MTU     int
+	Up      bool
+	Running bool
+	Deleted bool
+}
+
+// AddressEvent reports an address added to or removed from the interface.
+type AddressEvent struct {
+	Address           net.IPNet
+	Flags             int
+	Scope             int
+	PreferredLifetime int
+	ValidLifetime     int
+	Removed           bool
+}
+
+// RouteEvent reports a route through the interface being added or removed.
+type RouteEvent struct {
+	Route   netlink.Route
+	Removed bool
+}
+
+// ErrorEvent reports a failure of one of the underlying netlink subscriptions.
+type ErrorEvent struct {
+	Err error
+}
+
+func (LinkEvent) isEvent()    {}
+func (AddressEvent) isEvent() {}
+func (RouteEvent) isEvent()   {}
+func (ErrorEvent) isEvent()   {}
+
+// Watch subscribes to netlink link, address and route updates of the interface and delivers them as events.
+// The channel is closed once ctx is done or every subscription has ended.
+func (a *SwiftInterface) Watch(ctx context.Context) (<-chan Event, error) {
+	index, err := a.GetAdapterIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var ns *netns.NsHandle
+	if a.ns.IsOpen() {
+		ns = &a.ns
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	errs := make(chan error, 1)
+
+	abort := func() {
+		close(stopped)
+		close(done)
+	}
+
+	onError := func(err error) {
+		select {
+		case errs <- err:
+		case <-stopped:
+		}
+	}
+
+	links := make(chan netlink.LinkUpdate, watchBufferSize)
+	if err := netlink.LinkSubscribeWithOptions(links, done, netlink.LinkSubscribeOptions{
+		Namespace:     ns,
+		ErrorCallback: onError,
+	}); err != nil {
+		abort()
+		return nil, fmt.Errorf("failed to subscribe to link updates: %w", err)
+	}
+
+	addrs := make(chan netlink.AddrUpdate, watchBufferSize)
+	if err := netlink.AddrSubscribeWithOptions(addrs, done, netlink.AddrSubscribeOptions{
+		Namespace:     ns,
+		ErrorCallback: onError,
+	}); err != nil {
+		abort()
+		return nil, fmt.Errorf("failed to subscribe to address updates: %w", err)
+	}
+
+	routes := make(chan netlink.RouteUpdate, watchBufferSize)
+	if err := netlink.RouteSubscribeWithOptions(routes, done, netlink.RouteSubscribeOptions{
+		Namespace:     ns,
+		ErrorCallback: onError,
+	}); err != nil {
+		abort()
+		return nil, fmt.Errorf("failed to subscribe to route updates: %w", err)
+	}
+
+	events := make(chan Event, watchBufferSize)
+
+	go func() {
+		defer close(events)
+		defer drainUpdates(links, addrs, routes)
+		defer abort()
+
+		linkUpdates, addrUpdates, routeUpdates := links, addrs, routes
+
+		for linkUpdates != nil || addrUpdates != nil || routeUpdates != nil {
+			var event Event
+
+			select {
+			case <-ctx.Done():
+				return
+			case err := <-errs:
+				event = ErrorEvent{Err: err}
+			case update, ok := <-linkUpdates:
+				if !ok {
+					linkUpdates = nil
+					continue
+				}
+				event = linkEvent(index, update)
+			case update, ok := <-addrUpdates:
+				if !ok {
+					addrUpdates = nil
+					continue
+				}
+				event = addressEvent(index, update)
+			case update, ok := <-routeUpdates:
+				if !ok {
+					routeUpdates = nil
+					continue
+				}
+				event = routeEvent(index, update)
+			}
+
+			if event == nil {
+				continue
+			}
+
+			select {
+			case events <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// linkEvent converts a link update of the interface, returning nil for other links.
+func linkEvent(index int, update netlink.LinkUpdate) Event {
+	attrs := update.Attrs()
+	if attrs == nil || attrs.Index != index {
+		return nil
+	}
+
+	return LinkEvent{
+		Name:    attrs.Name,
+		Index:   attrs.Index,
+		MTU:     attrs.MTU,
+		Up:      attrs.Flags&net.FlagUp != 0,
+		Running: attrs.RawFlags&unix.IFF_RUNNING != 0,
+		Deleted: update.Header.Type == unix.RTM_DELLINK,
+	}
+}
+
+// addressEvent converts an address update of the interface, returning nil for other links.
+func addressEvent(index int, update netlink.AddrUpdate) Event {
+	if update.LinkIndex != index {
+		return nil
+	}
+
+	return AddressEvent{
+		Address:           update.LinkAddress,
+		Flags:             update.Flags,
+		Scope:             update.Scope,
+		PreferredLifetime: update.PreferedLft,
+		ValidLifetime:     update.ValidLft,
+		Removed:           !update.NewAddr,
+	}
+}
+
+// routeEvent converts an update of a route through the interface, returning nil for unrelated routes.
+func routeEvent(index int, update netlink.RouteUpdate) Event {
+	if !routeUsesLink(&update.Route, index) {
+		return nil
+	}
+
+	return RouteEvent{
+		Route:   update.Route,
+		Removed: update.Type == unix.RTM_DELROUTE,
+	}
+}
+
+// routeUsesLink reports whether the route or one of its multipath next hops leaves through the link.
+func routeUsesLink(route *netlink.Route, index int) bool {
+	if route.LinkIndex == index {
+		return true
+	}
+
+	for _, hop := range route.MultiPath {
+		if hop.LinkIndex == index {
+			return true
+		}
+	}
+
+	return false
+}
+
+// drainUpdates consumes pending updates in the background so that the subscription goroutines can exit.
+func drainUpdates(links chan netlink.LinkUpdate, addrs chan netlink.AddrUpdate, routes chan netlink.RouteUpdate) {
+	go func() {
+		for range links {
+		}
+	}()
+	go func() {
+		for range addrs {
+		}
+	}()
+	go func() {
+		for range routes {
+		}
+	}()
+}
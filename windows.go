@@ -29,9 +29,19 @@ type swiftService interface {
 	GetAdapterGUID() (swiftypes.GUID, error)
 }
 
+// batchService is implemented by drivers that can move several packets per call.
+type batchService interface {
+	ReadBatch(bufs [][]byte, sizes []int) (int, error)
+	WriteBatch(bufs [][]byte) (int, error)
+}
+
 // SwiftInterface provides a generic interface for Windows network tunnels.
 type SwiftInterface struct {
-	service swiftService
+	service  swiftService
+	counters ioCounters
+	changes  changeLog
+	// encryptedDNS is the configuration served by the forwarder setEncryptedDNS started, if it is running.
+	encryptedDNS *swiftypes.DNSConfig
 }
 
 // Write transmits a packet via the underlying Windows service.
@@ -39,7 +49,9 @@ func (a *SwiftInterface) Write(buf []byte) (int, error) {
 	if a.service == nil {
 		return 0, ErrCannotFindAdapter
 	}
-	return a.service.Write(buf)
+	n, err := a.service.Write(buf)
+	a.counters.countWrite(n, err)
+	return n, err
 }
 
 // Read receives a packet via the underlying Windows service.
@@ -47,15 +59,41 @@ func (a *SwiftInterface) Read(buf []byte) (int, error) {
 	if a.service == nil {
 		return 0, ErrCannotFindAdapter
 	}
-	return a.service.Read(buf)
+	n, err := a.service.Read(buf)
+	a.counters.countRead(n, err)
+	return n, err
+}
+
+// ReadBatch receives up to len(bufs) packets, draining the Wintun ring without blocking after the first packet.
+func (a *SwiftInterface) ReadBatch(bufs [][]byte, sizes []int) (n int, err error) {
+	if a.service == nil {
+		return 0, ErrCannotFindAdapter
+	}
+	defer func() { a.counters.countReadBatch(n, sizes, err) }()
+	if batch, ok := a.service.(batchService); ok {
+		return batch.ReadBatch(bufs, sizes)
+	}
+	return readBatch(a.service.Read, bufs, sizes)
+}
+
+// WriteBatch transmits each packet of bufs, returning the number of packets written.
+func (a *SwiftInterface) WriteBatch(bufs [][]byte) (n int, err error) {
+	if a.service == nil {
+		return 0, ErrCannotFindAdapter
+	}
+	defer func() { a.counters.countWriteBatch(n, bufs, err) }()
+	if batch, ok := a.service.(batchService); ok {
+		return batch.WriteBatch(bufs)
+	}
+	return writeBatch(a.service.Write, bufs)
 }
 
-// Close terminates the adapter session and releases driver resources.
+// Close reverts the recorded system changes, then terminates the adapter session and releases driver resources.
 func (a *SwiftInterface) Close() error {
 	if a.service == nil {
 		return nil
 	}
-	return a.service.Close()
+	return errors.Join(a.changes.revert(), a.service.Close())
 }
 
 // GetFD retrieves the file handle associated with the tunnel session.
@@ -68,15 +106,15 @@ func (a *SwiftInterface) GetFD() *os.File {
 
 // NewSwiftInterface creates a new adapter using either the Wintun or TAP-Windows driver.
 func NewSwiftInterface(config *swiftconfig.Config) (*SwiftInterface, error) {
-	if config == nil {
-		return nil, errors.New("config cannot be nil")
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
 
 	adapter := &SwiftInterface{}
 	var err error
 
 	switch config.DriverType {
-	case swiftconfig.DriverTypeWintun:
+	case swiftconfig.DriverTypeDefault, swiftconfig.DriverTypeWintun:
 		if config.AdapterType == swiftypes.AdapterTypeTAP {
 			return nil, errors.New("TAP adapter not supported on Wintun driver")
 		}
@@ -120,26 +158,3 @@ func NewSwiftInterface(config *swiftconfig.Config) (*SwiftInterface, error) {
 
 	return adapter, nil
 }
-
-// configureAdapter applies IP, MTU, and DNS settings to a newly created interface.
-func configureAdapter(adapter *SwiftInterface, config *swiftconfig.Config) error {
-	if config.UnicastConfig != nil {
-		if err := adapter.SetUnicastIpAddressEntry(config.UnicastConfig); err != nil {
-			return fmt.Errorf("failed to set IP address: %w", err)
-		}
-	}
-
-	if config.MTU > 0 {
-		if err := adapter.SetMTU(config.MTU); err != nil {
-			return fmt.Errorf("failed to set MTU: %w", err)
-		}
-	}
-
-	if config.DNSConfig != nil {
-		if err := adapter.SetDNS(config.DNSConfig); err != nil {
-			return fmt.Errorf("failed to set DNS: %w", err)
-		}
-	}
-
-	return nil
-}
@@ -51,6 +51,7 @@ var (
 	ErrEmptyPacket          = errors.New("packet cannot be empty")
 	ErrNoDataAvailable      = errors.New("no more data is available")
 	ErrBufferTooSmall       = errors.New("destination buffer is too small")
+	ErrBatchSizeMismatch    = errors.New("sizes must have at least as many entries as bufs")
 )
 
 // ensureDLL extracts the embedded Wintun DLL to a cache directory and loads it.
@@ -373,6 +374,50 @@ func (s *WintunSession) Read(buf []byte) (int, error) {
 	}
 }
 
+// ReadBatch blocks for the first packet and then drains whatever else is already queued in the ring buffer.
+func (s *WintunSession) ReadBatch(bufs [][]byte, sizes []int) (int, error) {
+	if len(sizes) < len(bufs) {
+		return 0, ErrBatchSizeMismatch
+	}
+
+	if len(bufs) == 0 {
+		return 0, nil
+	}
+
+	n, err := s.Read(bufs[0])
+	if err != nil {
+		return 0, err
+	}
+	sizes[0] = n
+
+	count := 1
+	for count < len(bufs) {
+		n, err := s.ReadNow(bufs[count])
+		if errors.Is(err, ErrNoDataAvailable) {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+
+		sizes[count] = n
+		count++
+	}
+
+	return count, nil
+}
+
+// WriteBatch queues every packet of bufs into the ring buffer, returning how many were sent.
+func (s *WintunSession) WriteBatch(bufs [][]byte) (int, error) {
+	for i, buf := range bufs {
+		if _, err := s.Write(buf); err != nil {
+			return i, err
+		}
+	}
+
+	return len(bufs), nil
+}
+
 // GetFD returns nil as Wintun uses a custom ring buffer rather than a standard file descriptor.
 func (s *WintunSession) GetFD() *os.File {
 	return s.adapter.GetFD()